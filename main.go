@@ -1,84 +1,249 @@
 package main
 
 import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	_ "image/jpeg" // YouTube thumbnails, for 'mfp art'
+	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode"
 )
 
 // Song represents a single song
 type Song struct {
-	Title    string `json:"title"`
-	VideoID  string `json:"video_id"`
-	Duration string `json:"duration"`
-	URL      string `json:"url"`
+	Title    string    `json:"title"`
+	VideoID  string    `json:"video_id"`
+	Duration string    `json:"duration"`
+	URL      string    `json:"url"`
+	Artist   string    `json:"artist,omitempty"`  // Artist when YouTube knows it, else the uploader
+	AddedAt  time.Time `json:"added_at,omitzero"` // When the song was added to its playlist
+	Skip     bool      `json:"skip,omitempty"`    // Always skipped during playback (skip-always)
+	Note     string    `json:"note,omitempty"`    // Personal note from 'mfp note'
+}
+
+// DisplayTitle returns "Artist – Title", or just the title when the artist
+// is unknown
+func (s Song) DisplayTitle() string {
+	if s.Artist == "" {
+		return s.Title
+	}
+	return s.Artist + " – " + s.Title
 }
 
 // Playlist represents a YouTube playlist
 type Playlist struct {
-	Name        string `json:"name"`
-	URL         string `json:"url"`
-	Songs       []Song `json:"songs"`
-	LastUpdated string `json:"last_updated"`
+	Name         string   `json:"name"`
+	URL          string   `json:"url"`
+	Songs        []Song   `json:"songs"`
+	LastUpdated  string   `json:"last_updated"`
+	Label        string   `json:"label,omitempty"` // Short emoji/label shown in listings
+	Tags         []string `json:"tags,omitempty"`
+	Order        string   `json:"order,omitempty"`         // Song order chosen at add time: original, reverse or shuffle
+	RefreshEvery string   `json:"refresh_every,omitempty"` // Interval for scheduled refreshes, e.g. "6h"
+	ListPosition int      `json:"list_position,omitempty"` // Place in 'mfp list', set by reorder; 0 sorts last
 }
 
 // PlayerState holds the current state of the music player
 type PlayerState struct {
-	CurrentPlaylist  string    `json:"current_playlist"`
-	CurrentSongIndex int       `json:"current_song_index"`
-	IsPlaying        bool      `json:"is_playing"`
-	IsShuffle        bool      `json:"is_shuffle"`
-	IsLoop           bool      `json:"is_loop"`
-	Volume           int       `json:"volume"`
-	ShuffleOrder     []int     `json:"shuffle_order"`
-	ShuffleIndex     int       `json:"shuffle_index"`
-	LastUpdated      time.Time `json:"last_updated"`
-	Position         int       `json:"position"` // Current position in seconds
+	CurrentPlaylist  string        `json:"current_playlist"`
+	CurrentSongIndex int           `json:"current_song_index"`
+	IsPlaying        bool          `json:"is_playing"`
+	IsShuffle        bool          `json:"is_shuffle"`
+	IsLoop           bool          `json:"is_loop"`
+	Volume           int           `json:"volume"`
+	ShuffleOrder     []int         `json:"shuffle_order"`
+	ShuffleIndex     int           `json:"shuffle_index"`
+	LastUpdated      time.Time     `json:"last_updated"`
+	Position         int           `json:"position"` // Current position in seconds
+	ReshuffleOnLoop  bool          `json:"reshuffle_on_loop"`
+	SingleSong       bool          `json:"single_song"`                // Stop after the current song instead of advancing
+	MpvArgs          []string      `json:"mpv_args,omitempty"`         // One-off 'mfp play --mpv-arg' arguments for this session
+	Session          *Playlist     `json:"session,omitempty"`          // Transient song list played instead of CurrentPlaylist's (e.g. a --from/--to range)
+	TempQueue        []Song        `json:"temp_queue,omitempty"`       // Songs appended to mpv after the playlist by queue-playlist, until playback stops
+	PinnedPlaylist   string        `json:"pinned_playlist,omitempty"`  // What a bare 'mfp play' starts instead of resuming CurrentPlaylist
+	LastSkip         time.Time     `json:"last_skip,omitzero"`         // When next/prev last moved mpv, for skipCooldown
+	PlayStarted      time.Time     `json:"play_started,omitzero"`      // When the last 'mfp play' started, for save-session
+	LoopCount        int           `json:"loop_count,omitempty"`       // Passes to play with loop on before stopping, 0 for no limit
+	LoopsDone        int           `json:"loops_done,omitempty"`       // Passes finished this session, for LoopCount
+	SessionVolume    *int          `json:"session_volume,omitempty"`   // 'mfp play --volume' for this session; Volume stays the default
+	PlayedSeconds    int           `json:"played_seconds,omitempty"`   // Unpaused playback this session, counted while a limit is set
+	PlayedAsOf       time.Time     `json:"played_as_of,omitzero"`      // When the daemon last counted PlayedSeconds
+	PlayLimitAt      int           `json:"play_limit_at,omitempty"`    // 'mfp limit': stop once PlayedSeconds reaches this, 0 for none
+	MirrorDevice     string        `json:"mirror_device,omitempty"`    // 'mfp mirror': audio device a second mpv plays along on
+	EqBass           int           `json:"eq_bass,omitempty"`          // Bass gain in dB, applied as an mpv audio filter
+	EqTreble         int           `json:"eq_treble,omitempty"`        // Treble gain in dB
+	Interjection     *Interjection `json:"interjection,omitempty"`     // 'mfp interject': one-off song playing in between, until playback resumes
+	PauseAfterSong   bool          `json:"pause_after_song,omitempty"` // 'mfp pause-after-track': pause when the current song ends
+}
+
+// Interjection is a one-off song from 'mfp interject', held in mpv's
+// playlist only while it plays; the song it interrupted resumes after it
+type Interjection struct {
+	Song       Song `json:"song"`
+	MpvPos     int  `json:"mpv_pos"`     // mpv playlist entry of the one-off song
+	ReturnPos  int  `json:"return_pos"`  // mpv playlist entry to go back to
+	ReturnTime int  `json:"return_time"` // Where in that song to resume, in seconds
+	Started    bool `json:"started,omitempty"`
+}
+
+// Settings holds user preferences changed with 'mfp config set'
+type Settings struct {
+	Cookies string `json:"cookies,omitempty"` // Netscape cookies file for restricted videos
+	Format  string `json:"format,omitempty"`  // yt-dlp format selector for streaming/downloads
+
+	// Executables run on player events, see runHook
+	OnSongChange string `json:"on_song_change,omitempty"`
+	OnPlay       string `json:"on_play,omitempty"`
+	OnStop       string `json:"on_stop,omitempty"`
+
+	MediaTitlePlaylist bool `json:"media_title_playlist,omitempty"` // Prefix mpv's media title with the playlist name
+	Notify             bool `json:"notify,omitempty"`               // Desktop notification on song change
+
+	MpvExtraArgs []string `json:"mpv_extra_args,omitempty"` // Passed to mpv after mfp's own arguments
+	M3UEntry     string   `json:"m3u_entry,omitempty"`      // Template for each song in the playlist file mpv gets
+	Fade         int      `json:"fade,omitempty"`           // Seconds to fade the volume in on play and out on stop
+
+	LyricsAPI string `json:"lyrics_api,omitempty"` // Base URL of an LRCLIB-compatible lyrics API
+	LyricsKey string `json:"lyrics_key,omitempty"` // Optional bearer token for the lyrics API
+
+	// History retention, 0 keeps everything
+	HistoryMax  int `json:"history_max,omitempty"`  // Most entries to keep
+	HistoryDays int `json:"history_days,omitempty"` // Drop entries older than this
+
+	PositionSaveInterval int `json:"position_save_interval,omitempty"` // Seconds between position saves while playing, 0 for the default
+
+	DefaultVolume *int `json:"default_volume,omitempty"` // Volume for 'mfp volume reset', 70 when unset
+
+	YtdlpRate string `json:"ytdlp_rate,omitempty"` // Most yt-dlp fetches per minute/hour across all mfp processes, e.g. "20/m"
+
+	QuietHours  string `json:"quiet_hours,omitempty"`  // Daily window like "22:00-07:00"
+	QuietVolume string `json:"quiet_volume,omitempty"` // Volume cap in quiet hours, or "pause"
+
+	SkipSilence    bool `json:"skip_silence,omitempty"`     // Seek past silent gaps inside songs
+	SkipSilenceMin int  `json:"skip_silence_min,omitempty"` // Seconds of silence before skipping, 0 for the default
+
+	CacheMaxMB int `json:"cache_max_mb,omitempty"` // Size cap for downloads and thumbnails, 0 for none
+}
+
+// DownloadRecord tracks one song's download so an interrupted 'mfp download'
+// can pick up where it stopped
+type DownloadRecord struct {
+	Status    string    `json:"status"` // "done" or "failed"
+	File      string    `json:"file,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// HistoryEntry records one song that started playing
+type HistoryEntry struct {
+	PlayedAt time.Time `json:"played_at"`
+	Playlist string    `json:"playlist"`
+	Title    string    `json:"title"`
+	Artist   string    `json:"artist,omitempty"`
+	VideoID  string    `json:"video_id"`
+}
+
+// defaultFormat is used when no (or an unsupported) format is configured
+const defaultFormat = "bestaudio/best"
+
+// allowedFormats lists the yt-dlp format selectors accepted by 'config set format'
+var allowedFormats = []string{
+	"bestaudio/best",
+	"bestaudio",
+	"bestaudio[ext=m4a]/bestaudio",
+	"bestaudio[ext=webm]/bestaudio",
+	"bestaudio[abr<=128]/bestaudio",
+	"bestaudio[abr<=64]/worstaudio",
+	"worstaudio",
+	"best",
 }
 
 // Config holds application configuration
 type Config struct {
-	DataDir    string
-	StateFile  string
-	SocketFile string
-	Playlists  map[string]*Playlist
-	State      *PlayerState
+	DataDir      string
+	StateFile    string
+	SocketFile   string
+	SettingsFile string
+	Playlists    map[string]*Playlist
+	State        *PlayerState
+	Settings     *Settings
+	Blacklist    map[string]string // Video IDs never to add or play, with their titles
+	ReadOnly     bool              // Data directory is not writable; changes are kept in memory only
+	Profile      string            // Name of the --profile in use, empty for the default one
 }
 
 var (
-	config      *Config
-	currentCmd  *exec.Cmd
-	quitChannel = make(chan bool)
-	skipChannel = make(chan bool)
+	config         *Config
+	currentCmd     *exec.Cmd
+	mpvExited      chan struct{} // Closed when the mpv started by this process exits
+	stateMu        sync.Mutex    // Serializes the daemon's monitor loop and signal-driven commands
+	quitChannel    = make(chan bool)
+	skipChannel    = make(chan bool)
+	readOnlyWarned bool
 )
 
 func main() {
 	// Initialize configuration
+	// --profile selects an independent set of playlists, state and player
+	cliArgs, profile, hasProfile := extractFlagValue(os.Args[1:], "--profile")
+	if hasProfile && !isValidProfileName(profile) {
+		fmt.Printf("Invalid profile name: %q\n", profile)
+		os.Exit(1)
+	}
+
+	// -v and -vv show what mfp does under the hood. They go before the
+	// command, so a note or title of "-v" is left alone.
+	for len(cliArgs) > 0 {
+		if cliArgs[0] == "-vv" {
+			verbosity = 2
+		} else if cliArgs[0] == "-v" {
+			verbosity = max(verbosity, 1)
+		} else {
+			break
+		}
+		cliArgs = cliArgs[1:]
+	}
+
 	var err error
-	config, err = initConfig()
+	config, err = initConfig(profile)
 	if err != nil {
 		log.Fatal("Failed to initialize config:", err)
 	}
 
 	// Handle command line arguments
-	if len(os.Args) < 2 {
+	if len(cliArgs) < 1 {
 		showHelp()
 		return
 	}
 
-	command := os.Args[1]
-	args := os.Args[2:]
+	command := cliArgs[0]
+	args := cliArgs[1:]
 
 	// Set up signal handling for graceful shutdown
 	setupSignalHandler()
@@ -91,15 +256,31 @@ func main() {
 	case "stop":
 		handleStop()
 	case "next":
-		handleNext()
+		handleNext(args)
 	case "prev", "previous":
-		handlePrevious()
+		handlePrevious(args)
 	case "current", "now":
-		handleCurrent()
+		handleCurrent(args)
+	case "schedule-view":
+		handleScheduleView(args)
+	case "time-left":
+		handleTimeLeft(args)
 	case "queue":
 		handleQueue(args)
+	case "queue-after":
+		handleQueueAfter(args)
+	case "queue-playlist":
+		handleQueuePlaylist(args)
+	case "interject":
+		handleInterject(args)
+	case "pause-after-track":
+		handlePauseAfterTrack(args)
+	case "random":
+		handleRandom()
 	case "jump":
 		handleJump(args)
+	case "continue-from":
+		handleContinueFrom(args)
 	case "shuffle":
 		handleShuffle(args)
 	case "loop":
@@ -109,30 +290,120 @@ func main() {
 	case "seek":
 		handleSeek(args)
 	case "list", "playlists":
-		handleListPlaylists()
+		handleListPlaylists(args)
 	case "songs":
 		handleListSongs(args)
+	case "refresh":
+		handleRefresh(args)
+	case "export":
+		handleExport(args)
+	case "recent-added":
+		handleRecentAdded(args)
+	case "schedule":
+		handleSchedule(args)
+	case "sort":
+		handleSortPlaylist(args)
+	case "dedupe":
+		handleDedupe(args)
+	case "trim-playlist":
+		handleTrimPlaylist(args)
 	case "rename":
 		handleRename(args)
+	case "note":
+		handleNote(args)
+	case "skip-always":
+		handleSkipAlways(args)
+	case "lyrics":
+		handleLyrics()
+	case "cache":
+		handleCache(args)
+	case "art":
+		handleArt(args)
+	case "problems":
+		handleProblems(args)
+	case "history":
+		handleHistory(args)
+	case "blacklist":
+		handleBlacklist(args)
+	case "url":
+		handleURL(args)
+	case "eq":
+		handleEq(args)
+	case "mirror":
+		handleMirror(args)
+	case "limit":
+		handleLimit(args)
+	case "skip-silence":
+		handleSkipSilence(args)
+	case "quiet-hours":
+		handleQuietHours(args)
+	case "events":
+		handleEvents()
+	case "download":
+		handleDownload(args)
+	case "import-spotify":
+		handleImportSpotify(args)
+	case "save-session":
+		handleSaveSession(args)
+	case "play-search":
+		handlePlaySearch(args)
+	case "sync":
+		handleSync(args)
+	case "repair":
+		handleRepair()
+	case "backup":
+		handleBackup(args)
+	case "restore":
+		handleRestore(args)
+	case "chapters":
+		handleChapters()
+	case "chapter":
+		handleChapter(args)
+	case "rename-song":
+		handleRenameSong(args)
 	case "delete", "remove":
 		handleDelete(args)
+	case "tag":
+		handleTag(args)
+	case "pin":
+		handlePin(args)
+	case "unpin":
+		handleUnpin()
+	case "reorder", "move-playlist":
+		handleReorder(args)
 	case "help", "-h", "--help":
-		showHelp()
+		handleHelp(args)
 	case "status":
-		handleStatus()
+		handleStatus(args)
+	case "debug":
+		handleDebug(args)
+	case "doctor":
+		handleDoctor()
+	case "config":
+		handleConfig(args)
+	case "daemon":
+		// Internal: started by 'play' to own mpv in the background
+		runDaemon()
+	case "serve":
+		handleServe(args)
+	case "pid":
+		handlePid()
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		showHelp()
 	}
 }
 
-func initConfig() (*Config, error) {
+func initConfig(profile string) (*Config, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return nil, err
 	}
 
 	dataDir := filepath.Join(homeDir, ".mfp")
+	if profile != "" {
+		dataDir = filepath.Join(dataDir, "profiles", profile)
+	}
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return nil, err
 	}
@@ -140,12 +411,23 @@ func initConfig() (*Config, error) {
 	stateFile := filepath.Join(dataDir, "state.json")
 	socketFile := filepath.Join(dataDir, "mpv-socket")
 	playlistsFile := filepath.Join(dataDir, "playlists.json")
+	settingsFile := filepath.Join(dataDir, "config.json")
+
+	// Fall back to a read-only session if we can't write to the data directory.
+	// mpv still needs somewhere to create its socket, so move it to the temp dir.
+	readOnly := checkDirWritable(dataDir) != nil
+	if readOnly {
+		socketFile = tempFilePath(profile, "mpv-socket")
+	}
 
 	config := &Config{
-		DataDir:    dataDir,
-		StateFile:  stateFile,
-		SocketFile: socketFile,
-		Playlists:  make(map[string]*Playlist),
+		DataDir:      dataDir,
+		StateFile:    stateFile,
+		SocketFile:   socketFile,
+		SettingsFile: settingsFile,
+		ReadOnly:     readOnly,
+		Profile:      profile,
+		Playlists:    make(map[string]*Playlist),
 		State: &PlayerState{
 			Volume:           70,
 			CurrentSongIndex: 0,
@@ -153,6 +435,7 @@ func initConfig() (*Config, error) {
 			ShuffleIndex:     0,
 			Position:         0,
 		},
+		Settings: &Settings{},
 	}
 
 	// Load existing playlists
@@ -165,17 +448,71 @@ func initConfig() (*Config, error) {
 		json.Unmarshal(data, config.State)
 	}
 
+	// Load user settings
+	if data, err := ioutil.ReadFile(settingsFile); err == nil {
+		json.Unmarshal(data, config.Settings)
+	}
+
+	// Load the blacklist
+	config.Blacklist = make(map[string]string)
+	if data, err := ioutil.ReadFile(filepath.Join(dataDir, "blacklist.json")); err == nil {
+		json.Unmarshal(data, &config.Blacklist)
+	}
+
 	return config, nil
 }
 
+// tempFilePath names a per-user, per-profile file in the temp dir, used when
+// the data directory can't be written to
+func tempFilePath(profile, name string) string {
+	if profile != "" {
+		name = profile + "-" + name
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("mfp-%d-%s", os.Getuid(), name))
+}
+
+// isValidProfileName allows names that are safe to use as a directory name
+func isValidProfileName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func saveSettings() error {
+	if config.ReadOnly {
+		warnReadOnly()
+		return nil
+	}
+
+	data, err := json.MarshalIndent(config.Settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(config.SettingsFile, data, 0644); err != nil {
+		return handleWriteError(err)
+	}
+	return nil
+}
+
 func saveConfig() error {
+	if config.ReadOnly {
+		warnReadOnly()
+		return nil
+	}
+
 	playlistsFile := filepath.Join(config.DataDir, "playlists.json")
 	data, err := json.MarshalIndent(config.Playlists, "", "  ")
 	if err != nil {
 		return err
 	}
 	if err := ioutil.WriteFile(playlistsFile, data, 0644); err != nil {
-		return err
+		return handleWriteError(err)
 	}
 
 	config.State.LastUpdated = time.Now()
@@ -183,7 +520,60 @@ func saveConfig() error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(config.StateFile, stateData, 0644)
+	if err := ioutil.WriteFile(config.StateFile, stateData, 0644); err != nil {
+		return handleWriteError(err)
+	}
+	return nil
+}
+
+// handleWriteError switches to read-only mode when a save fails because the
+// data directory can't be written, so the session keeps working in memory.
+func handleWriteError(err error) error {
+	if !isPermissionError(err) {
+		return err
+	}
+	config.ReadOnly = true
+	warnReadOnly()
+	return nil
+}
+
+func warnReadOnly() {
+	if readOnlyWarned {
+		return
+	}
+	readOnlyWarned = true
+	fmt.Printf("Warning: %s is not writable, changes will not be saved this session (run 'mfp doctor')\n", config.DataDir)
+}
+
+func isPermissionError(err error) bool {
+	return os.IsPermission(err) || errors.Is(err, syscall.EROFS)
+}
+
+// checkDirWritable verifies we can create files in dir
+func checkDirWritable(dir string) error {
+	file, err := ioutil.TempFile(dir, ".write-test-")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// verbosity is set by -v (1: mpv commands and player events) or -vv (2: also
+// every IPC request and response); the extra output goes to stderr, which the
+// daemon writes to daemon.log
+var verbosity int
+
+func debugf(level int, format string, args ...interface{}) {
+	if verbosity >= level {
+		fmt.Fprintf(os.Stderr, time.Now().Format("15:04:05")+" "+format+"\n", args...)
+	}
+}
+
+// debugIPC dumps an mpv IPC exchange at -vv
+func debugIPC(request string, response []byte) {
+	debugf(2, "ipc → %s", strings.TrimSpace(request))
+	debugf(2, "ipc ← %s", strings.TrimSpace(string(response)))
 }
 
 func setupSignalHandler() {
@@ -197,26 +587,93 @@ func setupSignalHandler() {
 }
 
 func cleanup() {
-	if currentCmd != nil && currentCmd.Process != nil {
-		// Send quit command to mpv
-		sendMpvCommand("quit")
-		currentCmd.Process.Kill()
+	// Commands like 'next' or 'queue' only talk to the daemon's mpv over the
+	// socket; being interrupted must not stop the music for them.
+	if !ownsMpvSession() {
+		return
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	// Send quit command to mpv
+	sendMpvCommand("quit")
+	currentCmd.Process.Kill()
+	if isCurrentDaemon() {
+		endSession()
 	}
+}
+
+// endSession resets the per-session state once the owner's mpv is gone and
+// removes the files that advertised it
+func endSession() {
+	reloadConfig()
 	config.State.IsPlaying = false
+	config.State.SessionVolume = nil
+	config.State.PlayLimitAt = 0
+	config.State.MirrorDevice = ""
+	config.State.Interjection = nil
+	config.State.PauseAfterSong = false
 	saveConfig()
-	// Clean up socket file
+	// Clean up socket and pid files
 	os.Remove(config.SocketFile)
+	os.Remove(eventsSocketPath())
+	os.Remove(daemonPidFile())
+}
+
+// ownsMpvSession reports whether this process started the running mpv
+func ownsMpvSession() bool {
+	return currentCmd != nil && currentCmd.Process != nil
+}
+
+// reloadConfig re-reads playlists and state from disk so a long-running
+// daemon picks up changes made by other mfp commands before saving its own
+func reloadConfig() {
+	if config.ReadOnly {
+		return
+	}
+
+	playlists := make(map[string]*Playlist)
+	if data, err := ioutil.ReadFile(filepath.Join(config.DataDir, "playlists.json")); err == nil {
+		if json.Unmarshal(data, &playlists) == nil {
+			config.Playlists = playlists
+		}
+	}
+
+	state := &PlayerState{}
+	if data, err := ioutil.ReadFile(config.StateFile); err == nil {
+		if json.Unmarshal(data, state) == nil {
+			config.State = state
+		}
+	}
+
+	settings := &Settings{}
+	if data, err := ioutil.ReadFile(config.SettingsFile); err == nil {
+		if json.Unmarshal(data, settings) == nil {
+			config.Settings = settings
+		}
+	}
+
+	config.Blacklist = loadBlacklist()
 }
 
 func handleAdd(args []string) {
+	args, order, _ := extractFlagValue(args, "--order")
 	if len(args) < 2 {
-		fmt.Println("Usage: mfp add <playlist_name> <youtube_playlist_url>")
+		fmt.Println("Usage: mfp add <playlist_name> <youtube_playlist_url> [--order original|reverse|shuffle]")
 		return
 	}
 
 	name := args[0]
 	url := args[1]
 
+	if order == "" {
+		order = "original"
+	}
+	if !isValidOrder(order) {
+		fmt.Println("Error: --order must be one of original, reverse, shuffle")
+		return
+	}
+
 	// Validate YouTube playlist URL
 	if !isValidPlaylistURL(url) {
 		fmt.Println("Error: Invalid YouTube playlist URL")
@@ -232,18 +689,40 @@ func handleAdd(args []string) {
 		return
 	}
 
+	// The same YouTube playlist under another name is usually a mistake
+	if existing := playlistsWithID(playlistID, name); len(existing) > 0 {
+		fmt.Printf("Warning: '%s' already has this YouTube playlist\n", existing[0])
+		if isTerminal(os.Stdin) && confirm(fmt.Sprintf("Refresh '%s' instead of adding a copy?", existing[0])) {
+			handleRefresh([]string{existing[0]})
+			return
+		}
+	}
+
 	// Fetch playlist information using yt-dlp
-	songs, err := fetchPlaylistSongs(playlistID)
+	songs, err := fetchPlaylistSongs(playlistID, order)
 	if err != nil {
 		fmt.Printf("Error fetching playlist: %v\n", err)
 		return
 	}
 
+	now := time.Now()
+	for i := range songs {
+		songs[i].AddedAt = now
+	}
+
 	playlist := &Playlist{
-		Name:        name,
-		URL:         url,
-		Songs:       songs,
-		LastUpdated: time.Now().Format("2006-01-02 15:04:05"),
+		Name:         name,
+		URL:          url,
+		Songs:        songs,
+		LastUpdated:  now.Format("2006-01-02 15:04:05"),
+		Order:        order,
+		ListPosition: nextListPosition(),
+	}
+	// Adding over an existing playlist keeps its place and labels
+	if old, exists := config.Playlists[name]; exists {
+		playlist.ListPosition = old.ListPosition
+		playlist.Label = old.Label
+		playlist.Tags = old.Tags
 	}
 
 	config.Playlists[name] = playlist
@@ -255,393 +734,583 @@ func handleAdd(args []string) {
 	fmt.Printf("Successfully added playlist '%s' with %d songs\n", name, len(songs))
 }
 
-func handleStop() {
-	if currentCmd != nil && currentCmd.Process != nil {
-		// Send quit command to mpv first for graceful shutdown
-		sendMpvCommand("quit")
-
-		// Wait a moment for graceful shutdown
-		time.Sleep(100 * time.Millisecond)
+func handleRefresh(args []string) {
+	args, dryRun := extractFlag(args, "--dry-run")
+	if len(args) == 0 {
+		fmt.Println("Usage: mfp refresh <playlist_name> [--dry-run]")
+		return
+	}
 
-		// Force kill if still running
-		if currentCmd.Process != nil {
-			currentCmd.Process.Kill()
-		}
-		currentCmd = nil
+	playlistName := args[0]
+	playlist, exists := config.Playlists[playlistName]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", playlistName)
+		return
 	}
 
-	config.State.IsPlaying = false
-	config.State.Position = 0
-	saveConfig()
+	fmt.Printf("Refreshing playlist '%s'...\n", playlistName)
 
-	// Clean up socket file
-	os.Remove(config.SocketFile)
+	fetched, err := fetchForRefresh(playlist)
+	if err != nil {
+		fmt.Printf("Error fetching playlist: %v\n", err)
+		return
+	}
 
-	fmt.Println("Playback stopped")
-}
+	songs := fetched
+	if playlist.Order == "shuffle" {
+		songs = mergeShuffledSongs(playlist.Songs, fetched)
+	}
+	added, removed := diffSongs(playlist.Songs, songs)
 
-func handleNext() {
-	if !config.State.IsPlaying {
-		fmt.Println("No music is currently playing")
+	for _, song := range added {
+		fmt.Println(colorize("32", "  + "+song.DisplayTitle()))
+	}
+	for _, song := range removed {
+		fmt.Println(colorize("31", "  - "+song.DisplayTitle()))
+	}
+	if dryRun {
+		fmt.Printf("Dry run: '%s' would have %d songs (+%d, -%d), nothing was changed\n", playlistName, len(songs), len(added), len(removed))
 		return
 	}
 
-	// Update our internal state first
-	playlist := config.Playlists[config.State.CurrentPlaylist]
-	if playlist != nil {
-		if config.State.IsShuffle {
-			config.State.ShuffleIndex++
-			if config.State.ShuffleIndex >= len(config.State.ShuffleOrder) {
-				if config.State.IsLoop {
-					config.State.ShuffleIndex = 0
-				} else {
-					handleStop()
-					return
-				}
-			}
-		} else {
-			config.State.CurrentSongIndex++
-			if config.State.CurrentSongIndex >= len(playlist.Songs) {
-				if config.State.IsLoop {
-					config.State.CurrentSongIndex = 0
-				} else {
-					handleStop()
-					return
-				}
-			}
+	if interjectionBlocksEdit(playlistName) {
+		return
+	}
+	keepAddedAt(playlist, songs)
+	keepNotes(playlist, songs)
+
+	// Match old songs to new ones by ID, in order when a song is in twice
+	newIndices := make(map[string][]int)
+	for i, song := range songs {
+		newIndices[song.VideoID] = append(newIndices[song.VideoID], i)
+	}
+	oldToNew := make([]int, len(playlist.Songs))
+	for i, song := range playlist.Songs {
+		oldToNew[i] = -1
+		if indices := newIndices[song.VideoID]; len(indices) > 0 {
+			oldToNew[i] = indices[0]
+			newIndices[song.VideoID] = indices[1:]
 		}
 	}
 
-	// Force skip to next song immediately
-	sendMpvCommand("playlist-next")
-	saveConfig()
-	fmt.Println("Skipping to next song...")
-}
+	playlist.Songs = songs
+	playlist.LastUpdated = time.Now().Format("2006-01-02 15:04:05")
+	carryOverPlayback(playlistName, oldToNew)
 
-func handlePrevious() {
-	if !config.State.IsPlaying {
-		fmt.Println("No music is currently playing")
+	if err := saveConfig(); err != nil {
+		fmt.Printf("Error saving playlist: %v\n", err)
 		return
 	}
 
-	// Update our internal state first
-	playlist := config.Playlists[config.State.CurrentPlaylist]
-	if playlist != nil {
-		if config.State.IsShuffle {
-			config.State.ShuffleIndex--
-			if config.State.ShuffleIndex < 0 {
-				if config.State.IsLoop {
-					config.State.ShuffleIndex = len(config.State.ShuffleOrder) - 1
-				} else {
-					config.State.ShuffleIndex = 0
-				}
-			}
+	fmt.Printf("Refreshed playlist '%s': %d songs (+%d, -%d)\n", playlistName, len(songs), len(added), len(removed))
+}
+
+// keepAddedAt carries each song's AddedAt over from the playlist's current
+// songs into a refreshed list; songs that are new to the playlist get now
+func keepAddedAt(playlist *Playlist, songs []Song) {
+	addedAt := make(map[string]time.Time)
+	for _, song := range playlist.Songs {
+		addedAt[song.VideoID] = songAddedAt(playlist, song)
+	}
+	now := time.Now()
+	for i := range songs {
+		if t, ok := addedAt[songs[i].VideoID]; ok {
+			songs[i].AddedAt = t
 		} else {
-			config.State.CurrentSongIndex--
-			if config.State.CurrentSongIndex < 0 {
-				if config.State.IsLoop {
-					config.State.CurrentSongIndex = len(playlist.Songs) - 1
-				} else {
-					config.State.CurrentSongIndex = 0
-				}
-			}
+			songs[i].AddedAt = now
 		}
 	}
-
-	// Force skip to previous song immediately
-	sendMpvCommand("playlist-prev")
-	saveConfig()
-	fmt.Println("Going to previous song...")
 }
 
-func handleQueue(args []string) {
-	if config.State.CurrentPlaylist == "" {
-		fmt.Println("No playlist is currently loaded")
-		return
+// keepNotes carries notes over into a refreshed list of songs
+func keepNotes(playlist *Playlist, songs []Song) {
+	notes := make(map[string]string)
+	for _, song := range playlist.Songs {
+		if song.Note != "" {
+			notes[song.VideoID] = song.Note
+		}
+	}
+	for i := range songs {
+		songs[i].Note = notes[songs[i].VideoID]
 	}
+}
 
-	playlist := config.Playlists[config.State.CurrentPlaylist]
-	if playlist == nil {
-		fmt.Println("Current playlist not found")
-		return
+// songAddedAt returns when song was added, falling back to the playlist's
+// last update for songs saved before AddedAt was recorded
+func songAddedAt(playlist *Playlist, song Song) time.Time {
+	if !song.AddedAt.IsZero() {
+		return song.AddedAt
 	}
+	t, _ := time.ParseInLocation("2006-01-02 15:04:05", playlist.LastUpdated, time.Local)
+	return t
+}
 
-	showCount := 5
+func handleRecentAdded(args []string) {
+	count := 10
 	if len(args) > 0 {
-		if count, err := strconv.Atoi(args[0]); err == nil && count > 0 {
-			showCount = count
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			fmt.Println("Usage: mfp recent-added [count]")
+			return
 		}
+		count = n
 	}
 
-	currentIndex := getCurrentSongIndex()
-	fmt.Printf("Queue for playlist '%s':\n\n", config.State.CurrentPlaylist)
+	type addedSong struct {
+		song     Song
+		playlist string
+		addedAt  time.Time
+	}
 
-	// Show previous songs
-	fmt.Println("Previous:")
-	start := currentIndex - showCount
-	if start < 0 {
-		start = 0
+	var names []string
+	for name := range config.Playlists {
+		names = append(names, name)
 	}
-	for i := start; i < currentIndex; i++ {
-		realIndex := i
-		if config.State.IsShuffle && i < len(config.State.ShuffleOrder) {
-			realIndex = config.State.ShuffleOrder[i]
-		}
-		if realIndex < len(playlist.Songs) {
-			fmt.Printf("  %d. %s\n", i+1, playlist.Songs[realIndex].Title)
+	sort.Strings(names)
+
+	var songs []addedSong
+	for _, name := range names {
+		playlist := config.Playlists[name]
+		for _, song := range playlist.Songs {
+			songs = append(songs, addedSong{song, name, songAddedAt(playlist, song)})
 		}
 	}
+	if len(songs) == 0 {
+		fmt.Println("No songs found. Add a playlist with: mfp add <name> <url>")
+		return
+	}
 
-	// Show current song
-	if currentIndex < len(playlist.Songs) {
-		realIndex := currentIndex
-		if config.State.IsShuffle && currentIndex < len(config.State.ShuffleOrder) {
-			realIndex = config.State.ShuffleOrder[currentIndex]
-		}
-		if realIndex < len(playlist.Songs) {
-			status := "▶"
-			if !config.State.IsPlaying {
-				status = "⏸"
-			}
-			fmt.Printf("\n%s %d. %s (NOW PLAYING)\n\n", status, currentIndex+1, playlist.Songs[realIndex].Title)
-		}
+	sort.SliceStable(songs, func(i, j int) bool {
+		return songs[i].addedAt.After(songs[j].addedAt)
+	})
+	if count > len(songs) {
+		count = len(songs)
 	}
 
-	// Show next songs
-	fmt.Println("Next:")
-	end := currentIndex + showCount + 1
-	if end > len(playlist.Songs) {
-		end = len(playlist.Songs)
+	fmt.Println("Recently added songs:")
+	for _, s := range songs[:count] {
+		fmt.Printf("  %s  %s [%s]\n", s.addedAt.Format("2006-01-02 15:04"), s.song.DisplayTitle(), s.playlist)
 	}
-	for i := currentIndex + 1; i < end; i++ {
-		realIndex := i
-		if config.State.IsShuffle && i < len(config.State.ShuffleOrder) {
-			realIndex = config.State.ShuffleOrder[i]
-		}
-		if realIndex < len(playlist.Songs) {
-			fmt.Printf("  %d. %s\n", i+1, playlist.Songs[realIndex].Title)
-		}
+}
+
+// fetchForRefresh re-fetches a saved playlist's songs from YouTube. A shuffled
+// order was fixed at add time, so those are fetched in the original order
+// and merged against the existing songs by the caller.
+func fetchForRefresh(playlist *Playlist) ([]Song, error) {
+	playlistID := extractPlaylistID(playlist.URL)
+	if playlistID == "" {
+		return nil, fmt.Errorf("could not extract playlist ID from URL")
+	}
+
+	fetchOrder := playlist.Order
+	if fetchOrder == "shuffle" {
+		fetchOrder = "original"
 	}
+	return fetchPlaylistSongs(playlistID, fetchOrder)
 }
 
-func handleJump(args []string) {
+func handleSchedule(args []string) {
 	if len(args) == 0 {
-		fmt.Println("Usage: mfp jump <song_number>")
+		fmt.Println("Usage: mfp schedule refresh <playlist_name> --every <interval>")
+		fmt.Println("       mfp schedule list")
+		fmt.Println("       mfp schedule remove <playlist_name>")
 		return
 	}
 
-	if config.State.CurrentPlaylist == "" {
-		fmt.Println("No playlist is currently loaded")
-		return
-	}
+	switch args[0] {
+	case "refresh":
+		rest, every, _ := extractFlagValue(args[1:], "--every")
+		if len(rest) != 1 || every == "" {
+			fmt.Println("Usage: mfp schedule refresh <playlist_name> --every <interval>")
+			return
+		}
+		playlist, exists := config.Playlists[rest[0]]
+		if !exists {
+			fmt.Printf("Playlist '%s' not found\n", rest[0])
+			return
+		}
+		if _, err := parseRefreshInterval(every); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		playlist.RefreshEvery = every
+		saveConfig()
+		fmt.Printf("Playlist '%s' will be refreshed every %s while mfp is playing\n", rest[0], every)
 
-	playlist := config.Playlists[config.State.CurrentPlaylist]
-	if playlist == nil {
-		fmt.Println("Current playlist not found")
-		return
+	case "list":
+		var names []string
+		for _, name := range playlistNames() {
+			if config.Playlists[name].RefreshEvery != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			fmt.Println("No scheduled refreshes")
+			return
+		}
+		fmt.Println("Scheduled refreshes:")
+		for _, name := range names {
+			playlist := config.Playlists[name]
+			fmt.Printf("  %s: every %s (last updated %s)\n", name, playlist.RefreshEvery, playlist.LastUpdated)
+		}
+
+	case "remove":
+		if len(args) != 2 {
+			fmt.Println("Usage: mfp schedule remove <playlist_name>")
+			return
+		}
+		playlist, exists := config.Playlists[args[1]]
+		if !exists {
+			fmt.Printf("Playlist '%s' not found\n", args[1])
+			return
+		}
+		if playlist.RefreshEvery == "" {
+			fmt.Printf("Playlist '%s' has no scheduled refresh\n", args[1])
+			return
+		}
+		playlist.RefreshEvery = ""
+		saveConfig()
+		fmt.Printf("Removed scheduled refresh for '%s'\n", args[1])
+
+	default:
+		fmt.Printf("Unknown schedule command: %s\n", args[0])
+		fmt.Println("Usage: mfp schedule refresh|list|remove")
 	}
+}
 
-	songNum, err := strconv.Atoi(args[0])
-	if err != nil || songNum < 1 || songNum > len(playlist.Songs) {
-		fmt.Printf("Invalid song number. Please use 1-%d\n", len(playlist.Songs))
-		return
+// parseRefreshInterval accepts Go durations ("90m", "6h") plus whole days
+// ("2d"). Anything under 15 minutes is rejected to go easy on YouTube.
+func parseRefreshInterval(value string) (time.Duration, error) {
+	var interval time.Duration
+	if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && strings.HasSuffix(value, "d") {
+		interval = time.Duration(days) * 24 * time.Hour
+	} else if interval, err = time.ParseDuration(value); err != nil {
+		return 0, fmt.Errorf("invalid interval %q, use e.g. 30m, 6h or 1d", value)
 	}
+	if interval < 15*time.Minute {
+		return 0, fmt.Errorf("interval must be at least 15m")
+	}
+	return interval, nil
+}
 
-	// Convert to 0-based index
-	targetIndex := songNum - 1
+// startRefreshScheduler runs the scheduled playlist refreshes from the
+// daemon, checking once a minute for any that are due
+func startRefreshScheduler() {
+	go func() {
+		// New songs are also queued in mpv, so wait for its socket first
+		waitForSocket()
+		for {
+			runDueRefreshes()
+			time.Sleep(time.Minute)
+		}
+	}()
+}
 
-	if config.State.IsShuffle {
-		// Find the shuffle index that corresponds to this song
-		for i, shuffledIndex := range config.State.ShuffleOrder {
-			if shuffledIndex == targetIndex {
-				config.State.ShuffleIndex = i
+// waitForSocket waits up to 10 seconds for mpv's IPC socket to appear
+func waitForSocket() bool {
+	for i := 0; i < 20; i++ {
+		if _, err := os.Stat(config.SocketFile); err == nil {
+			return true
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return false
+}
+
+// fadeIn ramps mpv's volume from 0 (where startMpv leaves it when fading) up
+// to target over the configured fade time. A skip to another song ends the
+// fade at full volume; a volume change by the user ends it where it is.
+func fadeIn(target int) {
+	if !waitForSocket() {
+		return
+	}
+	startPos := getMpvPlaylistPosition()
+	steps := config.Settings.Fade * 10
+	volume := 0
+	for i := 1; i <= steps; i++ {
+		if i%5 == 0 {
+			if pos := getMpvPlaylistPosition(); pos != startPos {
 				break
 			}
+			if current, ok := getMpvFloatProperty("volume"); ok && int(current) != volume {
+				return
+			}
 		}
-	} else {
-		config.State.CurrentSongIndex = targetIndex
+		volume = target * i / steps
+		sendMpvCommand(fmt.Sprintf("set volume %d", volume))
+		time.Sleep(100 * time.Millisecond)
 	}
+	sendMpvCommand(fmt.Sprintf("set volume %d", target))
+}
 
-	if config.State.IsPlaying {
-		// Jump to the song in mpv playlist
-		sendMpvCommand(fmt.Sprintf("set playlist-pos %d", targetIndex))
+// fadeOut ramps mpv's volume down to 0 over the configured fade time
+func fadeOut() {
+	current, ok := getMpvFloatProperty("volume")
+	if !ok {
+		return
+	}
+	steps := config.Settings.Fade * 10
+	for i := 1; i <= steps; i++ {
+		sendMpvCommand(fmt.Sprintf("set volume %d", int(current)*(steps-i)/steps))
+		time.Sleep(100 * time.Millisecond)
 	}
-
-	fmt.Printf("Jumped to song %d: %s\n", songNum, playlist.Songs[targetIndex].Title)
-	saveConfig()
 }
 
-func handleShuffle(args []string) {
-	if len(args) == 0 {
-		// Toggle shuffle
-		config.State.IsShuffle = !config.State.IsShuffle
-	} else {
-		switch strings.ToLower(args[0]) {
-		case "on", "true", "1":
-			config.State.IsShuffle = true
-		case "off", "false", "0":
-			config.State.IsShuffle = false
-		default:
-			fmt.Println("Usage: mfp shuffle [on|off]")
-			return
+func runDueRefreshes() {
+	stateMu.Lock()
+	reloadConfig()
+	due := make(map[string]Playlist)
+	for name, playlist := range config.Playlists {
+		if playlist.RefreshEvery == "" {
+			continue
+		}
+		interval, err := parseRefreshInterval(playlist.RefreshEvery)
+		if err != nil {
+			continue
+		}
+		last, err := time.ParseInLocation("2006-01-02 15:04:05", playlist.LastUpdated, time.Local)
+		if err != nil || time.Since(last) >= interval {
+			due[name] = *playlist
 		}
 	}
+	stateMu.Unlock()
 
-	if config.State.IsShuffle {
-		initShuffleOrder()
-		if config.State.IsPlaying {
-			sendMpvCommand("set shuffle yes")
+	// Fetch without holding the lock so playback tracking isn't held up
+	for name, playlist := range due {
+		fetched, err := fetchForRefresh(&playlist)
+		if err != nil {
+			fmt.Printf("Scheduled refresh of '%s' failed: %v\n", name, err)
+			continue
 		}
-		fmt.Println("Shuffle: ON")
-	} else {
-		if config.State.IsPlaying {
-			sendMpvCommand("set shuffle no")
+
+		stateMu.Lock()
+		reloadConfig()
+		if current, exists := config.Playlists[name]; exists {
+			added := appendNewSongs(name, current, fetched)
+			saveConfig()
+			fmt.Printf("Scheduled refresh of '%s': %d new songs\n", name, added)
 		}
-		fmt.Println("Shuffle: OFF")
+		stateMu.Unlock()
 	}
-
-	saveConfig()
 }
 
-func handleLoop(args []string) {
-	if len(args) == 0 {
-		// Toggle loop
-		config.State.IsLoop = !config.State.IsLoop
-	} else {
-		switch strings.ToLower(args[0]) {
-		case "on", "true", "1":
-			config.State.IsLoop = true
-		case "off", "false", "0":
-			config.State.IsLoop = false
-		default:
-			fmt.Println("Usage: mfp loop [on|off]")
-			return
-		}
+// appendNewSongs adds the fetched songs that aren't in the playlist yet to
+// its end, leaving existing songs in place so a playing playlist's indices
+// stay valid. New songs are appended to mpv's playlist too when playing.
+func appendNewSongs(name string, playlist *Playlist, fetched []Song) int {
+	existing := make(map[string]bool)
+	for _, song := range playlist.Songs {
+		existing[song.VideoID] = true
 	}
 
-	if config.State.IsLoop {
-		if config.State.IsPlaying {
-			sendMpvCommand("set loop-playlist inf")
+	isCurrent := config.State.CurrentPlaylist == name && config.State.Session == nil
+	added := 0
+	for _, song := range fetched {
+		if existing[song.VideoID] {
+			continue
 		}
-		fmt.Println("Loop: ON")
-	} else {
-		if config.State.IsPlaying {
-			sendMpvCommand("set loop-playlist no")
+		existing[song.VideoID] = true
+		song.AddedAt = time.Now()
+		playlist.Songs = append(playlist.Songs, song)
+		added++
+
+		if isCurrent {
+			if config.State.IsShuffle {
+				config.State.ShuffleOrder = append(config.State.ShuffleOrder, len(playlist.Songs)-1)
+			}
+			// With queue-playlist songs at the end this would misplace it;
+			// it's picked up on the next play instead
+			if config.State.IsPlaying && len(config.State.TempQueue) == 0 {
+				sendMpvCommandArgs("loadfile", song.URL, "append")
+			}
 		}
-		fmt.Println("Loop: OFF")
 	}
-
-	saveConfig()
+	playlist.LastUpdated = time.Now().Format("2006-01-02 15:04:05")
+	return added
 }
 
-func handleVolume(args []string) {
-	if len(args) == 0 {
-		fmt.Printf("Current volume: %d%%\n", config.State.Volume)
+func handleExport(args []string) {
+	args, fromValue, _ := extractFlagValue(args, "--from")
+	args, toValue, _ := extractFlagValue(args, "--to")
+	if len(args) < 2 {
+		fmt.Println("Usage: mfp export <playlist_name> <file.m3u> [--from N] [--to M]")
 		return
 	}
 
-	switch args[0] {
-	case "up", "+":
-		config.State.Volume += 10
-		if config.State.Volume > 100 {
-			config.State.Volume = 100
-		}
-	case "down", "-":
-		config.State.Volume -= 10
-		if config.State.Volume < 0 {
-			config.State.Volume = 0
-		}
-	default:
-		if vol, err := strconv.Atoi(args[0]); err == nil {
-			if vol >= 0 && vol <= 100 {
-				config.State.Volume = vol
-			} else {
-				fmt.Println("Volume must be between 0 and 100")
-				return
-			}
-		} else {
-			fmt.Println("Usage: mfp volume [up|down|<0-100>]")
-			return
-		}
+	playlistName := args[0]
+	playlist, exists := config.Playlists[playlistName]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", playlistName)
+		return
 	}
 
-	// Set volume in mpv if playing
-	if config.State.IsPlaying {
-		sendMpvCommand(fmt.Sprintf("set volume %d", config.State.Volume))
+	start, end, err := parseRange(fromValue, toValue, len(playlist.Songs))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
 
-	fmt.Printf("Volume set to: %d%%\n", config.State.Volume)
-	saveConfig()
+	if err := writeM3U(playlist.Songs[start:end], args[1]); err != nil {
+		fmt.Printf("Error exporting playlist: %v\n", err)
+		return
+	}
+	fmt.Printf("Exported %d songs from '%s' to %s\n", end-start, playlistName, args[1])
 }
 
-func handleSeek(args []string) {
-	if len(args) == 0 {
-		fmt.Println("Usage: mfp seek [+|-]<seconds>")
+func handleSortPlaylist(args []string) {
+	args, by, _ := extractFlagValue(args, "--by")
+	args, desc := extractFlag(args, "--desc")
+	if len(args) != 1 || by == "" {
+		fmt.Println("Usage: mfp sort <playlist_name> --by title|duration|added [--desc]")
 		return
 	}
 
-	if !config.State.IsPlaying {
-		fmt.Println("No music is currently playing")
+	playlistName := args[0]
+	playlist, exists := config.Playlists[playlistName]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", playlistName)
 		return
 	}
 
-	seekArg := args[0]
-	var seekSeconds int
-	var err error
-	var relative bool
+	var less func(a, b Song) bool
+	switch by {
+	case "title":
+		less = func(a, b Song) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) }
+	case "duration":
+		less = func(a, b Song) bool {
+			da, _ := durationSeconds(a.Duration)
+			db, _ := durationSeconds(b.Duration)
+			return da < db
+		}
+	case "added":
+		less = func(a, b Song) bool { return songAddedAt(playlist, a).Before(songAddedAt(playlist, b)) }
+	default:
+		fmt.Println("Error: --by must be one of title, duration, added")
+		return
+	}
 
-	if strings.HasPrefix(seekArg, "+") || strings.HasPrefix(seekArg, "-") {
-		relative = true
-		seekSeconds, err = strconv.Atoi(seekArg[1:])
-		if strings.HasPrefix(seekArg, "-") {
-			seekSeconds = -seekSeconds
+	if interjectionBlocksEdit(playlistName) {
+		return
+	}
+
+	// Sort the song indices, so each song's old and new place are known
+	order := make([]int, len(playlist.Songs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := playlist.Songs[order[i]], playlist.Songs[order[j]]
+		// Songs of unknown length go last either way
+		if by == "duration" {
+			_, knownA := durationSeconds(a.Duration)
+			_, knownB := durationSeconds(b.Duration)
+			if knownA != knownB {
+				return knownA
+			}
 		}
-	} else {
-		seekSeconds, err = strconv.Atoi(seekArg)
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	songs := make([]Song, len(order))
+	oldToNew := make([]int, len(order))
+	for i, old := range order {
+		songs[i] = playlist.Songs[old]
+		oldToNew[old] = i
 	}
+	playlist.Songs = songs
+	carryOverPlayback(playlistName, oldToNew)
 
-	if err != nil {
-		fmt.Println("Invalid seek value")
+	if err := saveConfig(); err != nil {
+		fmt.Printf("Error saving playlist: %v\n", err)
 		return
 	}
 
-	if relative {
-		sendMpvCommand(fmt.Sprintf("seek %d", seekSeconds))
-		if seekSeconds > 0 {
-			fmt.Printf("Seeking forward %d seconds\n", seekSeconds)
-		} else {
-			fmt.Printf("Seeking backward %d seconds\n", -seekSeconds)
+	direction := "ascending"
+	if desc {
+		direction = "descending"
+	}
+	fmt.Printf("Sorted playlist '%s' by %s (%s)\n", playlistName, by, direction)
+}
+
+// titleNoisePattern matches the bracketed extras that differ between uploads
+// of the same song, like "(Official Video)" or "[Lyrics]"
+var titleNoisePattern = regexp.MustCompile(`(?i)[(\[][^)\]]*\b(official|lyrics?|audio|video|visuali[sz]er|hd|hq|4k|mv|m/v)\b[^)\]]*[)\]]`)
+
+// fuzzyDurationSlack is how far apart two uploads of one song may run
+const fuzzyDurationSlack = 10
+
+// normalizedTitle reduces a song to words that match across its official
+// video and its "Artist - Topic" audio
+func normalizedTitle(song Song) string {
+	artist := strings.TrimSuffix(strings.TrimSpace(song.Artist), " - Topic")
+	title := titleNoisePattern.ReplaceAllString(song.Title, " ")
+	words := strings.FieldsFunc(strings.ToLower(artist+" "+title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	// "Artist - Song" titles repeat the artist that the Topic upload keeps
+	// separately, so drop the repeat
+	if len(words) > 1 {
+		seen := make(map[string]bool)
+		unique := words[:0]
+		for _, word := range words {
+			if !seen[word] {
+				seen[word] = true
+				unique = append(unique, word)
+			}
 		}
-	} else {
-		sendMpvCommand(fmt.Sprintf("seek %d absolute", seekSeconds))
-		fmt.Printf("Seeking to %d seconds\n", seekSeconds)
+		words = unique
 	}
+	sort.Strings(words)
+	return strings.Join(words, " ")
 }
 
-func handleListPlaylists() {
-	if len(config.Playlists) == 0 {
-		fmt.Println("No playlists found. Add one with: mfp add <name> <url>")
-		return
+// sameSong reports whether two songs are duplicates: the same video, or with
+// fuzzy, the same normalized title at about the same length
+func sameSong(a, b Song, fuzzy bool) bool {
+	if a.VideoID == b.VideoID {
+		return true
+	}
+	if !fuzzy || normalizedTitle(a) != normalizedTitle(b) {
+		return false
+	}
+	da, okA := durationSeconds(a.Duration)
+	db, okB := durationSeconds(b.Duration)
+	if !okA || !okB {
+		return true
 	}
+	return da-db <= fuzzyDurationSlack && db-da <= fuzzyDurationSlack
+}
 
-	fmt.Println("Available playlists:")
-	for name, playlist := range config.Playlists {
-		status := ""
-		if name == config.State.CurrentPlaylist {
-			if config.State.IsPlaying {
-				status = " (currently playing)"
-			} else {
-				status = " (loaded)"
+// duplicateGroups returns the indexes of songs that are duplicates of each
+// other, in playlist order
+func duplicateGroups(songs []Song, fuzzy bool) [][]int {
+	grouped := make([]bool, len(songs))
+	var groups [][]int
+	for i := range songs {
+		if grouped[i] {
+			continue
+		}
+		group := []int{i}
+		for j := i + 1; j < len(songs); j++ {
+			if !grouped[j] && sameSong(songs[i], songs[j], fuzzy) {
+				grouped[j] = true
+				group = append(group, j)
 			}
 		}
-		fmt.Printf("  %s - %d songs%s\n", name, len(playlist.Songs), status)
-		fmt.Printf("    Last updated: %s\n", playlist.LastUpdated)
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
 	}
+	return groups
 }
 
-func handleListSongs(args []string) {
-	if len(args) == 0 {
-		fmt.Println("Usage: mfp songs <playlist_name>")
+func handleDedupe(args []string) {
+	args, fuzzy := extractFlag(args, "--fuzzy")
+	args, yes := extractFlag(args, "--yes")
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp dedupe <playlist_name> [--fuzzy] [--yes]")
 		return
 	}
 
@@ -652,524 +1321,7937 @@ func handleListSongs(args []string) {
 		return
 	}
 
-	fmt.Printf("Songs in playlist '%s':\n", playlistName)
-	for i, song := range playlist.Songs {
-		fmt.Printf("  %d. %s (%s)\n", i+1, song.Title, song.Duration)
+	groups := duplicateGroups(playlist.Songs, fuzzy)
+	if len(groups) == 0 {
+		fmt.Printf("No duplicate songs in '%s'\n", playlistName)
+		return
 	}
-}
 
-func handleRename(args []string) {
-	if len(args) != 2 {
-		fmt.Println("Usage: mfp rename <old_name> <new_name>")
+	interactive := !yes && isTerminal(os.Stdin)
+	if !yes && !interactive {
+		fmt.Printf("Duplicate songs in '%s':\n", playlistName)
+		for g, group := range groups {
+			if g > 0 {
+				fmt.Println()
+			}
+			for k, index := range group {
+				song := playlist.Songs[index]
+				action := "drop"
+				if k == 0 {
+					action = "keep"
+				}
+				fmt.Printf("  %s %d. %s (%s)\n", action, index+1, song.DisplayTitle(), song.Duration)
+			}
+		}
+		fmt.Println("Run again with --yes to keep the first of each, or in a terminal to choose.")
 		return
 	}
 
-	oldName := args[0]
-	newName := args[1]
-
-	playlist, exists := config.Playlists[oldName]
-	if !exists {
-		fmt.Printf("Playlist '%s' not found\n", oldName)
+	remove := make(map[int]bool)
+	reader := bufio.NewReader(os.Stdin)
+	for g, group := range groups {
+		keep := 0
+		if interactive {
+			fmt.Printf("Duplicates %d/%d:\n", g+1, len(groups))
+			for k, index := range group {
+				song := playlist.Songs[index]
+				fmt.Printf("  [%d] %d. %s (%s)\n", k+1, index+1, song.DisplayTitle(), song.Duration)
+			}
+			fmt.Printf("Keep which? [1-%d, a = all, Enter = 1] ", len(group))
+			answer, _ := reader.ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer == "a" {
+				continue
+			}
+			if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(group) {
+				keep = n - 1
+			}
+		}
+		for k, index := range group {
+			if k != keep {
+				remove[index] = true
+			}
+		}
+	}
+	if len(remove) == 0 {
+		fmt.Println("Nothing removed")
 		return
 	}
-
-	if _, exists := config.Playlists[newName]; exists {
-		fmt.Printf("Playlist '%s' already exists\n", newName)
+	if interjectionBlocksEdit(playlistName) {
 		return
 	}
 
-	playlist.Name = newName
-	config.Playlists[newName] = playlist
-	delete(config.Playlists, oldName)
+	var kept []Song
+	oldToNew := make([]int, len(playlist.Songs))
+	for i, song := range playlist.Songs {
+		oldToNew[i] = -1
+		if !remove[i] {
+			oldToNew[i] = len(kept)
+			kept = append(kept, song)
+		}
+	}
+	playlist.Songs = kept
+	carryOverPlayback(playlistName, oldToNew)
 
-	// Update current playlist name if it matches
-	if config.State.CurrentPlaylist == oldName {
-		config.State.CurrentPlaylist = newName
+	if err := saveConfig(); err != nil {
+		fmt.Printf("Error saving playlist: %v\n", err)
+		return
 	}
 
-	saveConfig()
-	fmt.Printf("Renamed playlist '%s' to '%s'\n", oldName, newName)
+	fmt.Printf("Removed %d duplicate songs from '%s'\n", len(remove), playlistName)
+	if playlist.URL != "" {
+		fmt.Println("Note: a refresh brings back songs that are still in the YouTube playlist")
+	}
 }
 
-func handleDelete(args []string) {
-	if len(args) == 0 {
-		fmt.Println("Usage: mfp delete <playlist_name>")
+func handleTrimPlaylist(args []string) {
+	args, tail := extractFlag(args, "--tail")
+	args, yes := extractFlag(args, "--yes")
+	if len(args) != 2 {
+		fmt.Println("Usage: mfp trim-playlist <playlist_name> <count> [--tail] [--yes]")
 		return
 	}
 
 	playlistName := args[0]
-	if _, exists := config.Playlists[playlistName]; !exists {
+	playlist, exists := config.Playlists[playlistName]
+	if !exists {
 		fmt.Printf("Playlist '%s' not found\n", playlistName)
 		return
 	}
 
-	// Stop playback if this playlist is currently playing
-	if config.State.CurrentPlaylist == playlistName {
-		handleStop()
-		config.State.CurrentPlaylist = ""
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 1 {
+		fmt.Printf("Invalid count: %s\n", args[1])
+		return
 	}
 
-	delete(config.Playlists, playlistName)
-	saveConfig()
-	fmt.Printf("Deleted playlist '%s'\n", playlistName)
-}
-
-func handleStatus() {
-	fmt.Println("MFP Status:")
-	fmt.Printf("  Volume: %d%%\n", config.State.Volume)
-	fmt.Printf("  Shuffle: %s\n", boolToOnOff(config.State.IsShuffle))
-	fmt.Printf("  Loop: %s\n", boolToOnOff(config.State.IsLoop))
+	removeCount := len(playlist.Songs) - count
+	if removeCount <= 0 {
+		fmt.Printf("Playlist '%s' has %d songs, nothing to trim\n", playlistName, len(playlist.Songs))
+		return
+	}
 
-	if config.State.CurrentPlaylist != "" {
-		fmt.Printf("  Current Playlist: %s\n", config.State.CurrentPlaylist)
-		playlist := config.Playlists[config.State.CurrentPlaylist]
-		if playlist != nil {
-			currentIndex := getCurrentSongIndex()
-			if currentIndex < len(playlist.Songs) {
-				fmt.Printf("  Current Song: %s\n", playlist.Songs[currentIndex].Title)
-				fmt.Printf("  Position: %d/%d\n", currentIndex+1, len(playlist.Songs))
-			}
+	which := "first"
+	if tail {
+		which = "last"
+	}
+	if !yes {
+		fmt.Printf("This keeps the %s %d songs of '%s' and removes %d.\n", which, count, playlistName, removeCount)
+		if !isTerminal(os.Stdin) {
+			fmt.Println("Run again with --yes to confirm.")
+			return
+		}
+		if !confirm("Trim it?") {
+			fmt.Println("Aborted, nothing was removed")
+			return
 		}
-		fmt.Printf("  Playing: %s\n", boolToOnOff(config.State.IsPlaying))
-	} else {
-		fmt.Println("  No playlist loaded")
 	}
-}
 
-// Helper functions
+	if interjectionBlocksEdit(playlistName) {
+		return
+	}
 
-func boolToOnOff(b bool) string {
-	if b {
-		return "ON"
+	first := 0
+	if tail {
+		first = removeCount
 	}
-	return "OFF"
-}
+	oldToNew := make([]int, len(playlist.Songs))
+	for i := range oldToNew {
+		oldToNew[i] = -1
+		if i >= first && i < first+count {
+			oldToNew[i] = i - first
+		}
+	}
+	playlist.Songs = playlist.Songs[first : first+count]
+	carryOverPlayback(playlistName, oldToNew)
 
-func formatDuration(seconds int) string {
-	minutes := seconds / 60
-	seconds = seconds % 60
-	return fmt.Sprintf("%d:%02d", minutes, seconds)
-}
+	if err := saveConfig(); err != nil {
+		fmt.Printf("Error saving playlist: %v\n", err)
+		return
+	}
 
-func isValidPlaylistURL(url string) bool {
-	playlistRegex := regexp.MustCompile(`(?i)(?:youtube\.com/playlist\?list=|youtu\.be/playlist\?list=)([a-zA-Z0-9_-]+)`)
-	return playlistRegex.MatchString(url)
+	fmt.Printf("Trimmed playlist '%s' to the %s %d songs (removed %d)\n", playlistName, which, count, removeCount)
 }
 
-func extractPlaylistID(url string) string {
-	playlistRegex := regexp.MustCompile(`(?i)(?:youtube\.com/playlist\?list=|youtu\.be/playlist\?list=)([a-zA-Z0-9_-]+)`)
-	matches := playlistRegex.FindStringSubmatch(url)
-	if len(matches) > 1 {
-		return matches[1]
+func handleStop() {
+	if config.State.IsPlaying {
+		runHook("stop", config.Settings.OnStop)
 	}
-	return ""
-}
-
-func fetchPlaylistSongs(playlistID string) ([]Song, error) {
-	// Use yt-dlp to fetch playlist information
-	cmd := exec.Command("yt-dlp", "--flat-playlist", "--print", "%(title)s|%(id)s|%(duration_string)s", "--playlist-end", "100", fmt.Sprintf("https://www.youtube.com/playlist?list=%s", playlistID))
 
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch playlist: %v", err)
+	// Remember where we stopped so a bare 'mfp play' can resume there
+	if pos := getMpvPosition(); pos >= 0 {
+		config.State.Position = pos
 	}
 
-	lines := strings.Split(string(output), "\n")
-	var songs []Song
+	if config.State.IsPlaying && config.Settings.Fade > 0 {
+		fadeOut()
+	}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
+	// Send quit command to mpv first for graceful shutdown; when the
+	// daemon owns mpv this is all we can do from here
+	sendMpvCommand("quit")
 
-		parts := strings.Split(line, "|")
-		if len(parts) >= 2 {
-			title := parts[0]
-			videoID := parts[1]
-			duration := "Unknown"
-			if len(parts) >= 3 && parts[2] != "NA" {
-				duration = parts[2]
-			}
+	if ownsMpvSession() {
+		// Wait a moment for graceful shutdown
+		time.Sleep(100 * time.Millisecond)
 
-			songs = append(songs, Song{
-				Title:    title,
-				VideoID:  videoID,
-				Duration: duration,
-				URL:      fmt.Sprintf("https://www.youtube.com/watch?v=%s", videoID),
-			})
-		}
+		// Force kill if still running
+		currentCmd.Process.Kill()
+		currentCmd = nil
 	}
 
-	if len(songs) == 0 {
-		return nil, fmt.Errorf("no songs found in playlist")
-	}
+	config.State.IsPlaying = false
+	config.State.TempQueue = nil
+	config.State.SessionVolume = nil
+	config.State.PlayLimitAt = 0
+	config.State.MirrorDevice = ""
+	config.State.Interjection = nil
+	config.State.PauseAfterSong = false
+	saveConfig()
 
-	return songs, nil
+	// Clean up socket file
+	os.Remove(config.SocketFile)
+
+	fmt.Println("Playback stopped")
 }
 
-func initShuffleOrder() {
-	if config.State.CurrentPlaylist == "" {
+func handleNext(args []string) {
+	count, ok := parseSkipCount(args, "next")
+	if !ok {
 		return
 	}
 
-	playlist := config.Playlists[config.State.CurrentPlaylist]
-	if playlist == nil {
+	if !config.State.IsPlaying {
+		fmt.Println("No music is currently playing")
 		return
 	}
 
-	// Create shuffled order
-	config.State.ShuffleOrder = make([]int, len(playlist.Songs))
-	for i := range config.State.ShuffleOrder {
-		config.State.ShuffleOrder[i] = i
+	if skipTooSoon() {
+		return
 	}
 
-	// Shuffle using Fisher-Yates algorithm
-	rand.Seed(time.Now().UnixNano())
-	for i := len(config.State.ShuffleOrder) - 1; i > 0; i-- {
-		j := rand.Intn(i + 1)
-		config.State.ShuffleOrder[i], config.State.ShuffleOrder[j] = config.State.ShuffleOrder[j], config.State.ShuffleOrder[i]
+	if skipInterjection() {
+		return
 	}
 
-	config.State.ShuffleIndex = 0
-}
+	if skipQueued(count) {
+		if config.State.IsPlaying {
+			fmt.Println("Skipping to next song...")
+		}
+		return
+	}
 
-// Key fixes for the MFP player state management issues
+	// Update our internal state first
+	target := -1
+	playlist := currentPlaylist()
+	if playlist != nil {
+		index := &config.State.CurrentSongIndex
+		length := len(playlist.Songs)
+		if config.State.IsShuffle {
+			index = &config.State.ShuffleIndex
+			length = len(config.State.ShuffleOrder)
+		}
+		if length == 0 {
+			fmt.Println("Current playlist has no songs")
+			return
+		}
 
-// Improve startPlayback function
-func startPlayback() {
-	playlist := config.Playlists[config.State.CurrentPlaylist]
-	if playlist == nil {
-		fmt.Println("Error: Current playlist not found")
-		return
+		*index += count
+		if *index >= length {
+			if config.State.IsLoop && length > 0 {
+				*index %= length
+			} else {
+				handleStop()
+				return
+			}
+		}
+		target = *index
 	}
 
-	// Set state BEFORE starting mpv
-	config.State.IsPlaying = true
-	if err := saveConfig(); err != nil {
-		fmt.Printf("Error saving state: %v\n", err)
+	// Force skip to next song immediately
+	if count == 1 || target < 0 {
+		sendMpvCommand("playlist-next")
+	} else {
+		sendMpvCommand(fmt.Sprintf("set playlist-pos %d", target))
+	}
+	saveConfig()
+	if count == 1 {
+		fmt.Println("Skipping to next song...")
+	} else {
+		fmt.Printf("Skipping forward %d songs...\n", count)
 	}
+}
 
-	// Create temporary playlist file for mpv
-	playlistFile := filepath.Join(config.DataDir, "current_playlist.m3u")
-	if err := createPlaylistFile(playlist, playlistFile); err != nil {
-		fmt.Printf("Error creating playlist file: %v\n", err)
-		config.State.IsPlaying = false
-		saveConfig()
+func handlePrevious(args []string) {
+	count, ok := parseSkipCount(args, "prev")
+	if !ok {
 		return
 	}
 
-	// Start mpv with the playlist
-	if err := startMpv(playlistFile); err != nil {
-		fmt.Printf("Error starting mpv: %v\n", err)
-		config.State.IsPlaying = false
-		saveConfig()
+	if !config.State.IsPlaying {
+		fmt.Println("No music is currently playing")
 		return
 	}
 
-	fmt.Printf("MPV started successfully for playlist: %s\n", config.State.CurrentPlaylist)
+	if skipTooSoon() {
+		return
+	}
 
-	// Start monitoring in background
-	go monitorMpv()
+	if skipInterjection() {
+		return
+	}
 
-	// Don't wait here - let it run in background
-	// The Wait() should be handled in the monitor goroutine
-}
+	if skipQueued(-count) {
+		fmt.Println("Going to previous song...")
+		return
+	}
 
-// Improve handlePlay function
-func handlePlay(args []string) {
-	if len(args) == 0 {
-		// Resume current playlist if available
-		if config.State.CurrentPlaylist == "" {
-			fmt.Println("No playlist specified. Use: mfp play <playlist_name>")
-			return
+	// Update our internal state first
+	target := -1
+	playlist := currentPlaylist()
+	if playlist != nil {
+		index := &config.State.CurrentSongIndex
+		length := len(playlist.Songs)
+		if config.State.IsShuffle {
+			index = &config.State.ShuffleIndex
+			length = len(config.State.ShuffleOrder)
 		}
-		fmt.Printf("Resuming playlist: %s\n", config.State.CurrentPlaylist)
-	} else {
-		// Start new playlist
-		playlistName := args[0]
-		if _, exists := config.Playlists[playlistName]; !exists {
-			fmt.Printf("Playlist '%s' not found\n", playlistName)
+		if length == 0 {
+			fmt.Println("Current playlist has no songs")
 			return
 		}
 
-		// Stop current playback if any
-		if config.State.IsPlaying {
-			handleStop()
-			time.Sleep(500 * time.Millisecond) // Give time for cleanup
-		}
-
-		config.State.CurrentPlaylist = playlistName
-		config.State.CurrentSongIndex = 0
-		config.State.Position = 0
-
-		// Initialize shuffle order if shuffle is enabled
-		if config.State.IsShuffle {
-			initShuffleOrder()
+		*index -= count
+		if *index < 0 {
+			if config.State.IsLoop && length > 0 {
+				*index = (*index%length + length) % length
+			} else {
+				*index = 0
+			}
 		}
-
-		fmt.Printf("Loading playlist: %s\n", playlistName)
+		target = *index
 	}
 
-	if config.State.IsPlaying {
-		fmt.Println("Already playing. Use 'mfp stop' to stop current playback.")
-		return
+	// Force skip to previous song immediately
+	if count == 1 || target < 0 {
+		sendMpvCommand("playlist-prev")
+	} else {
+		sendMpvCommand(fmt.Sprintf("set playlist-pos %d", target))
 	}
-
-	// Start playback - this should run in background
-	go startPlayback()
-
-	// Give it a moment to start, then confirm
-	time.Sleep(1 * time.Second)
-	if config.State.IsPlaying {
-		fmt.Printf("Started playing playlist: %s\n", config.State.CurrentPlaylist)
+	saveConfig()
+	if count == 1 {
+		fmt.Println("Going to previous song...")
 	} else {
-		fmt.Println("Failed to start playback")
+		fmt.Printf("Going back %d songs...\n", count)
 	}
 }
 
-// Fixed monitorMpv function to properly track current song
-func monitorMpv() {
-	defer func() {
-		config.State.IsPlaying = false
-		saveConfig()
-		if currentCmd != nil {
-			currentCmd = nil
-		}
-		// Clean up socket file
-		os.Remove(config.SocketFile)
-	}()
+// parseSkipCount reads the optional song count for next/prev, defaulting to 1
+func parseSkipCount(args []string, command string) (int, bool) {
+	if len(args) == 0 {
+		return 1, true
+	}
+	count, err := strconv.Atoi(args[0])
+	if err != nil || count < 1 {
+		fmt.Printf("Usage: mfp %s [count] (count must be a positive number)\n", command)
+		return 0, false
+	}
+	return count, true
+}
 
-	// Wait for socket to be available
-	maxWait := 10 // seconds
-	for i := 0; i < maxWait; i++ {
-		if _, err := os.Stat(config.SocketFile); err == nil {
-			break
-		}
-		time.Sleep(time.Second)
-		if i == maxWait-1 {
-			fmt.Println("Error: MPV socket not created, playback may have failed")
+func handleQueue(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			listTempQueue()
+			return
+		case "clear":
+			clearTempQueue()
+			return
+		case "remove":
+			if len(args) != 2 {
+				fmt.Println("Usage: mfp queue remove <n>")
+				return
+			}
+			removeFromTempQueue(args[1])
 			return
 		}
 	}
 
-	fmt.Println("MPV connection established")
-	lastPlaylistPos := -1 // Track the last known position to detect changes
-
-	for {
-		if currentCmd == nil {
-			break
-		}
+	if config.State.CurrentPlaylist == "" {
+		fmt.Println("No playlist is currently loaded")
+		return
+	}
 
-		// Check if process is still running
-		if currentCmd.ProcessState != nil {
-			fmt.Println("MPV process ended")
-			break
-		}
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("Current playlist not found")
+		return
+	}
 
-		// Update position
-		pos := getMpvPosition()
-		if pos >= 0 {
-			config.State.Position = pos
+	showCount := 5
+	if len(args) > 0 {
+		if count, err := strconv.Atoi(args[0]); err == nil && count > 0 {
+			showCount = count
 		}
+	}
 
-		// Update current song index based on mpv's playlist position
-		playlistPos := getMpvPlaylistPosition()
-		if playlistPos >= 0 && playlistPos != lastPlaylistPos {
-			// MPV playlist position changed - update our state
-			lastPlaylistPos = playlistPos
+	currentIndex := getCurrentSongIndex()
+	fmt.Printf("Queue for playlist '%s':\n\n", config.State.CurrentPlaylist)
 
-			playlist := config.Playlists[config.State.CurrentPlaylist]
-			if playlist != nil {
-				if config.State.IsShuffle {
-					// In shuffle mode, playlistPos is the index in the shuffled order
-					if playlistPos < len(config.State.ShuffleOrder) {
-						config.State.ShuffleIndex = playlistPos
-						config.State.CurrentSongIndex = config.State.ShuffleOrder[playlistPos]
+	// Show previous songs
+	fmt.Println("Previous:")
+	start := currentIndex - showCount
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < currentIndex; i++ {
+		realIndex := i
+		if config.State.IsShuffle && i < len(config.State.ShuffleOrder) {
+			realIndex = config.State.ShuffleOrder[i]
+		}
+		if realIndex < len(playlist.Songs) {
+			fmt.Printf("  %d. %s\n", i+1, playlist.Songs[realIndex].DisplayTitle())
+		}
+	}
+
+	// Show current song
+	if currentIndex < len(playlist.Songs) {
+		realIndex := currentIndex
+		if config.State.IsShuffle && currentIndex < len(config.State.ShuffleOrder) {
+			realIndex = config.State.ShuffleOrder[currentIndex]
+		}
+		if realIndex < len(playlist.Songs) {
+			status := "▶"
+			if !config.State.IsPlaying {
+				status = "⏸"
+			}
+			fmt.Printf("\n%s %d. %s (%s) (NOW PLAYING)\n\n", status, currentIndex+1, playlist.Songs[realIndex].DisplayTitle(), playlist.Songs[realIndex].Duration)
+		}
+	}
+
+	// Time left in the current song; the next song starts when it ends
+	startsIn, known := -1, false
+	if song := currentSong(); song != nil {
+		if length, ok := durationSeconds(song.Duration); ok {
+			position := config.State.Position
+			if config.State.IsPlaying {
+				if pos := getMpvPosition(); pos >= 0 {
+					position = pos
+				}
+			}
+			startsIn, known = length-position, true
+			if startsIn < 0 {
+				startsIn = 0
+			}
+		}
+	}
+
+	// Show next songs
+	fmt.Println("Next:")
+	end := currentIndex + showCount + 1
+	if end > len(playlist.Songs) {
+		end = len(playlist.Songs)
+	}
+	for i := currentIndex + 1; i < end; i++ {
+		realIndex := i
+		if config.State.IsShuffle && i < len(config.State.ShuffleOrder) {
+			realIndex = config.State.ShuffleOrder[i]
+		}
+		if realIndex < len(playlist.Songs) {
+			song := playlist.Songs[realIndex]
+			// Once a song's length is unknown, later start times are too
+			startText := "starts in ?"
+			if known {
+				startText = "starts in ~" + formatDuration(startsIn)
+			}
+			fmt.Printf("  %d. %s (%s) - %s\n", i+1, song.DisplayTitle(), song.Duration, startText)
+
+			length, ok := durationSeconds(song.Duration)
+			known = known && ok
+			startsIn += length
+		}
+	}
+}
+
+func handleScheduleView(args []string) {
+	count := 10
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || len(args) > 1 {
+			fmt.Println("Usage: mfp schedule-view [count]")
+			return
+		}
+		count = n
+	}
+
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("No playlist is currently loaded")
+		return
+	}
+	order := playOrder(playlist)
+	current := config.State.CurrentSongIndex
+	if config.State.IsShuffle {
+		current = config.State.ShuffleIndex
+	}
+	if len(order) == 0 || current < 0 || current >= len(order) || allSkipped(playlist) {
+		fmt.Println("Nothing to schedule")
+		return
+	}
+
+	position := config.State.Position
+	if config.State.IsPlaying {
+		if pos := getMpvPosition(); pos >= 0 {
+			position = pos
+		}
+	}
+
+	// Start times add up from now; once a length is unknown, later ones are guesses
+	at, known := time.Now(), true
+	song := playlist.Songs[order[current]]
+	fmt.Printf("Schedule for '%s':\n", config.State.CurrentPlaylist)
+	fmt.Printf("  Now      – %s\n", song.DisplayTitle())
+	if length, ok := durationSeconds(song.Duration); ok && length > position {
+		at = at.Add(time.Duration(length-position) * time.Second)
+	} else if !ok {
+		known = false
+	}
+
+	for i, shown := current+1, 0; shown < count; i++ {
+		if i >= len(order) {
+			if !config.State.IsLoop || onFinalLoop() {
+				fmt.Println("  (end of playlist)")
+				break
+			}
+			i = 0
+		}
+		song := playlist.Songs[order[i]]
+		if isSkipped(song) {
+			continue
+		}
+		if known {
+			fmt.Printf("  %8s – %s\n", at.Format("3:04 PM"), song.DisplayTitle())
+		} else {
+			fmt.Printf("  %8s – %s\n", "~", song.DisplayTitle())
+		}
+		length, ok := durationSeconds(song.Duration)
+		known = known && ok
+		at = at.Add(time.Duration(length) * time.Second)
+		shown++
+	}
+	if config.State.IsPlaying {
+		if paused, err := getMpvProperty("pause"); err == nil && paused == true {
+			fmt.Println("\nPaused: times assume playback resumes now")
+		}
+	} else {
+		fmt.Println("\nNot playing: times assume playback resumes now")
+	}
+}
+
+// handleTimeLeft adds up what's left of the current song and everything
+// after it in play order, including queue-playlist songs and the passes left
+// of 'mfp loop <times>'
+func handleTimeLeft(args []string) {
+	if len(args) > 0 {
+		fmt.Println("Usage: mfp time-left")
+		return
+	}
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("No playlist is currently loaded")
+		return
+	}
+	order := playOrder(playlist)
+	current := config.State.CurrentSongIndex
+	if config.State.IsShuffle {
+		current = config.State.ShuffleIndex
+	}
+	if len(order) == 0 || current < 0 || current >= len(order) || allSkipped(playlist) {
+		fmt.Println("Nothing left to play")
+		return
+	}
+	if config.State.IsLoop && config.State.LoopCount == 0 {
+		fmt.Println("Loop is on, so the playlist never ends ('mfp loop <times>' sets a number of passes)")
+		return
+	}
+
+	position := config.State.Position
+	if config.State.IsPlaying && config.State.Interjection == nil {
+		if pos := getMpvPosition(); pos >= 0 {
+			position = pos
+		}
+	}
+
+	total, unknown := 0, 0
+	add := func(song Song, played int) {
+		if length, ok := durationSeconds(song.Duration); ok {
+			if length > played {
+				total += length - played
+			}
+		} else {
+			unknown++
+		}
+	}
+	if in := config.State.Interjection; in != nil && in.Started {
+		played := 0
+		if pos := getMpvPosition(); pos >= 0 {
+			played = pos
+		}
+		add(in.Song, played)
+	}
+	add(playlist.Songs[order[current]], position)
+	for _, index := range order[current+1:] {
+		if !isSkipped(playlist.Songs[index]) {
+			add(playlist.Songs[index], 0)
+		}
+	}
+	if config.State.IsLoop {
+		for pass := config.State.LoopsDone + 1; pass < config.State.LoopCount; pass++ {
+			for _, index := range order {
+				if !isSkipped(playlist.Songs[index]) {
+					add(playlist.Songs[index], 0)
+				}
+			}
+		}
+	}
+	for _, song := range config.State.TempQueue {
+		add(song, 0)
+	}
+
+	left := time.Duration(total) * time.Second
+	hours, minutes := int(left.Hours()), int(left.Minutes())%60
+	summary := fmt.Sprintf("%dm", minutes)
+	if hours > 0 {
+		summary = fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+	line := fmt.Sprintf("Playlist ends in ~%s", summary)
+	if unknown == 1 {
+		line += " + 1 song of unknown length"
+	} else if unknown > 1 {
+		line += fmt.Sprintf(" + %d songs of unknown length", unknown)
+	} else {
+		line += fmt.Sprintf(" (around %s)", time.Now().Add(left).Format("3:04 PM"))
+	}
+	fmt.Println(line)
+	if !config.State.IsPlaying {
+		fmt.Println("Not playing: counted from where playback would resume")
+	}
+}
+
+func handleQueuePlaylist(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp queue-playlist <playlist_name>")
+		fmt.Println("       mfp queue-playlist --clear")
+		return
+	}
+
+	if !config.State.IsPlaying {
+		fmt.Println("No music is currently playing")
+		return
+	}
+
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("Current playlist not found")
+		return
+	}
+
+	if args[0] == "--clear" {
+		clearTempQueue()
+		return
+	}
+
+	other, exists := config.Playlists[args[0]]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", args[0])
+		return
+	}
+
+	added := 0
+	for _, song := range other.Songs {
+		if isSkipped(song) {
+			continue
+		}
+		sendMpvCommandArgs("loadfile", song.URL, "append")
+		config.State.TempQueue = append(config.State.TempQueue, song)
+		added++
+	}
+	saveConfig()
+	fmt.Printf("Queued %d songs from '%s' to play after '%s'\n", added, args[0], config.State.CurrentPlaylist)
+	if config.State.IsLoop {
+		fmt.Println("Loop is on, so they'll repeat along with the playlist")
+	}
+}
+
+// The temp queue's songs follow the playlist's in mpv's live playlist, so
+// queued song i (0-based) is at mpv position len(playOrder) + i
+
+func listTempQueue() {
+	if len(config.State.TempQueue) == 0 {
+		fmt.Println("Nothing is queued")
+		return
+	}
+	playingIndex := -1
+	if playlist := currentPlaylist(); playlist != nil {
+		playingIndex = getMpvPlaylistPosition() - len(playOrder(playlist))
+	}
+	fmt.Printf("Queued after '%s' (%d):\n", config.State.CurrentPlaylist, len(config.State.TempQueue))
+	for i, song := range config.State.TempQueue {
+		if i == playingIndex {
+			fmt.Printf("▶ %d. %s (NOW PLAYING)\n", i+1, song.DisplayTitle())
+		} else {
+			fmt.Printf("  %d. %s\n", i+1, song.DisplayTitle())
+		}
+	}
+}
+
+func clearTempQueue() {
+	queued := len(config.State.TempQueue)
+	if queued == 0 {
+		fmt.Println("Nothing is queued")
+		return
+	}
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("Current playlist not found")
+		return
+	}
+	length := len(playOrder(playlist))
+	if pos := getMpvPlaylistPosition(); pos >= length {
+		fmt.Println("A queued song is playing now; use 'mfp stop' to end the session")
+		return
+	}
+	// Remove from the end so the earlier positions stay valid
+	for i := length + queued - 1; i >= length; i-- {
+		sendMpvCommand(fmt.Sprintf("playlist-remove %d", i))
+	}
+	config.State.TempQueue = nil
+	saveConfig()
+	fmt.Printf("Removed %d queued songs\n", queued)
+}
+
+func removeFromTempQueue(value string) {
+	queued := len(config.State.TempQueue)
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > queued {
+		if queued == 0 {
+			fmt.Println("Nothing is queued")
+		} else {
+			fmt.Printf("Invalid queue number. Use 1-%d ('mfp queue list')\n", queued)
+		}
+		return
+	}
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("Current playlist not found")
+		return
+	}
+	position := len(playOrder(playlist)) + n - 1
+	if getMpvPlaylistPosition() == position {
+		fmt.Println("That song is playing now; use 'mfp next' to skip it")
+		return
+	}
+
+	song := config.State.TempQueue[n-1]
+	sendMpvCommand(fmt.Sprintf("playlist-remove %d", position))
+	config.State.TempQueue = append(config.State.TempQueue[:n-1], config.State.TempQueue[n:]...)
+	if len(config.State.TempQueue) == 0 {
+		config.State.TempQueue = nil
+	}
+	saveConfig()
+	fmt.Printf("Removed from the queue: %s\n", song.DisplayTitle())
+}
+
+// skipCooldown is the minimum time between skips. mpv needs a moment to act
+// on playlist-next/prev, and skips sent faster than that desync our index.
+const skipCooldown = 300 * time.Millisecond
+
+// skipTooSoon reports whether a skip should be ignored because the last one
+// was less than skipCooldown ago, and otherwise records this one. The check
+// holds skip.lock so simultaneous 'mfp next' calls are decided one at a time.
+func skipTooSoon() bool {
+	if lock, err := lockFile("skip.lock"); err == nil {
+		defer lock.Close()
+		reloadConfig()
+	}
+
+	if since := time.Since(config.State.LastSkip); since >= 0 && since < skipCooldown {
+		fmt.Println("Skip ignored, the previous one is still in progress")
+		return true
+	}
+	config.State.LastSkip = time.Now()
+	saveConfig()
+	return false
+}
+
+// skipQueued handles next/previous when mpv is in, or moving into, the songs
+// added by queue-playlist, which lie past the end of the playlist's own
+// songs. It returns false when the skip stays within the playlist.
+func skipQueued(delta int) bool {
+	queued := len(config.State.TempQueue)
+	playlist := currentPlaylist()
+	if queued == 0 || playlist == nil {
+		return false
+	}
+	length := len(playOrder(playlist))
+	pos := getMpvPlaylistPosition()
+	target := pos + delta
+	if pos < 0 || (pos < length && target < length) {
+		return false
+	}
+
+	if target >= length+queued {
+		if !config.State.IsLoop {
+			handleStop()
+			return true
+		}
+		target %= length + queued
+	}
+	if target < 0 {
+		target = 0
+	}
+
+	// Back in the playlist proper: keep our index in step with mpv
+	if target < length {
+		if config.State.IsShuffle {
+			config.State.ShuffleIndex = target
+		} else {
+			config.State.CurrentSongIndex = target
+		}
+	}
+	sendMpvCommand(fmt.Sprintf("set playlist-pos %d", target))
+	saveConfig()
+	return true
+}
+
+func handleQueueAfter(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: mfp queue-after <youtube_video_url>")
+		return
+	}
+
+	if config.State.CurrentPlaylist == "" {
+		fmt.Println("No playlist is currently loaded")
+		return
+	}
+
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("Current playlist not found")
+		return
+	}
+
+	videoID := extractVideoID(args[0])
+	if videoID == "" {
+		fmt.Println("Error: Invalid YouTube video URL")
+		return
+	}
+
+	song, err := fetchVideoSong(videoID)
+	if err != nil {
+		fmt.Printf("Error fetching song: %v\n", err)
+		return
+	}
+	song.AddedAt = time.Now()
+
+	// Insert right after the current song in the stored playlist
+	insertIndex := getCurrentSongIndex() + 1
+	if len(playlist.Songs) == 0 {
+		insertIndex = 0
+	}
+	playlist.Songs = append(playlist.Songs, Song{})
+	copy(playlist.Songs[insertIndex+1:], playlist.Songs[insertIndex:])
+	playlist.Songs[insertIndex] = song
+
+	if config.State.IsShuffle {
+		// Shift indices past the insertion point and play the new song next
+		for i, index := range config.State.ShuffleOrder {
+			if index >= insertIndex {
+				config.State.ShuffleOrder[i] = index + 1
+			}
+		}
+		pos := config.State.ShuffleIndex + 1
+		if pos > len(config.State.ShuffleOrder) {
+			pos = len(config.State.ShuffleOrder)
+		}
+		config.State.ShuffleOrder = append(config.State.ShuffleOrder, 0)
+		copy(config.State.ShuffleOrder[pos+1:], config.State.ShuffleOrder[pos:])
+		config.State.ShuffleOrder[pos] = insertIndex
+	}
+
+	// mpv's playlist follows our play order, so insert-next lines up with both modes
+	if config.State.IsPlaying {
+		sendMpvCommand(fmt.Sprintf("loadfile %s insert-next", song.URL))
+	}
+
+	saveConfig()
+	fmt.Printf("Queued to play next: %s\n", song.DisplayTitle())
+}
+
+// keepOpenMode is mpv's keep-open for the session: single-song mode holds
+// every song open at its end, otherwise mpv moves on as usual
+func keepOpenMode() string {
+	if config.State.SingleSong {
+		return "always"
+	}
+	return "no"
+}
+
+// songEndSlack is how close to its end the monitor must last have seen a
+// song for a change of song to count as it running out rather than a skip
+const songEndSlack = 3
+
+// pauseAtSongEnd pauses the song that just started when 'mfp
+// pause-after-track' asked to stop at the end of the one before. With
+// rewind it also goes back to the start of the song, which mpv may have
+// been playing for up to a poll interval. Only a song that ran out counts;
+// after a skip it waits for the new one to end.
+func pauseAtSongEnd(ranOut, rewind bool) {
+	if !config.State.PauseAfterSong || !ranOut {
+		return
+	}
+	sendMpvCommand("set pause yes")
+	if rewind {
+		sendMpvCommandArgs("seek", 0, "absolute")
+	}
+	config.State.PauseAfterSong = false
+	saveConfig()
+	fmt.Println("Song finished, paused (pause-after-track)")
+}
+
+func handlePauseAfterTrack(args []string) {
+	if len(args) > 0 {
+		fmt.Println("Usage: mfp pause-after-track")
+		return
+	}
+	if !config.State.IsPlaying {
+		fmt.Println("No music is currently playing")
+		return
+	}
+	config.State.PauseAfterSong = !config.State.PauseAfterSong
+	saveConfig()
+	if !config.State.PauseAfterSong {
+		fmt.Println("Pause after this song: OFF, playback carries on")
+		return
+	}
+	if song := currentSong(); song != nil {
+		fmt.Printf("Pause after this song: ON, pausing when %s ends\n", song.DisplayTitle())
+	} else {
+		fmt.Println("Pause after this song: ON")
+	}
+}
+
+// skipInterjection ends a playing interjection early for next/prev; the
+// monitor then resumes the interrupted song
+func skipInterjection() bool {
+	if in := config.State.Interjection; in == nil || !in.Started {
+		return false
+	}
+	sendMpvCommand("playlist-next")
+	fmt.Println("Skipping the interjection, back to the playlist...")
+	return true
+}
+
+// handleInterject plays a video right away and then goes back to the
+// interrupted song at the same spot; unlike queue-after, nothing is saved
+func handleInterject(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp interject <youtube_video_url>")
+		return
+	}
+	if !config.State.IsPlaying {
+		fmt.Println("No music is currently playing")
+		return
+	}
+	if config.State.Interjection != nil {
+		fmt.Printf("Already interjecting %s; wait for it to finish or skip it with 'mfp next'\n", config.State.Interjection.Song.DisplayTitle())
+		return
+	}
+
+	videoID := extractVideoID(args[0])
+	if videoID == "" {
+		fmt.Println("Error: Invalid YouTube video URL")
+		return
+	}
+	song, err := fetchVideoSong(videoID)
+	if err != nil {
+		fmt.Printf("Error fetching song: %v\n", err)
+		return
+	}
+
+	playlistPos := getMpvPlaylistPosition()
+	pos := getMpvPosition()
+	if playlistPos < 0 || pos < 0 {
+		fmt.Println("Error: mpv is not responding")
+		return
+	}
+
+	config.State.Interjection = &Interjection{
+		Song:       song,
+		MpvPos:     playlistPos + 1,
+		ReturnPos:  playlistPos,
+		ReturnTime: pos,
+	}
+	config.State.Position = pos
+	saveConfig()
+
+	if err := sendMpvCommandArgs("loadfile", song.URL, "insert-next"); err != nil {
+		config.State.Interjection = nil
+		saveConfig()
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	// Hold the one-off song open at its end so the monitor can go back from
+	// there: in single-song mode mpv would otherwise stop, and after the
+	// playlist's last song it would quit
+	sendMpvCommandArgs("set_property", "keep-open", "always")
+	sendMpvCommand("playlist-next")
+
+	fmt.Printf("Interjecting: %s\n", song.DisplayTitle())
+	if current := currentSong(); current != nil {
+		fmt.Printf("Then back to %s at %s\n", current.DisplayTitle(), formatDuration(pos))
+	}
+}
+
+func handleJump(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: mfp jump <song_number|+N|-N>")
+		return
+	}
+
+	if config.State.CurrentPlaylist == "" {
+		fmt.Println("No playlist is currently loaded")
+		return
+	}
+
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("Current playlist not found")
+		return
+	}
+	if interjectionBlocksMove() {
+		return
+	}
+
+	// +N/-N move through the play order, stopping at its ends
+	if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+		delta, err := strconv.Atoi(args[0])
+		order := playOrder(playlist)
+		if err != nil || delta == 0 || len(order) == 0 {
+			fmt.Println("Usage: mfp jump <song_number|+N|-N>")
+			return
+		}
+		current := config.State.CurrentSongIndex
+		if config.State.IsShuffle {
+			current = config.State.ShuffleIndex
+		}
+		target := current + delta
+		if target < 0 {
+			target = 0
+		}
+		if target > len(order)-1 {
+			target = len(order) - 1
+		}
+		if target == current {
+			if delta > 0 {
+				fmt.Println("Already at the last song")
+			} else {
+				fmt.Println("Already at the first song")
+			}
+			return
+		}
+		jumpToSong(order[target])
+		fmt.Printf("Jumped to song %d: %s\n", order[target]+1, playlist.Songs[order[target]].DisplayTitle())
+		saveConfig()
+		return
+	}
+
+	songNum, err := strconv.Atoi(args[0])
+	if err != nil || songNum < 1 || songNum > len(playlist.Songs) {
+		fmt.Printf("Invalid song number. Please use 1-%d\n", len(playlist.Songs))
+		return
+	}
+
+	// Convert to 0-based index
+	jumpToSong(songNum - 1)
+	fmt.Printf("Jumped to song %d: %s\n", songNum, playlist.Songs[songNum-1].DisplayTitle())
+	saveConfig()
+}
+
+// jumpToSong makes the song at targetIndex (in playlist order) the current
+// one, moving mpv there if it's playing
+func jumpToSong(targetIndex int) {
+	mpvIndex := targetIndex
+	if config.State.IsShuffle {
+		// Find the shuffle index that corresponds to this song; mpv's
+		// playlist is in shuffle order
+		for i, shuffledIndex := range config.State.ShuffleOrder {
+			if shuffledIndex == targetIndex {
+				config.State.ShuffleIndex = i
+				mpvIndex = i
+				break
+			}
+		}
+	} else {
+		config.State.CurrentSongIndex = targetIndex
+	}
+	config.State.Position = 0
+
+	if config.State.IsPlaying {
+		// Jump to the song in mpv playlist
+		sendMpvCommand(fmt.Sprintf("set playlist-pos %d", mpvIndex))
+	}
+}
+
+func handleRandom() {
+	if config.State.CurrentPlaylist == "" {
+		fmt.Println("No playlist is currently loaded")
+		return
+	}
+
+	playlist := currentPlaylist()
+	if playlist == nil || len(playlist.Songs) == 0 {
+		fmt.Println("Current playlist has no songs")
+		return
+	}
+	if interjectionBlocksMove() {
+		return
+	}
+
+	// Pick a position in play order, avoiding the song that's on now and
+	// songs marked skip-always
+	if config.State.IsShuffle && len(config.State.ShuffleOrder) != len(playlist.Songs) {
+		initShuffleOrder()
+	}
+	currentIndex := getCurrentSongIndex()
+	var candidates []int
+	for _, index := range playOrder(playlist) {
+		if index != currentIndex && !isSkipped(playlist.Songs[index]) {
+			candidates = append(candidates, index)
+		}
+	}
+	if len(candidates) == 0 {
+		fmt.Println("No other song to jump to")
+		return
+	}
+	targetIndex := candidates[rand.Intn(len(candidates))]
+
+	jumpToSong(targetIndex)
+	fmt.Printf("Jumped to song %d: %s\n", targetIndex+1, playlist.Songs[targetIndex].DisplayTitle())
+	saveConfig()
+}
+
+func handleShuffle(args []string) {
+	if len(args) > 0 && strings.ToLower(args[0]) == "reshuffle-on-loop" {
+		handleReshuffleOnLoop(args[1:])
+		return
+	}
+	if interjectionBlocksMove() {
+		return
+	}
+
+	// Where the current song sits before the toggle
+	wasShuffle := config.State.IsShuffle
+	var live []int
+	playlist := currentPlaylist()
+	playing := getCurrentSongIndex()
+	if playlist != nil {
+		live = playOrder(playlist)
+	}
+
+	if len(args) == 0 {
+		// Toggle shuffle
+		config.State.IsShuffle = !config.State.IsShuffle
+	} else {
+		switch strings.ToLower(args[0]) {
+		case "on", "true", "1":
+			config.State.IsShuffle = true
+		case "off", "false", "0":
+			config.State.IsShuffle = false
+		default:
+			fmt.Println("Usage: mfp shuffle [on|off|reshuffle-on-loop [on|off]]")
+			return
+		}
+	}
+
+	// The current song keeps playing across the toggle: it goes first in a
+	// new shuffle order, and mpv's playlist is rearranged around it
+	if playlist != nil && config.State.IsShuffle != wasShuffle {
+		if config.State.IsShuffle {
+			initShuffleOrder()
+			for i, index := range config.State.ShuffleOrder {
+				if index == playing {
+					config.State.ShuffleOrder[0], config.State.ShuffleOrder[i] = config.State.ShuffleOrder[i], config.State.ShuffleOrder[0]
+					break
+				}
+			}
+		}
+		config.State.CurrentSongIndex = playing
+		if config.State.IsPlaying {
+			rearrangeMpvPlaylist(live, playOrder(playlist))
+		}
+	}
+
+	fmt.Printf("Shuffle: %s\n", boolToOnOff(config.State.IsShuffle))
+	saveConfig()
+}
+
+func handleReshuffleOnLoop(args []string) {
+	if len(args) == 0 {
+		config.State.ReshuffleOnLoop = !config.State.ReshuffleOnLoop
+	} else {
+		switch strings.ToLower(args[0]) {
+		case "on", "true", "1":
+			config.State.ReshuffleOnLoop = true
+		case "off", "false", "0":
+			config.State.ReshuffleOnLoop = false
+		default:
+			fmt.Println("Usage: mfp shuffle reshuffle-on-loop [on|off]")
+			return
+		}
+	}
+
+	fmt.Printf("Reshuffle on loop: %s\n", boolToOnOff(config.State.ReshuffleOnLoop))
+	saveConfig()
+}
+
+func handleLoop(args []string) {
+	if len(args) == 0 {
+		// Toggle loop
+		config.State.IsLoop = !config.State.IsLoop
+		config.State.LoopCount = 0
+	} else {
+		switch value := strings.ToLower(args[0]); value {
+		case "on", "true", "1", "inf":
+			config.State.IsLoop = true
+			config.State.LoopCount = 0
+		case "off", "false", "0":
+			config.State.IsLoop = false
+			config.State.LoopCount = 0
+		default:
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 2 {
+				fmt.Println("Usage: mfp loop [on|off|inf|<times>]")
+				return
+			}
+			// The pass that's playing now counts as the first
+			config.State.IsLoop = true
+			config.State.LoopCount = count
+			config.State.LoopsDone = 0
+		}
+	}
+
+	if config.State.IsLoop {
+		if config.State.IsPlaying {
+			sendMpvCommand("set loop-playlist inf")
+		}
+		if config.State.LoopCount > 0 {
+			fmt.Printf("Loop: ON, %d times then stop\n", config.State.LoopCount)
+		} else {
+			fmt.Println("Loop: ON")
+		}
+	} else {
+		if config.State.IsPlaying {
+			sendMpvCommand("set loop-playlist no")
+		}
+		fmt.Println("Loop: OFF")
+	}
+
+	saveConfig()
+}
+
+func handleVolume(args []string) {
+	volume := currentVolume()
+	if len(args) == 0 {
+		fmt.Printf("Current volume: %d%%\n", *volume)
+		return
+	}
+
+	switch args[0] {
+	case "up", "+":
+		*volume += 10
+		if *volume > 100 {
+			*volume = 100
+		}
+	case "down", "-":
+		*volume -= 10
+		if *volume < 0 {
+			*volume = 0
+		}
+	case "reset":
+		*volume = defaultVolume()
+	default:
+		if vol, err := strconv.Atoi(args[0]); err == nil {
+			if vol >= 0 && vol <= 100 {
+				*volume = vol
+			} else {
+				fmt.Println("Volume must be between 0 and 100")
+				return
+			}
+		} else {
+			fmt.Println("Usage: mfp volume [up|down|reset|<0-100>]")
+			return
+		}
+	}
+
+	// Set volume in mpv if playing, within the quiet hours cap
+	quietCap, pause := quietVolume()
+	capped := inQuietHours(time.Now()) && !pause && *volume > quietCap
+	if config.State.IsPlaying {
+		target := *volume
+		if capped {
+			target = quietCap
+		}
+		sendMpvCommand(fmt.Sprintf("set volume %d", target))
+	}
+
+	if args[0] == "reset" {
+		fmt.Printf("Volume reset to: %d%%\n", *volume)
+	} else {
+		fmt.Printf("Volume set to: %d%%\n", *volume)
+	}
+	if config.State.SessionVolume != nil {
+		fmt.Printf("For this session only, the saved volume stays %d%%\n", config.State.Volume)
+	}
+	if capped {
+		fmt.Printf("Capped at %d%% during quiet hours (%s)\n", quietCap, config.Settings.QuietHours)
+	}
+	saveConfig()
+}
+
+// maxEqGain bounds EQ gains; ffmpeg takes more, but past this it only distorts
+const maxEqGain = 20
+
+// eqBands are the bands 'mfp eq' adjusts, each an ffmpeg filter of that name
+var eqBands = []struct {
+	name string
+	gain func() *int
+}{
+	{"bass", func() *int { return &config.State.EqBass }},
+	{"treble", func() *int { return &config.State.EqTreble }},
+}
+
+// eqFilter is a band's mpv filter, labelled so it can be replaced live
+func eqFilter(band string, gain int) string {
+	return fmt.Sprintf("@mfp%s:lavfi=[%s=g=%d]", band, band, gain)
+}
+
+func eqSummary() string {
+	var parts []string
+	for _, band := range eqBands {
+		if gain := *band.gain(); gain != 0 {
+			parts = append(parts, fmt.Sprintf("%s %+d dB", band.name, gain))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func handleEq(args []string) {
+	if len(args) == 0 {
+		if summary := eqSummary(); summary != "" {
+			fmt.Printf("EQ: %s\n", summary)
+		} else {
+			fmt.Println("EQ: flat")
+		}
+		return
+	}
+
+	if args[0] == "reset" {
+		for _, band := range eqBands {
+			*band.gain() = 0
+			if config.State.IsPlaying {
+				sendMpvCommandArgs("af", "remove", "@mfp"+band.name)
+			}
+		}
+		saveConfig()
+		fmt.Println("EQ reset to flat")
+		return
+	}
+
+	if len(args) != 2 {
+		fmt.Println("Usage: mfp eq [bass|treble <-20..+20>|reset]")
+		return
+	}
+	var gain *int
+	for _, band := range eqBands {
+		if band.name == args[0] {
+			gain = band.gain()
+		}
+	}
+	if gain == nil {
+		fmt.Printf("Unknown EQ band: %s (use bass or treble)\n", args[0])
+		return
+	}
+	value, err := strconv.Atoi(args[1])
+	if err != nil {
+		fmt.Println("Usage: mfp eq [bass|treble <-20..+20>|reset]")
+		return
+	}
+	if value > maxEqGain || value < -maxEqGain {
+		fmt.Printf("Clamped to the -%d..+%d dB range\n", maxEqGain, maxEqGain)
+		if value > 0 {
+			value = maxEqGain
+		} else {
+			value = -maxEqGain
+		}
+	}
+	*gain = value
+
+	if config.State.IsPlaying {
+		sendMpvCommandArgs("af", "remove", "@mfp"+args[0])
+		if value != 0 {
+			sendMpvCommandArgs("af", "add", eqFilter(args[0], value))
+		}
+	}
+	saveConfig()
+	fmt.Printf("EQ %s set to %+d dB\n", args[0], value)
+}
+
+func handleSeek(args []string) {
+	args, allowOverflow := extractFlag(args, "--allow-overflow")
+	if len(args) == 0 {
+		fmt.Println("Usage: mfp seek [+|-]<seconds>|<percent>% [--allow-overflow]")
+		return
+	}
+
+	if !config.State.IsPlaying {
+		fmt.Println("No music is currently playing")
+		return
+	}
+
+	seekArg := args[0]
+	var seekSeconds int
+	var err error
+	var relative bool
+	percent := -1.0
+
+	if strings.HasSuffix(seekArg, "%") {
+		// A percentage of the current song's length, e.g. 50% for the middle
+		percent, err = strconv.ParseFloat(strings.TrimSuffix(seekArg, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			fmt.Println("Invalid seek percentage, use 0-100%")
+			return
+		}
+		duration, ok := getMpvFloatProperty("duration")
+		if !ok {
+			fmt.Println("Song length is unknown, can't seek by percentage")
+			return
+		}
+		seekSeconds = int(duration * percent / 100)
+	} else if strings.HasPrefix(seekArg, "+") || strings.HasPrefix(seekArg, "-") {
+		relative = true
+		seekSeconds, err = strconv.Atoi(seekArg[1:])
+		if strings.HasPrefix(seekArg, "-") {
+			seekSeconds = -seekSeconds
+		}
+	} else {
+		seekSeconds, err = strconv.Atoi(seekArg)
+	}
+
+	if err != nil {
+		fmt.Println("Invalid seek value")
+		return
+	}
+
+	// Keep the seek inside the current song; seeking past the end would make
+	// mpv silently advance to the next one
+	if !allowOverflow {
+		if duration, ok := getMpvFloatProperty("duration"); ok {
+			target, known := seekSeconds, true
+			if relative {
+				pos := getMpvPosition()
+				target, known = pos+seekSeconds, pos >= 0
+			}
+
+			clamped := min(target, max(int(duration)-1, 0))
+			clamped = max(clamped, 0)
+			if known && clamped != target {
+				sendMpvCommand(fmt.Sprintf("seek %d absolute", clamped))
+				fmt.Printf("Seeking to %s (kept within the song's %s length)\n", formatDuration(clamped), formatDuration(int(duration)))
+				return
+			}
+		}
+	}
+
+	if relative {
+		sendMpvCommand(fmt.Sprintf("seek %d", seekSeconds))
+		if seekSeconds > 0 {
+			fmt.Printf("Seeking forward %d seconds\n", seekSeconds)
+		} else {
+			fmt.Printf("Seeking backward %d seconds\n", -seekSeconds)
+		}
+	} else {
+		sendMpvCommand(fmt.Sprintf("seek %d absolute", seekSeconds))
+		if percent >= 0 {
+			fmt.Printf("Seeking to %s%% (%s)\n", strings.TrimSuffix(seekArg, "%"), formatDuration(seekSeconds))
+		} else {
+			fmt.Printf("Seeking to %d seconds\n", seekSeconds)
+		}
+	}
+}
+
+// playlistsWithID returns the saved playlists whose URL has the given
+// list= ID, apart from exclude
+func playlistsWithID(playlistID, exclude string) []string {
+	var names []string
+	for _, name := range playlistNames() {
+		if name != exclude && extractPlaylistID(config.Playlists[name].URL) == playlistID {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func listDuplicatePlaylists() {
+	reported := make(map[string]bool)
+	found := false
+	for _, name := range playlistNames() {
+		playlistID := extractPlaylistID(config.Playlists[name].URL)
+		if playlistID == "" || reported[playlistID] {
+			continue
+		}
+		reported[playlistID] = true
+		if others := playlistsWithID(playlistID, name); len(others) > 0 {
+			if !found {
+				fmt.Println("Playlists pointing at the same YouTube playlist:")
+				found = true
+			}
+			fmt.Printf("  %s: %s\n", playlistID, strings.Join(append([]string{name}, others...), ", "))
+		}
+	}
+	if !found {
+		fmt.Println("No duplicate playlists found")
+	}
+}
+
+func handleListPlaylists(args []string) {
+	if len(config.Playlists) == 0 {
+		fmt.Println("No playlists found. Add one with: mfp add <name> <url>")
+		return
+	}
+
+	args, filterTag, _ := extractFlagValue(args, "--tag")
+	args, sortBy, _ := extractFlagValue(args, "--sort")
+	args, duplicates := extractFlag(args, "--duplicates")
+	if len(args) > 0 {
+		fmt.Println("Usage: mfp list [--tag <tag>] [--sort name|updated|songs] [--duplicates]")
+		return
+	}
+	if duplicates {
+		listDuplicatePlaylists()
+		return
+	}
+	filterTag = strings.ToLower(filterTag)
+
+	names := playlistNames()
+	switch sortBy {
+	case "":
+	case "name":
+		sort.Strings(names)
+	case "updated":
+		// Most recently updated first; the timestamp format sorts as text
+		sort.SliceStable(names, func(i, j int) bool {
+			return config.Playlists[names[i]].LastUpdated > config.Playlists[names[j]].LastUpdated
+		})
+	case "songs":
+		sort.SliceStable(names, func(i, j int) bool {
+			return len(config.Playlists[names[i]].Songs) > len(config.Playlists[names[j]].Songs)
+		})
+	default:
+		fmt.Printf("Invalid sort %q, use name, updated or songs\n", sortBy)
+		return
+	}
+
+	if filterTag != "" {
+		fmt.Printf("Playlists tagged '%s':\n", filterTag)
+	} else {
+		fmt.Println("Available playlists:")
+	}
+	found := false
+	for _, name := range names {
+		playlist := config.Playlists[name]
+		if filterTag != "" && !hasTag(playlist, filterTag) {
+			continue
+		}
+		found = true
+
+		status := ""
+		if name == config.State.CurrentPlaylist {
+			if config.State.IsPlaying {
+				status = " (currently playing)"
+			} else {
+				status = " (loaded)"
+			}
+		}
+		if name == config.State.PinnedPlaylist {
+			status += " (pinned)"
+		}
+		label := ""
+		if playlist.Label != "" {
+			label = playlist.Label + " "
+		}
+		fmt.Printf("  %s%s - %d songs%s\n", label, name, len(playlist.Songs), status)
+		if len(playlist.Tags) > 0 {
+			fmt.Printf("    Tags: %s\n", strings.Join(playlist.Tags, ", "))
+		}
+		fmt.Printf("    Last updated: %s\n", playlist.LastUpdated)
+	}
+
+	if !found {
+		fmt.Println("  (none)")
+	}
+}
+
+// nextListPosition puts a new playlist after all the others in 'mfp list'
+func nextListPosition() int {
+	position := 0
+	for _, playlist := range config.Playlists {
+		position = max(position, playlist.ListPosition)
+	}
+	return position + 1
+}
+
+// playlistNames returns the saved playlist names in listing order: by
+// ListPosition, then alphabetically for playlists that have none
+func playlistNames() []string {
+	var names []string
+	for name := range config.Playlists {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := config.Playlists[names[i]].ListPosition, config.Playlists[names[j]].ListPosition
+		if (a == 0) != (b == 0) {
+			return b == 0
+		}
+		if a != b {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func handlePin(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp pin <playlist_name>")
+		return
+	}
+
+	playlistName := args[0]
+	if _, exists := config.Playlists[playlistName]; !exists {
+		fmt.Printf("Playlist '%s' not found\n", playlistName)
+		return
+	}
+
+	config.State.PinnedPlaylist = playlistName
+	saveConfig()
+	fmt.Printf("Pinned '%s': 'mfp play' without a playlist will play it\n", playlistName)
+}
+
+func handleUnpin() {
+	if config.State.PinnedPlaylist == "" {
+		fmt.Println("No playlist is pinned")
+		return
+	}
+
+	fmt.Printf("Unpinned '%s'\n", config.State.PinnedPlaylist)
+	config.State.PinnedPlaylist = ""
+	saveConfig()
+}
+
+func handleReorder(args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: mfp reorder <playlist_name> <position>")
+		return
+	}
+
+	playlistName := args[0]
+	if _, exists := config.Playlists[playlistName]; !exists {
+		fmt.Printf("Playlist '%s' not found\n", playlistName)
+		return
+	}
+
+	position, err := strconv.Atoi(args[1])
+	if err != nil || position < 1 || position > len(config.Playlists) {
+		fmt.Printf("Invalid position. Please use 1-%d\n", len(config.Playlists))
+		return
+	}
+
+	// Move the playlist within the current order, then renumber everything
+	var names []string
+	for _, name := range playlistNames() {
+		if name != playlistName {
+			names = append(names, name)
+		}
+	}
+	names = append(names[:position-1], append([]string{playlistName}, names[position-1:]...)...)
+	for i, name := range names {
+		config.Playlists[name].ListPosition = i + 1
+	}
+
+	saveConfig()
+	fmt.Printf("Moved playlist '%s' to position %d\n", playlistName, position)
+}
+
+func handleListSongs(args []string) {
+	args, groupBy, hasGroupBy := extractFlagValue(args, "--group-by")
+	args, showNotes := extractFlag(args, "--notes")
+	if len(args) == 0 {
+		fmt.Println("Usage: mfp songs <playlist_name> [--group-by artist] [--notes]")
+		return
+	}
+
+	playlistName := args[0]
+	playlist, exists := config.Playlists[playlistName]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", playlistName)
+		return
+	}
+
+	if hasGroupBy {
+		if groupBy != "artist" {
+			fmt.Println("Error: --group-by only supports artist")
+			return
+		}
+		if listSongsByArtist(playlistName, playlist) {
+			return
+		}
+	}
+
+	playingID, played := sessionMarkers(playlistName)
+	fmt.Printf("Songs in playlist '%s':\n", playlistName)
+	for i, song := range playlist.Songs {
+		marker := " "
+		if song.VideoID == playingID {
+			marker = "▶"
+		} else if played[song.VideoID] {
+			marker = "✓"
+		}
+		line := fmt.Sprintf("%s %d. %s (%s)", marker, i+1, song.DisplayTitle(), song.Duration)
+		if song.Skip {
+			line = colorize("2", line+" [skipped]")
+		}
+		fmt.Println(line)
+		if showNotes && song.Note != "" {
+			fmt.Println(colorize("2", "       Note: "+song.Note))
+		}
+	}
+}
+
+// sessionMarkers returns the playing song and the songs played since 'mfp play'
+// when the named playlist is the one loaded; otherwise nothing is marked
+func sessionMarkers(playlistName string) (string, map[string]bool) {
+	if !config.State.IsPlaying || config.State.CurrentPlaylist != playlistName {
+		return "", nil
+	}
+	playingID := ""
+	if playlist := currentPlaylist(); playlist != nil && len(playlist.Songs) > 0 {
+		playingID = playlist.Songs[getCurrentSongIndex()].VideoID
+	}
+	played := make(map[string]bool)
+	for _, entry := range loadHistory() {
+		if entry.Playlist == playlistName && !entry.PlayedAt.Before(config.State.PlayStarted) {
+			played[entry.VideoID] = true
+		}
+	}
+	return playingID, played
+}
+
+func handleNote(args []string) {
+	args, clear := extractFlag(args, "--clear")
+	if len(args) < 2 || len(args) > 3 || clear && len(args) != 2 {
+		fmt.Println("Usage: mfp note <playlist_name> <song_number> [\"text\"|--clear]")
+		return
+	}
+
+	playlistName := args[0]
+	playlist, exists := config.Playlists[playlistName]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", playlistName)
+		return
+	}
+	songNum, err := strconv.Atoi(args[1])
+	if err != nil || songNum < 1 || songNum > len(playlist.Songs) {
+		fmt.Printf("Invalid song number. Please use 1-%d\n", len(playlist.Songs))
+		return
+	}
+	song := &playlist.Songs[songNum-1]
+
+	if len(args) == 2 && !clear {
+		if song.Note == "" {
+			fmt.Printf("No note on song %d: %s\n", songNum, song.DisplayTitle())
+		} else {
+			fmt.Printf("%s\n  Note: %s\n", song.DisplayTitle(), song.Note)
+		}
+		return
+	}
+	if clear {
+		song.Note = ""
+	} else {
+		song.Note = strings.TrimSpace(args[2])
+	}
+
+	// Keep a --from/--to session of this playlist in step
+	if config.State.Session != nil && config.State.CurrentPlaylist == playlistName {
+		for i := range config.State.Session.Songs {
+			if config.State.Session.Songs[i].VideoID == song.VideoID {
+				config.State.Session.Songs[i].Note = song.Note
+			}
+		}
+	}
+
+	saveConfig()
+	if song.Note == "" {
+		fmt.Printf("Removed the note on song %d: %s\n", songNum, song.DisplayTitle())
+	} else {
+		fmt.Printf("Noted on song %d: %s\n", songNum, song.DisplayTitle())
+	}
+}
+
+func handleSkipAlways(args []string) {
+	args, clear := extractFlag(args, "--clear")
+	if clear {
+		cleared := 0
+		for name, playlist := range config.Playlists {
+			if len(args) > 0 && name != args[0] {
+				continue
+			}
+			for i := range playlist.Songs {
+				if playlist.Songs[i].Skip {
+					playlist.Songs[i].Skip = false
+					cleared++
+				}
+			}
+		}
+		if len(args) > 0 && config.Playlists[args[0]] == nil {
+			fmt.Printf("Playlist '%s' not found\n", args[0])
+			return
+		}
+		if config.State.Session != nil && (len(args) == 0 || args[0] == config.State.CurrentPlaylist) {
+			for i := range config.State.Session.Songs {
+				config.State.Session.Songs[i].Skip = false
+			}
+		}
+		saveConfig()
+		fmt.Printf("Cleared %d skip marks\n", cleared)
+		return
+	}
+
+	if len(args) != 2 {
+		fmt.Println("Usage: mfp skip-always <playlist_name> <song_number>")
+		fmt.Println("       mfp skip-always [playlist_name] --clear")
+		return
+	}
+
+	playlistName := args[0]
+	playlist, exists := config.Playlists[playlistName]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", playlistName)
+		return
+	}
+
+	songNum, err := strconv.Atoi(args[1])
+	if err != nil || songNum < 1 || songNum > len(playlist.Songs) {
+		fmt.Printf("Invalid song number. Please use 1-%d\n", len(playlist.Songs))
+		return
+	}
+
+	// Running the command again on a marked song unmarks it
+	song := &playlist.Songs[songNum-1]
+	song.Skip = !song.Skip
+
+	// Keep a --from/--to session of this playlist in step
+	if config.State.Session != nil && config.State.CurrentPlaylist == playlistName {
+		for i := range config.State.Session.Songs {
+			if config.State.Session.Songs[i].VideoID == song.VideoID {
+				config.State.Session.Songs[i].Skip = song.Skip
+			}
+		}
+	}
+
+	saveConfig()
+	if song.Skip {
+		fmt.Printf("Song %d will always be skipped: %s\n", songNum, song.DisplayTitle())
+	} else {
+		fmt.Printf("Song %d will no longer be skipped: %s\n", songNum, song.DisplayTitle())
+	}
+}
+
+func blacklistFile() string {
+	return filepath.Join(config.DataDir, "blacklist.json")
+}
+
+func loadBlacklist() map[string]string {
+	blacklist := make(map[string]string)
+	if data, err := ioutil.ReadFile(blacklistFile()); err == nil {
+		json.Unmarshal(data, &blacklist)
+	}
+	return blacklist
+}
+
+func saveBlacklist() error {
+	if config.ReadOnly {
+		return nil
+	}
+	data, err := json.MarshalIndent(config.Blacklist, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(blacklistFile(), data, 0644); err != nil {
+		return handleWriteError(err)
+	}
+	return nil
+}
+
+var videoIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{11}$`)
+
+func handleBlacklist(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: mfp blacklist add <video_id|url>")
+		fmt.Println("       mfp blacklist remove <video_id|url>")
+		fmt.Println("       mfp blacklist list")
+		return
+	}
+
+	videoID := ""
+	if len(args) == 2 {
+		videoID = extractVideoID(args[1])
+		if videoID == "" && videoIDPattern.MatchString(args[1]) {
+			videoID = args[1]
+		}
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) != 2 {
+			fmt.Println("Usage: mfp blacklist add <video_id|url>")
+			return
+		}
+		if videoID == "" {
+			fmt.Printf("Not a YouTube video ID or URL: %s\n", args[1])
+			return
+		}
+		if _, exists := config.Blacklist[videoID]; exists {
+			fmt.Printf("%s is already blacklisted\n", videoID)
+			return
+		}
+
+		// Remember the title from whichever playlists have the song
+		title := ""
+		var found []string
+		for _, name := range playlistNames() {
+			for _, song := range config.Playlists[name].Songs {
+				if song.VideoID == videoID {
+					title = song.DisplayTitle()
+					found = append(found, name)
+					break
+				}
+			}
+		}
+		config.Blacklist[videoID] = title
+		if err := saveBlacklist(); err != nil {
+			fmt.Printf("Error saving blacklist: %v\n", err)
+			return
+		}
+		if title != "" {
+			fmt.Printf("Blacklisted %s: %s\n", videoID, title)
+			fmt.Printf("It's in %s; playback skips it and 'mfp refresh' drops it\n", strings.Join(found, ", "))
+		} else {
+			fmt.Printf("Blacklisted %s\n", videoID)
+		}
+
+	case "remove":
+		if len(args) != 2 {
+			fmt.Println("Usage: mfp blacklist remove <video_id|url>")
+			return
+		}
+		if _, exists := config.Blacklist[videoID]; videoID == "" || !exists {
+			fmt.Printf("%s is not blacklisted\n", args[1])
+			return
+		}
+		delete(config.Blacklist, videoID)
+		if err := saveBlacklist(); err != nil {
+			fmt.Printf("Error saving blacklist: %v\n", err)
+			return
+		}
+		fmt.Printf("Removed %s from the blacklist\n", videoID)
+
+	case "list":
+		if len(config.Blacklist) == 0 {
+			fmt.Println("The blacklist is empty")
+			return
+		}
+		var ids []string
+		for id := range config.Blacklist {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		fmt.Printf("Blacklisted songs (%d):\n", len(ids))
+		for _, id := range ids {
+			if title := config.Blacklist[id]; title != "" {
+				fmt.Printf("  %s  %s\n", id, title)
+			} else {
+				fmt.Printf("  %s\n", id)
+			}
+		}
+
+	default:
+		fmt.Printf("Unknown blacklist command: %s\n", args[0])
+		fmt.Println("Usage: mfp blacklist add|remove|list")
+	}
+}
+
+// isSkipped reports whether playback should pass over song, because it's
+// marked skip-always or blacklisted
+func isSkipped(song Song) bool {
+	_, blacklisted := config.Blacklist[song.VideoID]
+	return song.Skip || blacklisted
+}
+
+// allSkipped reports whether every song in playlist is skipped in playback
+func allSkipped(playlist *Playlist) bool {
+	for _, song := range playlist.Songs {
+		if !isSkipped(song) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is a character device too, but nobody is typing into it
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// confirm asks a yes/no question on the terminal; anything but y or yes
+// (including no answer) is a no
+func confirm(prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// colorize wraps s in an ANSI SGR code (e.g. "31" for red, "2" for dim) when
+// stdout is a terminal, and returns it unchanged otherwise
+func colorize(code, s string) string {
+	if !isTerminal(os.Stdout) {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+func handleRename(args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: mfp rename <old_name> <new_name>")
+		return
+	}
+
+	oldName := args[0]
+	newName := args[1]
+
+	playlist, exists := config.Playlists[oldName]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", oldName)
+		return
+	}
+
+	if _, exists := config.Playlists[newName]; exists {
+		fmt.Printf("Playlist '%s' already exists\n", newName)
+		return
+	}
+
+	playlist.Name = newName
+	config.Playlists[newName] = playlist
+	delete(config.Playlists, oldName)
+
+	// Update current playlist name if it matches
+	if config.State.CurrentPlaylist == oldName {
+		config.State.CurrentPlaylist = newName
+	}
+	if config.State.PinnedPlaylist == oldName {
+		config.State.PinnedPlaylist = newName
+	}
+
+	saveConfig()
+	fmt.Printf("Renamed playlist '%s' to '%s'\n", oldName, newName)
+}
+
+// listSongsByArtist prints the playlist as a tree of artists, most songs
+// first. Returns false, printing nothing, when no song has an artist.
+func listSongsByArtist(playlistName string, playlist *Playlist) bool {
+	groups := make(map[string][]int)
+	var artists []string
+	for i, song := range playlist.Songs {
+		if song.Artist == "" {
+			continue
+		}
+		if groups[song.Artist] == nil {
+			artists = append(artists, song.Artist)
+		}
+		groups[song.Artist] = append(groups[song.Artist], i)
+	}
+	if len(artists) == 0 {
+		fmt.Printf("No artist info for '%s' (run 'mfp refresh %s' to fetch it), listing songs instead\n\n", playlistName, playlistName)
+		return false
+	}
+
+	sort.SliceStable(artists, func(i, j int) bool {
+		if len(groups[artists[i]]) != len(groups[artists[j]]) {
+			return len(groups[artists[i]]) > len(groups[artists[j]])
+		}
+		return strings.ToLower(artists[i]) < strings.ToLower(artists[j])
+	})
+
+	// Songs without an artist go in one group at the end
+	const unknown = "(unknown artist)"
+	for i, song := range playlist.Songs {
+		if song.Artist == "" {
+			groups[unknown] = append(groups[unknown], i)
+		}
+	}
+	if groups[unknown] != nil {
+		artists = append(artists, unknown)
+	}
+
+	fmt.Printf("Songs in playlist '%s' by artist:\n", playlistName)
+	for _, artist := range artists {
+		fmt.Printf("%s (%d)\n", artist, len(groups[artist]))
+		for j, i := range groups[artist] {
+			branch := "├─"
+			if j == len(groups[artist])-1 {
+				branch = "└─"
+			}
+			song := playlist.Songs[i]
+			line := fmt.Sprintf("  %s %d. %s (%s)", branch, i+1, song.Title, song.Duration)
+			if song.Skip {
+				line = colorize("2", line+" [skipped]")
+			}
+			fmt.Println(line)
+		}
+	}
+	return true
+}
+
+func handleRenameSong(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: mfp rename-song <new_title>")
+		return
+	}
+
+	song := currentSong()
+	if song == nil {
+		fmt.Println("No song is currently loaded")
+		return
+	}
+
+	newTitle := strings.TrimSpace(strings.Join(args, " "))
+	if newTitle == "" {
+		fmt.Println("Title cannot be empty")
+		return
+	}
+
+	oldTitle := song.Title
+	song.Title = newTitle
+
+	// A --from/--to session holds copies, so rename the saved song too
+	if config.State.Session != nil {
+		if playlist, exists := config.Playlists[config.State.CurrentPlaylist]; exists {
+			for i := range playlist.Songs {
+				if playlist.Songs[i].VideoID == song.VideoID {
+					playlist.Songs[i].Title = newTitle
+				}
+			}
+		}
+	}
+
+	saveConfig()
+	if config.State.IsPlaying {
+		updateMediaTitle()
+	}
+	fmt.Printf("Renamed '%s' to '%s'\n", oldTitle, newTitle)
+}
+
+func handleDelete(args []string) {
+	args, yes := extractFlag(args, "--yes")
+	if len(args) == 0 {
+		fmt.Println("Usage: mfp delete <playlist_name> [--yes]")
+		return
+	}
+
+	playlistName := args[0]
+	playlist, exists := config.Playlists[playlistName]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", playlistName)
+		return
+	}
+
+	// Scripts (no terminal) delete right away, as they always have
+	if !yes && isTerminal(os.Stdin) && !confirm(fmt.Sprintf("Delete playlist '%s' with %d songs?", playlistName, len(playlist.Songs))) {
+		fmt.Println("Aborted, nothing was deleted")
+		return
+	}
+
+	// Stop playback if this playlist is currently playing
+	if config.State.CurrentPlaylist == playlistName {
+		handleStop()
+		config.State.CurrentPlaylist = ""
+		config.State.Session = nil
+	}
+
+	if config.State.PinnedPlaylist == playlistName {
+		config.State.PinnedPlaylist = ""
+	}
+
+	delete(config.Playlists, playlistName)
+	saveConfig()
+	fmt.Printf("Deleted playlist '%s'\n", playlistName)
+}
+
+func handleTag(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Usage: mfp tag <playlist_name> [label] [tags...]")
+		fmt.Println("       mfp tag <playlist_name> --clear")
+		return
+	}
+
+	playlistName := args[0]
+	playlist, exists := config.Playlists[playlistName]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", playlistName)
+		return
+	}
+
+	if args[1] == "--clear" {
+		playlist.Label = ""
+		playlist.Tags = nil
+		saveConfig()
+		fmt.Printf("Cleared label and tags for playlist '%s'\n", playlistName)
+		return
+	}
+
+	// A leading emoji/symbol argument is the label, everything else is a tag
+	values := args[1:]
+	if isLabel(values[0]) {
+		playlist.Label = values[0]
+		values = values[1:]
+	}
+
+	for _, tag := range values {
+		tag = strings.ToLower(tag)
+		if !hasTag(playlist, tag) {
+			playlist.Tags = append(playlist.Tags, tag)
+		}
+	}
+
+	saveConfig()
+	fmt.Printf("Updated playlist '%s'", playlistName)
+	if playlist.Label != "" {
+		fmt.Printf(" - label: %s", playlist.Label)
+	}
+	if len(playlist.Tags) > 0 {
+		fmt.Printf(" - tags: %s", strings.Join(playlist.Tags, ", "))
+	}
+	fmt.Println()
+}
+
+func handleStatus(args []string) {
+	if _, oneline := extractFlag(args, "--oneline"); oneline {
+		fmt.Println(statusLine())
+		return
+	}
+
+	fmt.Println("MFP Status:")
+	if config.Profile != "" {
+		fmt.Printf("  Profile: %s\n", config.Profile)
+	}
+	if config.State.SessionVolume != nil {
+		fmt.Printf("  Volume: %d%% (this session, default %d%%)\n", *config.State.SessionVolume, config.State.Volume)
+	} else {
+		fmt.Printf("  Volume: %d%%\n", config.State.Volume)
+	}
+	fmt.Printf("  Shuffle: %s\n", boolToOnOff(config.State.IsShuffle))
+	if config.State.ReshuffleOnLoop {
+		fmt.Println("  Reshuffle on loop: ON")
+	}
+	if summary := eqSummary(); summary != "" {
+		fmt.Printf("  EQ: %s\n", summary)
+	}
+	if config.State.IsLoop && config.State.LoopCount > 0 {
+		fmt.Printf("  Loop: ON, pass %d/%d\n", config.State.LoopsDone+1, config.State.LoopCount)
+	} else {
+		fmt.Printf("  Loop: %s\n", boolToOnOff(config.State.IsLoop))
+	}
+
+	if config.State.CurrentPlaylist != "" {
+		fmt.Printf("  Current Playlist: %s\n", config.State.CurrentPlaylist)
+		if config.State.PinnedPlaylist != "" && config.State.PinnedPlaylist != config.State.CurrentPlaylist {
+			fmt.Printf("  Pinned Playlist: %s\n", config.State.PinnedPlaylist)
+		}
+		playlist := currentPlaylist()
+		if playlist != nil {
+			currentIndex := getCurrentSongIndex()
+			if currentIndex < len(playlist.Songs) {
+				fmt.Printf("  Current Song: %s\n", playlist.Songs[currentIndex].DisplayTitle())
+				fmt.Printf("  Position: %d/%d\n", currentIndex+1, len(playlist.Songs))
+			}
+		}
+		fmt.Printf("  Playing: %s\n", boolToOnOff(config.State.IsPlaying))
+		if config.State.PauseAfterSong {
+			fmt.Println("  Pause after this song: ON")
+		}
+		if config.State.PlayLimitAt > 0 {
+			fmt.Printf("  Limit: %s of playback left\n", time.Duration(playLimitLeft())*time.Second)
+		}
+		if config.State.IsPlaying {
+			if buffering, percent := mpvBuffering(); buffering {
+				fmt.Printf("  Buffering… %d%%\n", percent)
+			}
+		}
+	} else {
+		fmt.Println("  No playlist loaded")
+	}
+}
+
+// statusLine renders the player state on a single line for shell prompts and
+// status bars, e.g. "▶ rock 3/42 | Song Title | 1:12/3:45 | 70% S L"
+func statusLine() string {
+	playlist := currentPlaylist()
+	if playlist == nil {
+		return "■ No playlist loaded"
+	}
+
+	icon := "⏸"
+	if config.State.IsPlaying {
+		icon = "▶"
+	}
+
+	parts := []string{fmt.Sprintf("%s %s", icon, config.State.CurrentPlaylist)}
+	if song := currentSong(); song != nil {
+		parts[0] += fmt.Sprintf(" %d/%d", getCurrentSongIndex()+1, len(playlist.Songs))
+		parts = append(parts, song.DisplayTitle())
+
+		position := config.State.Position
+		if config.State.IsPlaying {
+			if pos := getMpvPosition(); pos >= 0 {
+				position = pos
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%s/%s", formatDuration(position), song.Duration))
+		if config.State.IsPlaying {
+			if buffering, percent := mpvBuffering(); buffering {
+				parts = append(parts, fmt.Sprintf("Buffering… %d%%", percent))
+			}
+		}
+	}
+
+	volume := fmt.Sprintf("%d%%", *currentVolume())
+	if *currentVolume() == 0 {
+		volume = "muted"
+	}
+	if config.State.IsShuffle {
+		volume += " S"
+	}
+	if config.State.IsLoop {
+		volume += " L"
+	}
+	parts = append(parts, volume)
+
+	return strings.Join(parts, " | ")
+}
+
+func handleDoctor() {
+	fmt.Println("MFP Doctor:")
+	problems := 0
+
+	for _, dep := range []string{"mpv", "yt-dlp", "socat"} {
+		if path, err := exec.LookPath(dep); err == nil {
+			fmt.Printf("  [OK]   %s found at %s\n", dep, path)
+		} else {
+			fmt.Printf("  [FAIL] %s not found in PATH\n", dep)
+			problems++
+		}
+	}
+
+	// Prefer the version of the mpv that's playing, if any
+	versionText := ""
+	if value, err := getMpvProperty("mpv-version"); err == nil {
+		versionText, _ = value.(string)
+	} else if output, err := exec.Command("mpv", "--version").Output(); err == nil {
+		versionText = strings.SplitN(string(output), "\n", 2)[0]
+	}
+	if version, ok := parseMpvVersion(versionText); ok {
+		if mpvVersionTooOld(version) {
+			fmt.Printf("  [WARN] mpv %d.%d.%d is older than %s; position and status may not work\n",
+				version[0], version[1], version[2], minMpvVersionString())
+		} else {
+			fmt.Printf("  [OK]   mpv %d.%d.%d\n", version[0], version[1], version[2])
+		}
+	}
+
+	if info, err := os.Stat(config.DataDir); err != nil {
+		fmt.Printf("  [FAIL] Data directory %s: %v\n", config.DataDir, err)
+		problems++
+	} else if !info.IsDir() {
+		fmt.Printf("  [FAIL] Data directory %s is not a directory\n", config.DataDir)
+		problems++
+	} else if err := checkDirWritable(config.DataDir); err != nil {
+		if isPermissionError(err) {
+			fmt.Printf("  [FAIL] Data directory %s is not writable (permission denied)\n", config.DataDir)
+			fmt.Printf("         Fix with: chmod u+w %s\n", config.DataDir)
+		} else {
+			fmt.Printf("  [FAIL] Data directory %s is not writable: %v\n", config.DataDir, err)
+		}
+		problems++
+	} else {
+		fmt.Printf("  [OK]   Data directory %s is writable\n", config.DataDir)
+	}
+
+	if problems == 0 {
+		fmt.Println("\nNo problems found")
+	} else {
+		fmt.Printf("\n%d problem(s) found\n", problems)
+	}
+}
+
+// handleRepair checks playlists.json and state.json for references that
+// don't hold up (as older versions could leave behind), fixes or resets
+// them and rewrites both files
+func handleRepair() {
+	if config.ReadOnly {
+		fmt.Println("The data directory is read-only, nothing can be repaired")
+		return
+	}
+	if getMpvPid() >= 0 {
+		fmt.Println("Stop playback before repairing ('mfp stop')")
+		return
+	}
+
+	var fixes []string
+	fix := func(format string, args ...interface{}) {
+		fixes = append(fixes, fmt.Sprintf(format, args...))
+	}
+
+	// Files that don't parse at all are set aside and started over
+	playlistsFile := filepath.Join(config.DataDir, "playlists.json")
+	if data, err := ioutil.ReadFile(playlistsFile); err == nil {
+		if err := json.Unmarshal(data, &map[string]*Playlist{}); err != nil {
+			os.Rename(playlistsFile, playlistsFile+".bak")
+			config.Playlists = make(map[string]*Playlist)
+			fix("playlists.json was unreadable (%v), moved it to playlists.json.bak", err)
+		}
+	}
+	if data, err := ioutil.ReadFile(config.StateFile); err == nil {
+		if err := json.Unmarshal(data, &PlayerState{}); err != nil {
+			os.Rename(config.StateFile, config.StateFile+".bak")
+			config.State = &PlayerState{Volume: 70, ShuffleOrder: []int{}}
+			fix("state.json was unreadable (%v), moved it to state.json.bak", err)
+		}
+	}
+
+	var names []string
+	for name := range config.Playlists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		playlist := config.Playlists[name]
+		if playlist == nil {
+			delete(config.Playlists, name)
+			fix("Removed empty playlist entry '%s'", name)
+			continue
+		}
+		if playlist.Name != name {
+			fix("Playlist '%s' was named '%s' inside, renamed to match", name, playlist.Name)
+			playlist.Name = name
+		}
+		var songs []Song
+		for _, song := range playlist.Songs {
+			if song.VideoID == "" {
+				fix("Removed a song without a video ID from '%s': %q", name, song.Title)
+				continue
+			}
+			if song.URL == "" {
+				song.URL = fmt.Sprintf("https://www.youtube.com/watch?v=%s", song.VideoID)
+				fix("Restored the URL of '%s' in '%s'", song.Title, name)
+			}
+			songs = append(songs, song)
+		}
+		playlist.Songs = songs
+	}
+
+	state := config.State
+	if state.IsPlaying {
+		state.IsPlaying = false
+		fix("Cleared a stale 'playing' flag")
+	}
+	if state.Interjection != nil && !state.IsPlaying {
+		state.Interjection = nil
+		fix("Dropped a leftover interjection")
+	}
+	if state.TempQueue != nil {
+		state.TempQueue = nil
+		fix("Dropped the leftover queue-playlist songs")
+	}
+	if state.PinnedPlaylist != "" && config.Playlists[state.PinnedPlaylist] == nil {
+		fix("Unpinned missing playlist '%s'", state.PinnedPlaylist)
+		state.PinnedPlaylist = ""
+	}
+	if state.Volume < 0 || state.Volume > 100 {
+		fix("Reset volume %d%% to 70%%", state.Volume)
+		state.Volume = 70
+	}
+	if state.Position < 0 {
+		state.Position = 0
+		fix("Reset a negative playback position")
+	}
+	if state.Session != nil && len(state.Session.Songs) == 0 {
+		state.Session = nil
+		fix("Dropped an empty play session")
+	}
+
+	if state.CurrentPlaylist != "" && state.Session == nil && config.Playlists[state.CurrentPlaylist] == nil {
+		fix("Current playlist '%s' no longer exists, cleared it", state.CurrentPlaylist)
+		state.CurrentPlaylist = ""
+		state.Session = nil
+	}
+	playlist := currentPlaylist()
+	if playlist == nil {
+		if state.CurrentSongIndex != 0 || state.Position != 0 || len(state.ShuffleOrder) > 0 || state.ShuffleIndex != 0 {
+			fix("Reset the song position and shuffle order left from no playlist")
+		}
+		state.CurrentSongIndex = 0
+		state.Position = 0
+		state.ShuffleOrder = []int{}
+		state.ShuffleIndex = 0
+	} else {
+		if state.CurrentSongIndex < 0 || state.CurrentSongIndex >= len(playlist.Songs) {
+			fix("Song index %d is out of range for %d songs, reset to 1", state.CurrentSongIndex+1, len(playlist.Songs))
+			state.CurrentSongIndex = 0
+			state.Position = 0
+		}
+
+		// The shuffle order must be a permutation of the playlist's songs
+		valid := len(state.ShuffleOrder) == len(playlist.Songs)
+		seen := make(map[int]bool)
+		for _, index := range state.ShuffleOrder {
+			if index < 0 || index >= len(playlist.Songs) || seen[index] {
+				valid = false
+			}
+			seen[index] = true
+		}
+		if !valid && (state.IsShuffle || len(state.ShuffleOrder) > 0) {
+			fix("Shuffle order didn't match the playlist's %d songs, rebuilt it", len(playlist.Songs))
+			if state.IsShuffle {
+				initShuffleOrder()
+				for i, index := range state.ShuffleOrder {
+					if index == state.CurrentSongIndex {
+						state.ShuffleIndex = i
+					}
+				}
+			} else {
+				state.ShuffleOrder = []int{}
+				state.ShuffleIndex = 0
+			}
+		} else if state.IsShuffle && (state.ShuffleIndex < 0 || state.ShuffleIndex >= len(state.ShuffleOrder)) {
+			fix("Shuffle position %d is out of range, reset to 1", state.ShuffleIndex+1)
+			state.ShuffleIndex = 0
+			state.CurrentSongIndex = state.ShuffleOrder[0]
+			state.Position = 0
+		}
+	}
+
+	if err := saveConfig(); err != nil {
+		fmt.Printf("Error saving repaired state: %v\n", err)
+		return
+	}
+	if len(fixes) == 0 {
+		fmt.Println("No problems found, state files rewritten")
+		return
+	}
+	fmt.Printf("Fixed %d problem(s):\n", len(fixes))
+	for _, f := range fixes {
+		fmt.Printf("  - %s\n", f)
+	}
+}
+
+// handleSync takes mpv's word for what's playing: its playlist order,
+// position, time and volume overwrite ours, for when state.json has drifted
+// from the player (e.g. after driving mpv directly over its socket)
+func handleSync(args []string) {
+	if len(args) > 0 {
+		fmt.Println("Usage: mfp sync")
+		return
+	}
+	if _, err := getMpvProperty("pid"); err != nil {
+		fmt.Println("mpv isn't running, there's nothing to sync from")
+		if config.State.IsPlaying {
+			fmt.Println("The state still says it's playing; 'mfp repair' clears that")
+		}
+		return
+	}
+	if config.State.Interjection != nil {
+		fmt.Println("An interjection is playing; sync once it's over")
+		return
+	}
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("No playlist is currently loaded")
+		return
+	}
+
+	describe := func() map[string]string {
+		fields := map[string]string{
+			"Playing":  boolToOnOff(config.State.IsPlaying),
+			"Volume":   fmt.Sprintf("%d%%", *currentVolume()),
+			"Position": formatDuration(config.State.Position),
+			"Song":     "none",
+		}
+		if song := currentSong(); song != nil {
+			fields["Song"] = fmt.Sprintf("%d. %s", getCurrentSongIndex()+1, song.DisplayTitle())
+		}
+		if config.State.IsShuffle {
+			fields["Shuffle order"] = fmt.Sprint(config.State.ShuffleOrder)
+		}
+		return fields
+	}
+	before := describe()
+
+	// mpv's entries are the songs' URLs, in play order and then
+	// queue-playlist. A playlist can hold the same URL more than once, so
+	// each URL maps to its songs in our play order and each entry takes the
+	// next of them.
+	byURL := make(map[string][]int)
+	for _, i := range playOrder(playlist) {
+		url := playlist.Songs[i].URL
+		byURL[url] = append(byURL[url], i)
+	}
+	var mpvOrder []int
+	unknown := 0
+	if value, err := getMpvProperty("playlist"); err == nil {
+		entries, _ := value.([]interface{})
+		for _, entry := range entries {
+			item, _ := entry.(map[string]interface{})
+			filename, _ := item["filename"].(string)
+			if len(mpvOrder) >= len(playlist.Songs) {
+				continue
+			}
+			if indices := byURL[filename]; len(indices) > 0 {
+				mpvOrder = append(mpvOrder, indices[0])
+				byURL[filename] = indices[1:]
+			} else {
+				unknown++
+			}
+		}
+	}
+	if config.State.IsShuffle && unknown == 0 && len(mpvOrder) == len(playlist.Songs) {
+		config.State.ShuffleOrder = mpvOrder
+	} else if unknown > 0 || !sameOrder(mpvOrder, playOrder(playlist)) {
+		fmt.Println("Warning: mpv's playlist doesn't match the playlist's songs; restart playback to line them up ('mfp play --restart')")
+	}
+
+	if playlistPos := getMpvPlaylistPosition(); playlistPos >= 0 && playlistPos < len(mpvOrder) {
+		if config.State.IsShuffle {
+			config.State.ShuffleIndex = playlistPos
+		}
+		config.State.CurrentSongIndex = mpvOrder[playlistPos]
+	}
+	if pos := getMpvPosition(); pos >= 0 {
+		config.State.Position = pos
+	}
+	if volume, ok := getMpvFloatProperty("volume"); ok {
+		*currentVolume() = int(volume + 0.5)
+	}
+	config.State.IsPlaying = true
+	paused := false
+	if value, err := getMpvProperty("pause"); err == nil {
+		paused = value == true
+	}
+
+	after := describe()
+	changed := false
+	for _, field := range []string{"Playing", "Song", "Position", "Volume", "Shuffle order"} {
+		if before[field] != after[field] {
+			if !changed {
+				fmt.Println("Updated from mpv:")
+			}
+			fmt.Printf("  %s: %s → %s\n", field, before[field], after[field])
+			changed = true
+		}
+	}
+	if err := saveConfig(); err != nil {
+		fmt.Printf("Error saving state: %v\n", err)
+		return
+	}
+	if !changed {
+		fmt.Println("Already in sync with mpv")
+	}
+	if paused {
+		fmt.Println("mpv is paused ('mfp play' resumes)")
+	}
+}
+
+func sameOrder(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// backupFiles are the data files carried by 'mfp backup', with a check that
+// each one still parses. Downloads aren't included since the files stay behind.
+var backupFiles = []struct {
+	name  string
+	parse func([]byte) error
+}{
+	{"playlists.json", func(data []byte) error { return json.Unmarshal(data, &map[string]*Playlist{}) }},
+	{"state.json", func(data []byte) error { return json.Unmarshal(data, &PlayerState{}) }},
+	{"config.json", func(data []byte) error { return json.Unmarshal(data, &Settings{}) }},
+	{"history.json", func(data []byte) error { return json.Unmarshal(data, &[]HistoryEntry{}) }},
+	{"blacklist.json", func(data []byte) error { return json.Unmarshal(data, &map[string]string{}) }},
+}
+
+func handleBackup(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp backup <file.tar.gz>")
+		return
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		fmt.Printf("Error creating backup: %v\n", err)
+		return
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	count := 0
+	for _, entry := range backupFiles {
+		data, err := ioutil.ReadFile(filepath.Join(config.DataDir, entry.name))
+		if err != nil {
+			continue
+		}
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(header); err != nil {
+			fmt.Printf("Error writing backup: %v\n", err)
+			return
+		}
+		if _, err := tw.Write(data); err != nil {
+			fmt.Printf("Error writing backup: %v\n", err)
+			return
+		}
+		count++
+	}
+	if err := tw.Close(); err != nil {
+		fmt.Printf("Error writing backup: %v\n", err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		fmt.Printf("Error writing backup: %v\n", err)
+		return
+	}
+	fmt.Printf("Backed up %d file(s) from %s to %s\n", count, config.DataDir, args[0])
+}
+
+// readBackup loads and checks every file in a backup before anything is
+// overwritten, so a bad archive leaves the data directory untouched
+func readBackup(path string) (map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzip archive: %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %v", err)
+		}
+		var parse func([]byte) error
+		for _, entry := range backupFiles {
+			if header.Name == entry.name {
+				parse = entry.parse
+			}
+		}
+		if parse == nil || header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry %q, this is not an mfp backup", header.Name)
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(tr, 64<<20))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", header.Name, err)
+		}
+		if err := parse(data); err != nil {
+			return nil, fmt.Errorf("%s is damaged: %v", header.Name, err)
+		}
+		files[header.Name] = data
+	}
+	if _, ok := files["playlists.json"]; !ok {
+		return nil, errors.New("no playlists.json, this is not an mfp backup")
+	}
+	return files, nil
+}
+
+func handleRestore(args []string) {
+	args, yes := extractFlag(args, "--yes")
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp restore <file.tar.gz> [--yes]")
+		return
+	}
+	if config.ReadOnly {
+		fmt.Println("The data directory is read-only, nothing can be restored")
+		return
+	}
+	if getMpvPid() >= 0 {
+		fmt.Println("Stop playback before restoring ('mfp stop')")
+		return
+	}
+
+	files, err := readBackup(args[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	var playlists map[string]*Playlist
+	json.Unmarshal(files["playlists.json"], &playlists)
+	var names []string
+	for _, entry := range backupFiles {
+		if _, ok := files[entry.name]; ok {
+			names = append(names, entry.name)
+		}
+	}
+	fmt.Printf("%s has %d playlist(s) (%s)\n", args[0], len(playlists), strings.Join(names, ", "))
+
+	if !yes && !confirm(fmt.Sprintf("Replace the data in %s with it?", config.DataDir)) {
+		fmt.Println("Aborted, nothing was changed")
+		return
+	}
+
+	for _, name := range names {
+		path := filepath.Join(config.DataDir, name)
+		if err := ioutil.WriteFile(path+".tmp", files[name], 0644); err != nil {
+			fmt.Printf("Error restoring %s: %v\n", name, err)
+			return
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			fmt.Printf("Error restoring %s: %v\n", name, err)
+			return
+		}
+	}
+	fmt.Printf("Restored %d file(s) into %s\n", len(names), config.DataDir)
+}
+
+func handleConfig(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: mfp config <get|set|unset> <key> [value]")
+		fmt.Printf("Keys: %s\n", strings.Join(settingKeys, ", "))
+		return
+	}
+
+	switch args[0] {
+	case "get":
+		if len(args) != 2 {
+			fmt.Println("Usage: mfp config get <key>")
+			return
+		}
+		value, ok := getSetting(args[1])
+		if !ok {
+			fmt.Printf("Unknown config key: %s\n", args[1])
+			return
+		}
+		if value == "" {
+			value = "(not set)"
+		}
+		fmt.Printf("%s = %s\n", args[1], value)
+	case "set":
+		if len(args) != 3 {
+			fmt.Println("Usage: mfp config set <key> <value>")
+			return
+		}
+		if err := setSetting(args[1], args[2]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := saveSettings(); err != nil {
+			fmt.Printf("Error saving config: %v\n", err)
+			return
+		}
+		value, _ := getSetting(args[1])
+		fmt.Printf("%s = %s\n", args[1], value)
+	case "unset":
+		if len(args) != 2 {
+			fmt.Println("Usage: mfp config unset <key>")
+			return
+		}
+		if err := setSetting(args[1], ""); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := saveSettings(); err != nil {
+			fmt.Printf("Error saving config: %v\n", err)
+			return
+		}
+		fmt.Printf("Unset %s\n", args[1])
+	case "show":
+		showEffectiveConfig()
+	default:
+		fmt.Println("Usage: mfp config <get|set|unset> <key> [value]")
+		fmt.Println("       mfp config show")
+	}
+}
+
+// showEffectiveConfig prints the resolved paths, programs and settings,
+// with where each setting's value comes from
+func showEffectiveConfig() {
+	fmt.Println("Paths:")
+	profile := "(default)"
+	if config.Profile != "" {
+		profile = config.Profile + " (from --profile)"
+	}
+	fmt.Printf("  Profile:         %s\n", profile)
+	fmt.Printf("  Data directory:  %s (from $HOME)\n", config.DataDir)
+	fmt.Printf("  Config file:     %s\n", config.SettingsFile)
+	fmt.Printf("  State file:      %s\n", config.StateFile)
+	fmt.Printf("  mpv socket:      %s\n", config.SocketFile)
+	if config.ReadOnly {
+		fmt.Println("  Read-only:       yes, changes are not saved this session")
+	}
+
+	fmt.Println("\nPrograms:")
+	for _, dep := range []string{"mpv", "yt-dlp", "socat", "notify-send"} {
+		if path, err := exec.LookPath(dep); err == nil {
+			fmt.Printf("  %-12s %s\n", dep, path)
+		} else {
+			fmt.Printf("  %-12s not found in $PATH\n", dep)
+		}
+	}
+
+	// A key written to config.json comes from there, anything else is the default
+	fromFile := make(map[string]json.RawMessage)
+	if data, err := ioutil.ReadFile(config.SettingsFile); err == nil {
+		json.Unmarshal(data, &fromFile)
+	}
+	fmt.Println("\nSettings:")
+	for _, key := range settingKeys {
+		value, _ := getSetting(key)
+		if value == "" {
+			value = "(not set)"
+		}
+		source := "default"
+		if _, ok := fromFile[key]; ok {
+			source = "config file"
+		}
+		fmt.Printf("  %-24s %-30s [%s]\n", key, value, source)
+	}
+
+	// Values are secrets or internal, so only say whether each is set
+	fmt.Println("\nEnvironment:")
+	for _, env := range []struct{ name, use string }{
+		{"MFP_TOKEN", "token for 'mfp serve' without --token"},
+		{"MFP_DAEMON_STATE", "internal, state handed to the playback daemon"},
+	} {
+		value := "(not set)"
+		if os.Getenv(env.name) != "" {
+			value = "(set)"
+		}
+		fmt.Printf("  %-24s %-30s [%s]\n", env.name, value, env.use)
+	}
+}
+
+// settingKeys lists the keys accepted by 'mfp config'
+var settingKeys = []string{"cookies", "format", "on_song_change", "on_play", "on_stop", "media_title_playlist", "notify", "mpv_extra_args", "fade", "lyrics_api", "lyrics_key", "history_max", "history_days", "position_save_interval", "quiet_hours", "quiet_volume", "default_volume", "ytdlp_rate", "skip_silence", "skip_silence_min", "m3u_entry", "cache_max_mb"}
+
+func getSetting(key string) (string, bool) {
+	switch key {
+	case "cookies":
+		return config.Settings.Cookies, true
+	case "format":
+		return audioFormat(), true
+	case "on_song_change":
+		return config.Settings.OnSongChange, true
+	case "on_play":
+		return config.Settings.OnPlay, true
+	case "on_stop":
+		return config.Settings.OnStop, true
+	case "media_title_playlist":
+		return boolToOnOff(config.Settings.MediaTitlePlaylist), true
+	case "notify":
+		return boolToOnOff(config.Settings.Notify), true
+	case "mpv_extra_args":
+		return strings.Join(config.Settings.MpvExtraArgs, " "), true
+	case "fade":
+		return strconv.Itoa(config.Settings.Fade), true
+	case "m3u_entry":
+		return m3uEntryTemplate(), true
+	case "lyrics_api":
+		return lyricsAPI(), true
+	case "lyrics_key":
+		if config.Settings.LyricsKey != "" {
+			return "(set)", true
+		}
+		return "", true
+	case "history_max":
+		return strconv.Itoa(config.Settings.HistoryMax), true
+	case "history_days":
+		return strconv.Itoa(config.Settings.HistoryDays), true
+	case "position_save_interval":
+		return strconv.Itoa(int(positionSaveInterval().Seconds())), true
+	case "default_volume":
+		return strconv.Itoa(defaultVolume()), true
+	case "ytdlp_rate":
+		if config.Settings.YtdlpRate == "" {
+			return "off", true
+		}
+		return config.Settings.YtdlpRate, true
+	case "skip_silence":
+		return boolToOnOff(config.Settings.SkipSilence), true
+	case "skip_silence_min":
+		return strconv.Itoa(skipSilenceMin()), true
+	case "cache_max_mb":
+		return strconv.Itoa(config.Settings.CacheMaxMB), true
+	case "quiet_hours":
+		return config.Settings.QuietHours, true
+	case "quiet_volume":
+		volume, pause := quietVolume()
+		if pause {
+			return "pause", true
+		}
+		return strconv.Itoa(volume), true
+	}
+	return "", false
+}
+
+// setSetting validates and stores a setting; an empty value resets it
+func setSetting(key, value string) error {
+	switch key {
+	case "cookies":
+		if value == "" {
+			config.Settings.Cookies = ""
+			return nil
+		}
+		path, err := expandPath(value)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err != nil {
+			fmt.Printf("Warning: cookies file %s not found, it will be ignored until it exists\n", path)
+		}
+		config.Settings.Cookies = path
+		return nil
+	case "format":
+		if value != "" && !isAllowedFormat(value) {
+			return fmt.Errorf("unsupported format %q, choose one of:\n  %s", value, strings.Join(allowedFormats, "\n  "))
+		}
+		config.Settings.Format = value
+		return nil
+	case "on_song_change", "on_play", "on_stop":
+		if value != "" {
+			path, err := expandPath(value)
+			if err != nil {
+				return err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("hook %s not found", path)
+			}
+			if info.IsDir() || info.Mode()&0111 == 0 {
+				return fmt.Errorf("hook %s is not executable", path)
+			}
+			value = path
+		}
+		switch key {
+		case "on_song_change":
+			config.Settings.OnSongChange = value
+		case "on_play":
+			config.Settings.OnPlay = value
+		case "on_stop":
+			config.Settings.OnStop = value
+		}
+		return nil
+	case "media_title_playlist":
+		enabled, err := parseOnOff(value)
+		if err != nil {
+			return err
+		}
+		config.Settings.MediaTitlePlaylist = enabled
+		return nil
+	case "notify":
+		enabled, err := parseOnOff(value)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			if _, err := exec.LookPath("notify-send"); err != nil {
+				fmt.Println("Warning: notify-send not found, notifications won't be shown until it's installed")
+			}
+		}
+		config.Settings.Notify = enabled
+		return nil
+	case "mpv_extra_args":
+		extraArgs := strings.Fields(value)
+		if err := checkMpvArgs(extraArgs); err != nil {
+			return err
+		}
+		config.Settings.MpvExtraArgs = extraArgs
+		return nil
+	case "m3u_entry":
+		if value != "" {
+			sample := Song{Title: "Title", Artist: "Artist", VideoID: "dQw4w9WgXcQ", Duration: "3:33", URL: "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}
+			if _, err := renderM3UEntry(value, sample); err != nil {
+				return err
+			}
+		}
+		config.Settings.M3UEntry = value
+		return nil
+	case "fade":
+		seconds := 0
+		if value != "" {
+			var err error
+			seconds, err = strconv.Atoi(value)
+			if err != nil || seconds < 0 || seconds > 30 {
+				return fmt.Errorf("fade must be a number of seconds from 0 to 30")
+			}
+		}
+		config.Settings.Fade = seconds
+		return nil
+	case "position_save_interval":
+		seconds := 0
+		if value != "" {
+			var err error
+			seconds, err = strconv.Atoi(strings.TrimSuffix(value, "s"))
+			if err != nil || seconds < 1 || seconds > 3600 {
+				return fmt.Errorf("position_save_interval must be a number of seconds from 1 to 3600")
+			}
+		}
+		config.Settings.PositionSaveInterval = seconds
+		return nil
+	case "ytdlp_rate":
+		if value != "" {
+			if _, _, err := parseYtdlpRate(value); err != nil {
+				return err
+			}
+		}
+		config.Settings.YtdlpRate = value
+		return nil
+	case "default_volume":
+		if value == "" {
+			config.Settings.DefaultVolume = nil
+			return nil
+		}
+		volume, err := strconv.Atoi(value)
+		if err != nil || volume < 0 || volume > 100 {
+			return fmt.Errorf("default_volume must be between 0 and 100")
+		}
+		config.Settings.DefaultVolume = &volume
+		return nil
+	case "skip_silence":
+		enabled, err := parseOnOff(value)
+		if err != nil {
+			return err
+		}
+		config.Settings.SkipSilence = enabled
+		return nil
+	case "skip_silence_min":
+		seconds := 0
+		if value != "" {
+			var err error
+			seconds, err = strconv.Atoi(strings.TrimSuffix(value, "s"))
+			if err != nil || seconds < 1 || seconds > 600 {
+				return fmt.Errorf("skip_silence_min must be a number of seconds from 1 to 600")
+			}
+		}
+		config.Settings.SkipSilenceMin = seconds
+		return nil
+	case "cache_max_mb":
+		mb := 0
+		if value != "" {
+			var err error
+			mb, err = strconv.Atoi(strings.TrimSuffix(strings.ToUpper(value), "MB"))
+			if err != nil || mb < 0 {
+				return fmt.Errorf("cache_max_mb must be a number of megabytes, or 0 for no limit")
+			}
+		}
+		config.Settings.CacheMaxMB = mb
+		return nil
+	case "quiet_hours":
+		if value != "" {
+			if _, _, err := parseQuietHours(value); err != nil {
+				return err
+			}
+		}
+		config.Settings.QuietHours = value
+		return nil
+	case "quiet_volume":
+		if value != "" && value != "pause" {
+			volume, err := strconv.Atoi(value)
+			if err != nil || volume < 0 || volume > 100 {
+				return fmt.Errorf("quiet_volume must be 0-100 or 'pause'")
+			}
+		}
+		config.Settings.QuietVolume = value
+		return nil
+	case "lyrics_api":
+		if value != "" {
+			parsed, err := url.Parse(value)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("lyrics_api must be an http(s) URL")
+			}
+		}
+		config.Settings.LyricsAPI = strings.TrimSuffix(value, "/")
+		return nil
+	case "lyrics_key":
+		config.Settings.LyricsKey = value
+		return nil
+	case "history_max", "history_days":
+		limit := 0
+		if value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				return fmt.Errorf("%s must be a whole number, 0 for no limit", key)
+			}
+		}
+		if key == "history_max" {
+			config.Settings.HistoryMax = limit
+		} else {
+			config.Settings.HistoryDays = limit
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown config key: %s", key)
+}
+
+// Helper functions
+
+// reservedMpvArgs are set by mfp itself; overriding them would break IPC
+// or the mapping between mpv's playlist and mfp's song indices
+var reservedMpvArgs = []string{"--input-ipc-server", "--playlist", "--playlist-start", "--shuffle"}
+
+// checkMpvArgs rejects user-supplied mpv arguments that are not options or
+// that override one of reservedMpvArgs
+func checkMpvArgs(args []string) error {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			return fmt.Errorf("mpv argument %q must be an option like --name=value", arg)
+		}
+		name := strings.SplitN(arg, "=", 2)[0]
+		for _, reserved := range reservedMpvArgs {
+			if name == reserved || name == "--no-"+strings.TrimPrefix(reserved, "--") {
+				return fmt.Errorf("mpv argument %s is managed by mfp and can't be overridden", name)
+			}
+		}
+	}
+	return nil
+}
+
+// extractFlagValues removes every occurrence of a repeatable --name value /
+// --name=value flag from args and returns their values in order
+func extractFlagValues(args []string, name string) ([]string, []string) {
+	var rest, values []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == name && i+1 < len(args) {
+			values = append(values, args[i+1])
+			i++
+			continue
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			values = append(values, strings.TrimPrefix(arg, name+"="))
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, values
+}
+
+// expandPath resolves a leading ~ and makes path absolute
+func expandPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(homeDir, path[1:])
+	}
+	return filepath.Abs(path)
+}
+
+// cookiesFile returns the configured cookies file, or "" if unset or missing
+func cookiesFile() string {
+	if config.Settings.Cookies == "" {
+		return ""
+	}
+	if _, err := os.Stat(config.Settings.Cookies); err != nil {
+		fmt.Printf("Warning: cookies file %s not found, continuing without it\n", config.Settings.Cookies)
+		return ""
+	}
+	return config.Settings.Cookies
+}
+
+// parseOnOff parses a boolean setting; an empty value means off
+func parseOnOff(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off, got %q", value)
+}
+
+func isAllowedFormat(format string) bool {
+	for _, allowed := range allowedFormats {
+		if format == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// currentVolume is the volume in effect: this session's 'play --volume' if
+// one was given, otherwise the saved one
+func currentVolume() *int {
+	if config.State.SessionVolume != nil {
+		return config.State.SessionVolume
+	}
+	return &config.State.Volume
+}
+
+func defaultVolume() int {
+	if config.Settings.DefaultVolume == nil {
+		return 70
+	}
+	return *config.Settings.DefaultVolume
+}
+
+const defaultQuietVolume = 20
+
+// parseQuietHours reads "HH:MM-HH:MM" into minutes since midnight. The end
+// may be earlier than the start for a window that crosses midnight.
+func parseQuietHours(value string) (int, int, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) == 2 {
+		start, errStart := time.Parse("15:04", strings.TrimSpace(parts[0]))
+		end, errEnd := time.Parse("15:04", strings.TrimSpace(parts[1]))
+		if errStart == nil && errEnd == nil {
+			startMinute := start.Hour()*60 + start.Minute()
+			endMinute := end.Hour()*60 + end.Minute()
+			if startMinute == endMinute {
+				return 0, 0, fmt.Errorf("quiet hours must start and end at different times")
+			}
+			return startMinute, endMinute, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid quiet hours %q, use e.g. 22:00-07:00", value)
+}
+
+func inQuietHours(now time.Time) bool {
+	if config.Settings.QuietHours == "" {
+		return false
+	}
+	start, end, err := parseQuietHours(config.Settings.QuietHours)
+	if err != nil {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// quietVolume returns the volume cap for quiet hours, or pause=true when
+// playback should pause instead
+func quietVolume() (volume int, pause bool) {
+	switch value := config.Settings.QuietVolume; value {
+	case "":
+		return defaultQuietVolume, false
+	case "pause":
+		return 0, true
+	default:
+		volume, err := strconv.Atoi(value)
+		if err != nil {
+			return defaultQuietVolume, false
+		}
+		return volume, false
+	}
+}
+
+// defaultSkipSilenceMin is how many seconds of silence skip_silence waits
+// for when skip_silence_min is unset
+const defaultSkipSilenceMin = 5
+
+// silenceSeekStep is how far each skip jumps; a gap longer than that is
+// detected again and skipped in further steps
+const silenceSeekStep = 10
+
+func skipSilenceMin() int {
+	if config.Settings.SkipSilenceMin > 0 {
+		return config.Settings.SkipSilenceMin
+	}
+	return defaultSkipSilenceMin
+}
+
+// silenceFilter is the mpv filter that reports silent gaps in its
+// af-metadata, or "" when skip_silence is off
+func silenceFilter() string {
+	if !config.Settings.SkipSilence {
+		return ""
+	}
+	return fmt.Sprintf("@mfpsilence:lavfi=[silencedetect=noise=-50dB:duration=%d]", skipSilenceMin())
+}
+
+func handleSkipSilence(args []string) {
+	args, minValue, hasMin := extractFlagValue(args, "--min")
+	if len(args) > 1 {
+		fmt.Println("Usage: mfp skip-silence [on|off] [--min <seconds>]")
+		return
+	}
+	if len(args) == 0 && !hasMin {
+		fmt.Printf("Skip silence: %s (gaps of %ds or more)\n", boolToOnOff(config.Settings.SkipSilence), skipSilenceMin())
+		return
+	}
+	if len(args) == 1 {
+		if err := setSetting("skip_silence", args[0]); err != nil {
+			fmt.Println("Usage: mfp skip-silence [on|off] [--min <seconds>]")
+			return
+		}
+	}
+	if hasMin {
+		if err := setSetting("skip_silence_min", minValue); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+	}
+	if err := saveSettings(); err != nil {
+		fmt.Printf("Error saving config: %v\n", err)
+		return
+	}
+	fmt.Printf("Skip silence: %s (gaps of %ds or more)\n", boolToOnOff(config.Settings.SkipSilence), skipSilenceMin())
+}
+
+// playedSeconds is PlayedSeconds brought up to now. The daemon counts it
+// every second but saves it only every position_save_interval (and on
+// pause or resume), so add the time since if mpv is playing.
+func playedSeconds() int {
+	played := config.State.PlayedSeconds
+	if config.State.IsPlaying && config.State.PlayLimitAt > 0 && !config.State.PlayedAsOf.IsZero() {
+		if paused, err := getMpvProperty("pause"); err == nil && paused == false {
+			played += int(time.Since(config.State.PlayedAsOf).Seconds())
+		}
+	}
+	return played
+}
+
+// playLimitLeft is the playback left before 'mfp limit' stops it
+func playLimitLeft() int {
+	left := config.State.PlayLimitAt - playedSeconds()
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+func handleLimit(args []string) {
+	if len(args) == 0 {
+		if config.State.PlayLimitAt == 0 {
+			fmt.Println("No playback limit set")
+			return
+		}
+		fmt.Printf("Playback stops after %s more of playing\n", time.Duration(playLimitLeft())*time.Second)
+		return
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp limit [<duration>|off]")
+		return
+	}
+
+	if args[0] == "off" {
+		config.State.PlayLimitAt = 0
+		saveConfig()
+		fmt.Println("Playback limit cleared")
+		return
+	}
+	limit, err := time.ParseDuration(args[0])
+	if err != nil || limit < time.Minute {
+		fmt.Println("Error: the limit must be a duration of at least 1m, e.g. 45m, 2h or 1h30m")
+		return
+	}
+	// Counted from now; a limit set before playing starts with the session
+	if !config.State.IsPlaying {
+		config.State.PlayedSeconds = 0
+		config.State.PlayedAsOf = time.Time{}
+	}
+	config.State.PlayLimitAt = playedSeconds() + int(limit.Seconds())
+	saveConfig()
+	fmt.Printf("Playback will stop after %s of playing (pauses don't count)\n", limit)
+}
+
+func handleQuietHours(args []string) {
+	if len(args) == 0 {
+		if config.Settings.QuietHours == "" {
+			fmt.Println("Quiet hours are off")
+			return
+		}
+		volume, pause := quietVolume()
+		action := fmt.Sprintf("volume capped at %d%%", volume)
+		if pause {
+			action = "playback paused"
+		}
+		fmt.Printf("Quiet hours: %s (%s)\n", config.Settings.QuietHours, action)
+		if inQuietHours(time.Now()) {
+			fmt.Println("Quiet hours are in effect now")
+		}
+		return
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp quiet-hours [HH:MM-HH:MM|off]")
+		return
+	}
+
+	value := args[0]
+	if value == "off" {
+		value = ""
+	}
+	if err := setSetting("quiet_hours", value); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if err := saveSettings(); err != nil {
+		fmt.Printf("Error saving config: %v\n", err)
+		return
+	}
+	if value == "" {
+		fmt.Println("Quiet hours turned off")
+	} else {
+		fmt.Printf("Quiet hours set to %s\n", value)
+	}
+}
+
+const defaultPositionSaveInterval = 10 * time.Second
+
+func positionSaveInterval() time.Duration {
+	if config.Settings.PositionSaveInterval <= 0 {
+		return defaultPositionSaveInterval
+	}
+	return time.Duration(config.Settings.PositionSaveInterval) * time.Second
+}
+
+// audioFormat returns the configured format, falling back to the default
+// if it's unset or was edited to something unsupported
+func audioFormat() string {
+	if config.Settings.Format == "" || !isAllowedFormat(config.Settings.Format) {
+		return defaultFormat
+	}
+	return config.Settings.Format
+}
+
+// currentSong returns the song at the current position, or nil if none is loaded
+func currentSong() *Song {
+	playlist := currentPlaylist()
+	if playlist == nil {
+		return nil
+	}
+	index := getCurrentSongIndex()
+	if index < 0 || index >= len(playlist.Songs) {
+		return nil
+	}
+	return &playlist.Songs[index]
+}
+
+// updateMediaTitle makes mpv show our stored title (what 'mfp songs' lists)
+// instead of the raw stream title
+func updateMediaTitle() {
+	song := currentSong()
+	if song == nil {
+		return
+	}
+	title := song.Title
+	if config.Settings.MediaTitlePlaylist {
+		title = config.State.CurrentPlaylist + ": " + title
+	}
+	sendMpvCommandArgs("set_property", "force-media-title", title)
+}
+
+// mpvBuffering reports whether mpv has paused to fill its cache, and how
+// full the cache is in percent
+func mpvBuffering() (bool, int) {
+	value, err := getMpvProperty("paused-for-cache")
+	if buffering, ok := value.(bool); err != nil || !ok || !buffering {
+		return false, 0
+	}
+	percent, _ := getMpvFloatProperty("cache-buffering-state")
+	return true, int(percent)
+}
+
+// bufferingNotifyAfter is how long playback may stall on the cache before
+// the daemon says it's the network
+const bufferingNotifyAfter = 10 * time.Second
+
+// songSettleDelay is how long a song must play before it gets a desktop
+// notification and a history entry, so skipping quickly past songs
+// doesn't record every one
+const songSettleDelay = 2 * time.Second
+
+func notifyBuffering(song Song) {
+	if !config.Settings.Notify {
+		return
+	}
+	if _, err := exec.LookPath("notify-send"); err != nil {
+		return
+	}
+	exec.Command("notify-send", "--app-name=mfp", "Buffering", fmt.Sprintf("%s\nThe network is slow, waiting for the stream", song.DisplayTitle())).Run()
+}
+
+// notifySongChange shows a desktop notification for song, with its thumbnail
+// as the icon when it can be fetched
+func notifySongChange(song Song, playlistName string) {
+	if !config.Settings.Notify {
+		return
+	}
+	if _, err := exec.LookPath("notify-send"); err != nil {
+		return
+	}
+
+	args := []string{"--app-name=mfp"}
+	if thumb, err := thumbnailPath(song.VideoID); err == nil {
+		args = append(args, "-i", thumb)
+	}
+	args = append(args, "Now playing", fmt.Sprintf("%s\n%s", song.DisplayTitle(), playlistName))
+	exec.Command("notify-send", args...).Run()
+}
+
+// thumbnailPath returns the cached thumbnail for a video, downloading it into
+// DataDir/thumbs on first use
+func thumbnailPath(videoID string) (string, error) {
+	thumbsDir := filepath.Join(config.DataDir, "thumbs")
+	path := filepath.Join(thumbsDir, videoID+".jpg")
+	if _, err := os.Stat(path); err == nil {
+		touchCacheFile(path)
+		return path, nil
+	}
+	if config.ReadOnly {
+		return "", fmt.Errorf("data directory is read-only")
+	}
+	if err := os.MkdirAll(thumbsDir, 0755); err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", url.PathEscape(videoID)))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("thumbnail download failed: %s", resp.Status)
+	}
+
+	// Write to a temp file first so an interrupted download isn't cached
+	tmp, err := ioutil.TempFile(thumbsDir, ".thumb-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return path, nil
+}
+
+// artShades are the grayscale blocks of 'mfp art --no-color', dark to light
+var artShades = []rune(" ░▒▓█")
+
+func handleArt(args []string) {
+	args, noColor := extractFlag(args, "--no-color")
+	args, widthValue, hasWidth := extractFlagValue(args, "--width")
+	if len(args) > 0 {
+		fmt.Println("Usage: mfp art [--width N] [--no-color]")
+		return
+	}
+	width := 48
+	if hasWidth {
+		n, err := strconv.Atoi(widthValue)
+		if err != nil || n < 8 || n > 200 {
+			fmt.Println("Error: --width must be between 8 and 200")
+			return
+		}
+		width = n
+	}
+
+	song := currentSong()
+	if in := config.State.Interjection; in != nil && in.Started {
+		song = &in.Song
+	}
+	if song == nil {
+		fmt.Println("No song is currently loaded")
+		return
+	}
+
+	path, err := thumbnailPath(song.VideoID)
+	if err != nil {
+		fmt.Printf("Error fetching the thumbnail: %v\n", err)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("Error reading the thumbnail: %v\n", err)
+		return
+	}
+	img, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		fmt.Printf("Error decoding the thumbnail: %v\n", err)
+		return
+	}
+
+	color := !noColor && isTerminal(os.Stdout)
+	for _, line := range renderArt(img, width, color) {
+		fmt.Println(line)
+	}
+	fmt.Println(song.DisplayTitle())
+}
+
+// renderArt draws img width characters wide; each character is two pixels
+// stacked, as a half block in 24-bit color or a grayscale shade
+func renderArt(img image.Image, width int, color bool) []string {
+	bounds := trimLetterbox(img)
+	height := width * bounds.Dy() / bounds.Dx()
+	if height < 2 {
+		height = 2
+	}
+
+	// Average the source pixels falling in each cell of a width x height grid
+	pixel := func(x, y int) (r, g, b int) {
+		x0 := bounds.Min.X + x*bounds.Dx()/width
+		x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		n := 0
+		for py := y0; py < y1 || py == y0; py++ {
+			for px := x0; px < x1 || px == x0; px++ {
+				pr, pg, pb, _ := img.At(px, py).RGBA()
+				r, g, b = r+int(pr>>8), g+int(pg>>8), b+int(pb>>8)
+				n++
+			}
+		}
+		return r / n, g / n, b / n
+	}
+
+	var lines []string
+	for y := 0; y+1 < height; y += 2 {
+		var line strings.Builder
+		for x := 0; x < width; x++ {
+			tr, tg, tb := pixel(x, y)
+			br, bg, bb := pixel(x, y+1)
+			if color {
+				fmt.Fprintf(&line, "\033[38;2;%d;%d;%dm\033[48;2;%d;%d;%dm▀", tr, tg, tb, br, bg, bb)
+				continue
+			}
+			luma := (tr*299 + tg*587 + tb*114 + br*299 + bg*587 + bb*114) / 2000
+			line.WriteRune(artShades[luma*len(artShades)/256])
+		}
+		if color {
+			line.WriteString("\033[0m")
+		}
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+// trimLetterbox crops the black bars YouTube puts above and below 16:9
+// videos in their 4:3 thumbnails
+func trimLetterbox(img image.Image) image.Rectangle {
+	bounds := img.Bounds()
+	dark := func(y int) bool {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 4 {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if r>>8 > 24 || g>>8 > 24 || b>>8 > 24 {
+				return false
+			}
+		}
+		return true
+	}
+	top, bottom := bounds.Min.Y, bounds.Max.Y
+	for top < bottom-1 && dark(top) {
+		top++
+	}
+	for bottom-1 > top && dark(bottom-1) {
+		bottom--
+	}
+	// A mostly dark picture isn't letterboxed, keep it whole
+	if bottom-top < bounds.Dy()/2 {
+		return bounds
+	}
+	return image.Rect(bounds.Min.X, top, bounds.Max.X, bottom)
+}
+
+const defaultLyricsAPI = "https://lrclib.net/api"
+
+func lyricsAPI() string {
+	if config.Settings.LyricsAPI != "" {
+		return config.Settings.LyricsAPI
+	}
+	return defaultLyricsAPI
+}
+
+func handleLyrics() {
+	song := currentSong()
+	if song == nil {
+		fmt.Println("No song is currently loaded")
+		return
+	}
+
+	lyrics, err := fetchLyrics(*song)
+	if err != nil {
+		fmt.Printf("Error fetching lyrics: %v\n", err)
+		return
+	}
+	if lyrics == "" {
+		fmt.Printf("No lyrics found for %s\n", song.DisplayTitle())
+		return
+	}
+
+	fmt.Printf("%s\n\n%s\n", song.DisplayTitle(), strings.TrimSpace(lyrics))
+}
+
+// fetchLyrics returns the lyrics for song from the cache in DataDir/lyrics,
+// or looks them up with the lyrics API's search endpoint and caches them.
+// An empty result with no error means the API has no lyrics for it.
+func fetchLyrics(song Song) (string, error) {
+	lyricsDir := filepath.Join(config.DataDir, "lyrics")
+	path := filepath.Join(lyricsDir, song.VideoID+".txt")
+	if data, err := ioutil.ReadFile(path); err == nil {
+		return string(data), nil
+	}
+
+	query := url.Values{}
+	query.Set("track_name", lyricsTrackName(song.Title))
+	if song.Artist != "" {
+		query.Set("artist_name", song.Artist)
+	}
+	req, err := http.NewRequest("GET", lyricsAPI()+"/search?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "mfp (https://github.com/Tanmay-312/mfp)")
+	if config.Settings.LyricsKey != "" {
+		req.Header.Set("Authorization", "Bearer "+config.Settings.LyricsKey)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("lyrics API returned %s", resp.Status)
+	}
+
+	var results []struct {
+		PlainLyrics string `json:"plainLyrics"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return "", fmt.Errorf("unexpected lyrics API response: %v", err)
+	}
+	lyrics := ""
+	for _, result := range results {
+		if result.PlainLyrics != "" {
+			lyrics = result.PlainLyrics
+			break
+		}
+	}
+	if lyrics == "" {
+		return "", nil
+	}
+
+	if !config.ReadOnly && os.MkdirAll(lyricsDir, 0755) == nil {
+		ioutil.WriteFile(path, []byte(lyrics), 0644)
+	}
+	return lyrics, nil
+}
+
+var lyricsNoiseRegex = regexp.MustCompile(`(?i)\s*[(\[][^)\]]*(official|video|audio|lyrics?|visualizer|hd|4k)[^)\]]*[)\]]`)
+
+// lyricsTrackName strips the "(Official Video)"-style suffixes YouTube titles
+// often carry, which would otherwise make the lookup miss
+func lyricsTrackName(title string) string {
+	return strings.TrimSpace(lyricsNoiseRegex.ReplaceAllString(title, ""))
+}
+
+// runHook starts the user's hook script for an event without waiting for it,
+// so a slow script can't stall playback. Song details are passed in MFP_*
+// environment variables.
+func runHook(event, hook string) {
+	if hook == "" {
+		return
+	}
+
+	env := append(os.Environ(),
+		"MFP_EVENT="+event,
+		"MFP_PLAYLIST="+config.State.CurrentPlaylist,
+	)
+	if song := currentSong(); song != nil {
+		env = append(env,
+			"MFP_TITLE="+song.Title,
+			"MFP_ARTIST="+song.Artist,
+			"MFP_VIDEO_ID="+song.VideoID,
+			"MFP_URL="+song.URL,
+			"MFP_DURATION="+song.Duration,
+			"MFP_INDEX="+strconv.Itoa(getCurrentSongIndex()+1),
+		)
+	}
+
+	cmd := exec.Command(hook)
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		fmt.Printf("Error running %s hook: %v\n", event, err)
+		return
+	}
+	// Reap the process in the background so it doesn't linger as a zombie
+	go cmd.Wait()
+}
+
+// parseYtdlpRate reads "N/m" or "N/h" into a number of fetches and the
+// period they're spread over; "off" gives 0
+func parseYtdlpRate(value string) (int, time.Duration, error) {
+	if value == "off" {
+		return 0, 0, nil
+	}
+	parts := strings.Split(value, "/")
+	if len(parts) == 2 {
+		count, err := strconv.Atoi(parts[0])
+		if err == nil && count > 0 {
+			switch parts[1] {
+			case "m", "min":
+				return count, time.Minute, nil
+			case "h":
+				return count, time.Hour, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid ytdlp_rate %q, use e.g. 20/m, 300/h or off", value)
+}
+
+// ytdlpBucket is the token bucket shared by every mfp process through
+// ytdlp-rate.json
+type ytdlpBucket struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+// waitForYtdlp blocks until the ytdlp_rate limit, if one is set, allows
+// another yt-dlp fetch from YouTube. Bursts up to the per-period count go
+// straight through; after that fetches are spaced out evenly, across all
+// running mfp commands so scripted bulk imports don't get the IP throttled.
+func waitForYtdlp() {
+	rate := config.Settings.YtdlpRate
+	if rate == "" {
+		return
+	}
+	count, period, err := parseYtdlpRate(rate)
+	if err != nil || count == 0 {
+		return
+	}
+	perSecond := float64(count) / period.Seconds()
+
+	path := filepath.Join(config.DataDir, "ytdlp-rate.json")
+	if config.ReadOnly {
+		path = tempFilePath(config.Profile, "ytdlp-rate.json")
+	}
+	told := false
+	for {
+		lock, err := lockFile("ytdlp-rate.lock")
+		if err != nil {
+			return
+		}
+		bucket := ytdlpBucket{Tokens: float64(count), Updated: time.Now()}
+		if data, err := ioutil.ReadFile(path); err == nil {
+			json.Unmarshal(data, &bucket)
+		}
+		now := time.Now()
+		bucket.Tokens += now.Sub(bucket.Updated).Seconds() * perSecond
+		if bucket.Tokens > float64(count) {
+			bucket.Tokens = float64(count)
+		}
+		bucket.Updated = now
+
+		wait := time.Duration(0)
+		if bucket.Tokens >= 1 {
+			bucket.Tokens--
+		} else {
+			wait = time.Duration((1 - bucket.Tokens) / perSecond * float64(time.Second))
+		}
+		if data, err := json.Marshal(bucket); err == nil {
+			ioutil.WriteFile(path, data, 0644)
+		}
+		lock.Close()
+
+		if wait == 0 {
+			return
+		}
+		if !told && wait > time.Second {
+			fmt.Printf("Waiting %s before the next YouTube fetch (ytdlp_rate %s)...\n", wait.Round(time.Second), rate)
+			told = true
+		}
+		time.Sleep(wait)
+	}
+}
+
+// ytdlpArgs prepends the options shared by every yt-dlp call to args
+func ytdlpArgs(args ...string) []string {
+	if cookies := cookiesFile(); cookies != "" {
+		args = append([]string{"--cookies", cookies}, args...)
+	}
+	return args
+}
+
+func boolToOnOff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// extractFlag removes a boolean flag from args and reports whether it was present
+func extractFlag(args []string, name string) ([]string, bool) {
+	var rest []string
+	found := false
+	for _, arg := range args {
+		if arg == name {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, found
+}
+
+// extractFlagValue removes a "--name value" or "--name=value" flag from args
+// and returns its value
+func extractFlagValue(args []string, name string) ([]string, string, bool) {
+	var rest []string
+	value := ""
+	found := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == name && i+1 < len(args) {
+			value = args[i+1]
+			found = true
+			i++
+			continue
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			value = strings.TrimPrefix(arg, name+"=")
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, value, found
+}
+
+// isLabel reports whether s looks like a playlist label (emoji or symbols, no letters or digits)
+func isLabel(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func hasTag(playlist *Playlist, tag string) bool {
+	for _, t := range playlist.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// durationSeconds parses a yt-dlp duration string such as "3:05" or
+// "1:02:03" into seconds
+func durationSeconds(duration string) (int, bool) {
+	parts := strings.Split(duration, ":")
+	if len(parts) > 3 {
+		return 0, false
+	}
+	seconds := 0
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, true
+}
+
+func formatDuration(seconds int) string {
+	minutes := seconds / 60
+	seconds = seconds % 60
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
+}
+
+func isValidPlaylistURL(url string) bool {
+	playlistRegex := regexp.MustCompile(`(?i)(?:youtube\.com/playlist\?list=|youtu\.be/playlist\?list=)([a-zA-Z0-9_-]+)`)
+	return playlistRegex.MatchString(url)
+}
+
+func extractVideoID(url string) string {
+	videoRegex := regexp.MustCompile(`(?i)(?:youtube\.com/watch\?(?:.*&)?v=|youtu\.be/|music\.youtube\.com/watch\?(?:.*&)?v=)([a-zA-Z0-9_-]{11})`)
+	matches := videoRegex.FindStringSubmatch(url)
+	if len(matches) > 1 {
+		return matches[1]
+	}
+	return ""
+}
+
+func extractPlaylistID(url string) string {
+	playlistRegex := regexp.MustCompile(`(?i)(?:youtube\.com/playlist\?list=|youtu\.be/playlist\?list=)([a-zA-Z0-9_-]+)`)
+	matches := playlistRegex.FindStringSubmatch(url)
+	if len(matches) > 1 {
+		return matches[1]
+	}
+	return ""
+}
+
+func fetchPlaylistSongs(playlistID string, order string) ([]Song, error) {
+	// Use yt-dlp to fetch playlist information
+	waitForYtdlp()
+	cmd := exec.Command("yt-dlp", ytdlpArgs("--flat-playlist", "--print", "%(title)s|%(id)s|%(duration_string)s|%(artist,uploader)s", "--playlist-end", "100", fmt.Sprintf("https://www.youtube.com/playlist?list=%s", playlistID))...)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch playlist: %v", ytdlpError(err))
+	}
+
+	lines := strings.Split(string(output), "\n")
+	var songs []Song
+
+	blacklisted := 0
+	for _, line := range lines {
+		if song, ok := parseSongLine(line); ok {
+			if _, ok := config.Blacklist[song.VideoID]; ok {
+				blacklisted++
+				continue
+			}
+			songs = append(songs, song)
+		}
+	}
+
+	if len(songs) == 0 {
+		if blacklisted > 0 {
+			return nil, fmt.Errorf("every song in the playlist is blacklisted")
+		}
+		return nil, fmt.Errorf("no songs found in playlist")
+	}
+
+	switch order {
+	case "reverse":
+		for i, j := 0, len(songs)-1; i < j; i, j = i+1, j-1 {
+			songs[i], songs[j] = songs[j], songs[i]
+		}
+	case "shuffle":
+		rand.Shuffle(len(songs), func(i, j int) {
+			songs[i], songs[j] = songs[j], songs[i]
+		})
+	}
+
+	return songs, nil
+}
+
+// ytdlpProblems maps pieces of yt-dlp's error output to what they mean for
+// the user; the first match wins
+var ytdlpProblems = []struct {
+	patterns []string
+	message  string
+}{
+	{[]string{"available in your country", "geo restrict", "geo-restrict", "blocked it in your country"},
+		"blocked in your country (geo-restricted); it can only be played from another region"},
+	{[]string{"confirm your age", "age-restricted", "inappropriate for some users"},
+		"age-restricted; set cookies from a signed-in browser with 'mfp config set cookies <file>'"},
+	{[]string{"not a bot"},
+		"YouTube wants a signed-in session; set cookies with 'mfp config set cookies <file>' or limit fetches with ytdlp_rate"},
+	{[]string{"members-only", "join this channel"},
+		"members-only; set cookies from an account with access with 'mfp config set cookies <file>'"},
+	{[]string{"private video", "playlist is private", "playlist does not exist", "this video is private"},
+		"private or deleted; private playlists need cookies from the owner's account ('mfp config set cookies <file>')"},
+	{[]string{"video unavailable", "has been removed", "account associated with this video has been terminated"},
+		"unavailable (removed or deleted from YouTube)"},
+	{[]string{"http error 429", "too many requests"},
+		"YouTube is rate-limiting this connection; wait a while or limit fetches with ytdlp_rate"},
+	{[]string{"failed to resolve", "name or service not known", "timed out", "network is unreachable", "connection refused"},
+		"could not reach YouTube; check your network connection"},
+}
+
+// ytdlpError turns a failed yt-dlp run into a readable error: a known
+// problem gets an explanation, anything else yt-dlp's last error line
+func ytdlpError(err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("yt-dlp is not installed (run 'mfp doctor')")
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || len(exitErr.Stderr) == 0 {
+		return err
+	}
+	return describeYtdlpOutput(string(exitErr.Stderr))
+}
+
+// describeYtdlpOutput explains yt-dlp error output, from yt-dlp itself or as
+// relayed by mpv
+func describeYtdlpOutput(output string) error {
+	lower := strings.ToLower(output)
+	for _, problem := range ytdlpProblems {
+		for _, pattern := range problem.patterns {
+			if strings.Contains(lower, pattern) {
+				return errors.New(problem.message)
+			}
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return fmt.Errorf("%s", strings.TrimPrefix(lines[len(lines)-1], "ERROR: "))
+}
+
+func isValidOrder(order string) bool {
+	return order == "original" || order == "reverse" || order == "shuffle"
+}
+
+// mergeShuffledSongs keeps the persisted order of existing songs that are
+// still in the playlist and appends newly fetched songs in random order
+func mergeShuffledSongs(existing, fetched []Song) []Song {
+	fetchedIDs := make(map[string]bool)
+	for _, song := range fetched {
+		fetchedIDs[song.VideoID] = true
+	}
+	existingIDs := make(map[string]bool)
+
+	var merged []Song
+	for _, song := range existing {
+		existingIDs[song.VideoID] = true
+		if fetchedIDs[song.VideoID] {
+			merged = append(merged, song)
+		}
+	}
+
+	var newSongs []Song
+	for _, song := range fetched {
+		if !existingIDs[song.VideoID] {
+			newSongs = append(newSongs, song)
+		}
+	}
+	rand.Shuffle(len(newSongs), func(i, j int) {
+		newSongs[i], newSongs[j] = newSongs[j], newSongs[i]
+	})
+
+	return append(merged, newSongs...)
+}
+
+// diffSongs compares two versions of a playlist by VideoID
+func diffSongs(before, after []Song) (added, removed []Song) {
+	beforeIDs := make(map[string]bool)
+	for _, song := range before {
+		beforeIDs[song.VideoID] = true
+	}
+	afterIDs := make(map[string]bool)
+	for _, song := range after {
+		afterIDs[song.VideoID] = true
+		if !beforeIDs[song.VideoID] {
+			added = append(added, song)
+		}
+	}
+	for _, song := range before {
+		if !afterIDs[song.VideoID] {
+			removed = append(removed, song)
+		}
+	}
+	return added, removed
+}
+
+func fetchVideoSong(videoID string) (Song, error) {
+	waitForYtdlp()
+	cmd := exec.Command("yt-dlp", ytdlpArgs("--no-playlist", "--print", "%(title)s|%(id)s|%(duration_string)s|%(artist,uploader)s", fmt.Sprintf("https://www.youtube.com/watch?v=%s", videoID))...)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return Song{}, fmt.Errorf("failed to fetch video: %v", ytdlpError(err))
+	}
+
+	song, ok := parseSongLine(string(output))
+	if !ok {
+		return Song{}, fmt.Errorf("no video information found")
+	}
+	return song, nil
+}
+
+// parseSongLine parses a "title|id|duration" line printed by yt-dlp
+func parseSongLine(line string) (Song, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return Song{}, false
+	}
+
+	parts := strings.Split(line, "|")
+	if len(parts) < 2 {
+		return Song{}, false
+	}
+
+	title := parts[0]
+	videoID := parts[1]
+	duration := "Unknown"
+	if len(parts) >= 3 && parts[2] != "NA" {
+		duration = parts[2]
+	}
+	artist := ""
+	if len(parts) >= 4 && parts[3] != "NA" {
+		artist = parts[3]
+	}
+
+	return Song{
+		Title:    title,
+		VideoID:  videoID,
+		Duration: duration,
+		Artist:   artist,
+		URL:      fmt.Sprintf("https://www.youtube.com/watch?v=%s", videoID),
+	}, true
+}
+
+func initShuffleOrder() {
+	if config.State.CurrentPlaylist == "" {
+		return
+	}
+
+	playlist := currentPlaylist()
+	if playlist == nil {
+		return
+	}
+
+	config.State.ShuffleIndex = 0
+	if len(playlist.Songs) == 0 {
+		config.State.ShuffleOrder = []int{}
+		return
+	}
+
+	// Create shuffled order
+	config.State.ShuffleOrder = make([]int, len(playlist.Songs))
+	for i := range config.State.ShuffleOrder {
+		config.State.ShuffleOrder[i] = i
+	}
+
+	// Shuffle using Fisher-Yates algorithm
+	rand.Seed(time.Now().UnixNano())
+	for i := len(config.State.ShuffleOrder) - 1; i > 0; i-- {
+		j := rand.Intn(i + 1)
+		config.State.ShuffleOrder[i], config.State.ShuffleOrder[j] = config.State.ShuffleOrder[j], config.State.ShuffleOrder[i]
+	}
+}
+
+// onFinalLoop reports whether the pass playing now is the last one allowed
+// by a 'mfp loop <times>' count
+func onFinalLoop() bool {
+	return config.State.LoopCount > 0 && config.State.LoopsDone >= config.State.LoopCount-1
+}
+
+// reshuffleForNextLoop generates a new shuffle order once a looped playlist
+// has wrapped around, and rearranges mpv's live playlist to match. The song
+// that just started stays first so playback isn't interrupted.
+func reshuffleForNextLoop() {
+	oldOrder := append([]int(nil), config.State.ShuffleOrder...)
+	if len(oldOrder) < 2 {
+		return
+	}
+	playing := oldOrder[0]
+
+	initShuffleOrder()
+	newOrder := config.State.ShuffleOrder
+	for i, index := range newOrder {
+		if index == playing {
+			newOrder[0], newOrder[i] = newOrder[i], newOrder[0]
+			break
+		}
+	}
+	config.State.ShuffleIndex = 0
+	config.State.CurrentSongIndex = playing
+	rearrangeMpvPlaylist(oldOrder, newOrder)
+
+	fmt.Println("Playlist looped, reshuffled for the next pass")
+}
+
+// rearrangeMpvPlaylist moves mpv's playlist entries, holding the songs in
+// live order, into target order without interrupting the one playing.
+// Queued songs after them are left alone.
+func rearrangeMpvPlaylist(live, target []int) {
+	live = append([]int(nil), live...)
+	// Move entries into place one by one; positions before i are already final
+	for i := 0; i < len(target); i++ {
+		j := i
+		for j < len(live) && live[j] != target[i] {
+			j++
+		}
+		if j == i || j == len(live) {
+			continue
+		}
+		sendMpvCommand(fmt.Sprintf("playlist-move %d %d", j, i))
+		moved := live[j]
+		copy(live[i+1:j+1], live[i:j])
+		live[i] = moved
+	}
+}
+
+// interjectionBlocksEdit reports, and says so, when songs of the playing
+// playlist can't be moved or removed because an interjection is playing:
+// mpv's playlist has an extra entry until it's over
+func interjectionBlocksEdit(playlistName string) bool {
+	if config.State.Interjection != nil && config.State.IsPlaying &&
+		config.State.CurrentPlaylist == playlistName && config.State.Session == nil {
+		fmt.Println("An interjection is playing; try again once it's over")
+		return true
+	}
+	return false
+}
+
+// interjectionBlocksMove refuses to move around in mpv's playlist while an
+// interjection plays: its extra entry shifts every position after it, and
+// the monitor would take the move for the interjection ending and go back
+func interjectionBlocksMove() bool {
+	if config.State.Interjection != nil && config.State.IsPlaying {
+		fmt.Println("An interjection is playing; skip it with 'mfp next' or try again once it's over")
+		return true
+	}
+	return false
+}
+
+// carryOverPlayback follows the songs of a playlist that were reordered,
+// removed or refetched; oldToNew maps each song's old index to its new one,
+// or -1 for songs that are gone. For the current playlist the current song
+// and shuffle order move with their songs, with new songs shuffled in after
+// the current one. While it plays, mpv's playlist is rearranged to match
+// without interrupting the song, or moves on to the next one left if the
+// song playing is gone. The caller saves.
+func carryOverPlayback(playlistName string, oldToNew []int) {
+	state := config.State
+	playlist := config.Playlists[playlistName]
+	if state.CurrentPlaylist != playlistName || state.Session != nil || playlist == nil {
+		return
+	}
+
+	// The play order before the change, in old song indices
+	var oldOrder []int
+	if state.IsShuffle {
+		for _, index := range state.ShuffleOrder {
+			if index >= 0 && index < len(oldToNew) {
+				oldOrder = append(oldOrder, index)
+			}
+		}
+	} else {
+		for i := range oldToNew {
+			oldOrder = append(oldOrder, i)
+		}
+	}
+
+	// The current song, or the next one left after it
+	newCurrent := -1
+	at := 0
+	for i, index := range oldOrder {
+		if index == state.CurrentSongIndex {
+			at = i
+			break
+		}
+	}
+	for k := 0; k < len(oldOrder) && newCurrent < 0; k++ {
+		newCurrent = oldToNew[oldOrder[(at+k)%len(oldOrder)]]
+	}
+
+	var newOrder []int
+	if state.IsShuffle {
+		placed := make([]bool, len(playlist.Songs))
+		for _, index := range oldOrder {
+			if n := oldToNew[index]; n >= 0 {
+				newOrder = append(newOrder, n)
+				placed[n] = true
+			}
+		}
+		current := slices.Index(newOrder, newCurrent)
+		for n := range playlist.Songs {
+			if !placed[n] {
+				i := current + 1 + rand.Intn(len(newOrder)-current)
+				newOrder = append(newOrder, 0)
+				copy(newOrder[i+1:], newOrder[i:])
+				newOrder[i] = n
+			}
+		}
+	} else {
+		for n := range playlist.Songs {
+			newOrder = append(newOrder, n)
+		}
+	}
+	if newCurrent < 0 && len(newOrder) > 0 {
+		newCurrent = newOrder[0]
+	}
+	if state.CurrentSongIndex < 0 || state.CurrentSongIndex >= len(oldToNew) || oldToNew[state.CurrentSongIndex] != newCurrent {
+		state.Position = 0
+	}
+	if newCurrent < 0 {
+		newCurrent = 0
+	}
+	state.CurrentSongIndex = newCurrent
+	if state.IsShuffle {
+		state.ShuffleOrder = newOrder
+		state.ShuffleIndex = max(slices.Index(newOrder, newCurrent), 0)
+	}
+
+	if !state.IsPlaying || getMpvPid() < 0 {
+		return
+	}
+	if len(newOrder) == 0 {
+		handleStop()
+		return
+	}
+
+	// mpv holds the old play order followed by the songs from
+	// queue-playlist. Model it in new song indices, with -1 for entries to
+	// drop and -2 for the queued ones, then add the new songs at the end.
+	model := make([]int, 0, len(oldOrder)+len(state.TempQueue))
+	present := make(map[int]bool)
+	for _, index := range oldOrder {
+		model = append(model, oldToNew[index])
+		present[oldToNew[index]] = true
+	}
+	for range state.TempQueue {
+		model = append(model, -2)
+	}
+	for _, n := range newOrder {
+		if !present[n] {
+			sendMpvCommandArgs("loadfile", playlist.Songs[n].URL, "append")
+			model = append(model, n)
+		}
+	}
+	if playing := getMpvPlaylistPosition(); playing >= 0 && playing < len(oldOrder) && model[playing] == -1 {
+		sendMpvCommandArgs("playlist-play-index", slices.Index(model, newCurrent))
+	}
+	for i := len(oldOrder) - 1; i >= 0; i-- {
+		if model[i] == -1 {
+			sendMpvCommandArgs("playlist-remove", i)
+			model = append(model[:i], model[i+1:]...)
+		}
+	}
+	rearrangeMpvPlaylist(model, newOrder)
+}
+
+func daemonPidFile() string {
+	return filepath.Join(config.DataDir, "daemon.pid")
+}
+
+// readDaemonPid returns the pid recorded by the running daemon, or -1
+func readDaemonPid() int {
+	data, err := ioutil.ReadFile(daemonPidFile())
+	if err != nil {
+		return -1
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return -1
+	}
+	return pid
+}
+
+// isCurrentDaemon reports whether this process is the daemon the pid file
+// points at. A daemon being replaced by a newer 'play' must leave state alone.
+func isCurrentDaemon() bool {
+	if config.ReadOnly {
+		return true
+	}
+	return readDaemonPid() == os.Getpid()
+}
+
+// startDaemon launches 'mfp daemon' detached from the terminal so playback
+// and monitoring carry on after this command returns
+func startDaemon() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// Hand over the state directly in case it couldn't be saved to disk
+	stateData, err := json.Marshal(config.State)
+	if err != nil {
+		return err
+	}
+
+	var daemonArgs []string
+	if verbosity == 1 {
+		daemonArgs = append(daemonArgs, "-v")
+	} else if verbosity == 2 {
+		daemonArgs = append(daemonArgs, "-vv")
+	}
+	daemonArgs = append(daemonArgs, "daemon")
+	if config.Profile != "" {
+		daemonArgs = append(daemonArgs, "--profile", config.Profile)
+	}
+	cmd := exec.Command(exe, daemonArgs...)
+	cmd.Env = append(os.Environ(), "MFP_DAEMON_STATE="+string(stateData))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if !config.ReadOnly {
+		if logFile, err := os.Create(filepath.Join(config.DataDir, "daemon.log")); err == nil {
+			defer logFile.Close()
+			cmd.Stdout = logFile
+			cmd.Stderr = logFile
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// runDaemon owns the mpv process for 'mfp play --background': it starts
+// playback, then monitors mpv until it exits
+func runDaemon() {
+	if stateData := os.Getenv("MFP_DAEMON_STATE"); stateData != "" {
+		json.Unmarshal([]byte(stateData), config.State)
+	}
+
+	if ownPlayback() {
+		runPlayback()
+	}
+}
+
+// ownPlayback makes this process the owner of playback, the one 'mfp pid'
+// points at and that serves events, and starts mpv
+func ownPlayback() bool {
+	if !config.ReadOnly {
+		if err := ioutil.WriteFile(daemonPidFile(), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+			fmt.Printf("Error writing pid file: %v\n", err)
+		}
+	}
+
+	setupMediaKeySignals()
+	startEventServer()
+	return startPlayback()
+}
+
+// runPlayback monitors the mpv started by ownPlayback until it exits
+func runPlayback() {
+	if config.Settings.Fade > 0 {
+		go fadeIn(*currentVolume())
+	}
+	startRefreshScheduler()
+	go checkMpvVersion()
+	monitorMpv()
+}
+
+// setupMediaKeySignals lets media-key daemons control playback with
+// 'kill -USR1 $(mfp pid)' (next) and 'kill -USR2 $(mfp pid)' (previous)
+func setupMediaKeySignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range c {
+			stateMu.Lock()
+			reloadConfig()
+			switch sig {
+			case syscall.SIGUSR1:
+				handleNext(nil)
+			case syscall.SIGUSR2:
+				handlePrevious(nil)
+			}
+			stateMu.Unlock()
+		}
+	}()
+}
+
+// minMpvVersion is the oldest mpv whose IPC properties mfp relies on;
+// older builds answer some get_property calls with errors, which shows up
+// as missing positions and statuses
+var minMpvVersion = [3]int{0, 32, 0}
+
+var mpvVersionPattern = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseMpvVersion extracts the version from strings like "mpv 0.35.1" or
+// "mpv v0.36.0-git-1a2b3c"
+func parseMpvVersion(s string) ([3]int, bool) {
+	var version [3]int
+	match := mpvVersionPattern.FindStringSubmatch(s)
+	if match == nil {
+		return version, false
+	}
+	for i := 0; i < 3; i++ {
+		version[i], _ = strconv.Atoi(match[i+1])
+	}
+	return version, true
+}
+
+func mpvVersionTooOld(version [3]int) bool {
+	for i := 0; i < 3; i++ {
+		if version[i] != minMpvVersion[i] {
+			return version[i] < minMpvVersion[i]
+		}
+	}
+	return false
+}
+
+func minMpvVersionString() string {
+	return fmt.Sprintf("%d.%d.%d", minMpvVersion[0], minMpvVersion[1], minMpvVersion[2])
+}
+
+// checkMpvVersion asks the running mpv for its version and logs a warning
+// when it's older than minMpvVersion
+func checkMpvVersion() {
+	if !waitForSocket() {
+		return
+	}
+	value, err := getMpvProperty("mpv-version")
+	if err != nil {
+		fmt.Printf("Warning: couldn't read mpv's version (%v); mpv %s or newer is recommended\n", err, minMpvVersionString())
+		return
+	}
+	text, _ := value.(string)
+	if version, ok := parseMpvVersion(text); ok && mpvVersionTooOld(version) {
+		fmt.Printf("Warning: %s is older than %s; position and status may not work\n", text, minMpvVersionString())
+	}
+}
+
+// Clients of 'mfp events', fed newline-delimited JSON by the monitor loop
+var (
+	eventsMu         sync.Mutex
+	eventSubscribers []net.Conn
+)
+
+func eventsSocketPath() string {
+	if config.ReadOnly {
+		return tempFilePath(config.Profile, "events-socket")
+	}
+	return filepath.Join(config.DataDir, "events-socket")
+}
+
+// startEventServer accepts 'mfp events' clients on the events socket for as
+// long as the daemon runs
+func startEventServer() {
+	path := eventsSocketPath()
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		fmt.Printf("Error opening events socket: %v\n", err)
+		return
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			eventsMu.Lock()
+			eventSubscribers = append(eventSubscribers, conn)
+			eventsMu.Unlock()
+		}
+	}()
+}
+
+func hasEventSubscribers() bool {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	return len(eventSubscribers) > 0
+}
+
+// emitEvent sends an event to every subscriber, dropping those that have
+// disconnected
+func emitEvent(event string, fields map[string]interface{}) {
+	if verbosity >= 1 && fields == nil {
+		debugf(1, "event %s", event)
+	} else if verbosity >= 1 {
+		data, _ := json.Marshal(fields)
+		debugf(1, "event %s %s", event, data)
+	}
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if len(eventSubscribers) == 0 {
+		return
+	}
+
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["event"] = event
+	fields["time"] = time.Now().Format(time.RFC3339)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	kept := eventSubscribers[:0]
+	for _, conn := range eventSubscribers {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(data); err != nil {
+			conn.Close()
+			continue
+		}
+		kept = append(kept, conn)
+	}
+	eventSubscribers = kept
+}
+
+func emitSongChange(song Song, number int) {
+	emitEvent("song-change", map[string]interface{}{
+		"playlist": config.State.CurrentPlaylist,
+		"number":   number,
+		"title":    song.Title,
+		"artist":   song.Artist,
+		"video_id": song.VideoID,
+		"url":      song.URL,
+		"duration": song.Duration,
+	})
+}
+
+// handleEvents prints the daemon's events until it stops or the user quits
+func handleEvents() {
+	conn, err := net.Dial("unix", eventsSocketPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Nothing is playing")
+		os.Exit(1)
+	}
+	defer conn.Close()
+	io.Copy(os.Stdout, conn)
+}
+
+// serveCommands maps the POST endpoints of 'mfp serve' to mfp commands; the
+// JSON body's "value" (or "playlist" for /play) becomes the argument
+var serveCommands = map[string]string{
+	"/play":   "play",
+	"/stop":   "stop",
+	"/next":   "next",
+	"/prev":   "prev",
+	"/volume": "volume",
+	"/seek":   "seek",
+}
+
+// handleServe runs an HTTP server for remote control until interrupted.
+// Commands are run as 'mfp <command>' so they behave exactly like the CLI.
+func handleServe(args []string) {
+	args, addr, hasAddr := extractFlagValue(args, "--addr")
+	args, token, _ := extractFlagValue(args, "--token")
+	if len(args) > 0 {
+		fmt.Println("Usage: mfp serve [--addr host:port] [--token secret]")
+		return
+	}
+	if !hasAddr {
+		addr = "127.0.0.1:8080"
+	}
+	if token == "" {
+		token = os.Getenv("MFP_TOKEN")
+	}
+
+	// Handlers share the global config, so requests are served one at a time
+	var mu sync.Mutex
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeServeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "use GET"})
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		reloadConfig()
+		writeServeJSON(w, http.StatusOK, serveStatus())
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		servePause(w, r, &mu, true)
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		servePause(w, r, &mu, false)
+	})
+	for path, command := range serveCommands {
+		path, command := path, command
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if !servePost(w, r) {
+				return
+			}
+			var body struct {
+				Value    interface{} `json:"value"`
+				Playlist string      `json:"playlist"`
+			}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					writeServeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid JSON body"})
+					return
+				}
+			}
+			cmdArgs := []string{command}
+			if body.Playlist != "" {
+				cmdArgs = append(cmdArgs, body.Playlist)
+			} else if body.Value != nil {
+				cmdArgs = append(cmdArgs, fmt.Sprint(body.Value))
+			}
+			// Values become command arguments, so they mustn't pass for flags
+			// (e.g. --mpv-arg); negative numbers are fine for /seek
+			if len(cmdArgs) > 1 && strings.HasPrefix(cmdArgs[1], "-") && !serveNegativePattern.MatchString(cmdArgs[1]) {
+				writeServeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "values can't start with '-'"})
+				return
+			}
+			if command == "play" {
+				// The API call returns once playback has started
+				cmdArgs = append(cmdArgs, "--background")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			output, err := runMfp(cmdArgs...)
+			if err != nil {
+				writeServeJSON(w, http.StatusInternalServerError, map[string]interface{}{"ok": false, "output": output, "error": err.Error()})
+				return
+			}
+			writeServeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "output": output})
+		})
+	}
+
+	handler := http.Handler(mux)
+	if token != "" {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				writeServeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "invalid or missing token"})
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+	} else if host, _, err := net.SplitHostPort(addr); err == nil && host != "127.0.0.1" && host != "localhost" && host != "::1" {
+		fmt.Println("Warning: serving without --token, anyone on the network can control playback")
+	}
+
+	fmt.Printf("Serving the mfp API on %s (Ctrl+C to stop)\n", addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runMfp runs this mfp binary (with the same profile) and returns its output
+func runMfp(args ...string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if config.Profile != "" {
+		args = append([]string{"--profile", config.Profile}, args...)
+	}
+	output, err := exec.Command(exe, args...).CombinedOutput()
+	return strings.TrimSpace(string(output)), err
+}
+
+var serveNegativePattern = regexp.MustCompile(`^-\d+(\.\d+)?%?$`)
+
+// servePost accepts only JSON POSTs. A web page can't send application/json
+// to another origin without a CORS preflight, which isn't answered, so other
+// sites open in the browser can't drive the player.
+func servePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		writeServeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "use POST"})
+		return false
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeServeJSON(w, http.StatusUnsupportedMediaType, map[string]interface{}{"error": "send Content-Type: application/json"})
+		return false
+	}
+	return true
+}
+
+func servePause(w http.ResponseWriter, r *http.Request, mu *sync.Mutex, pause bool) {
+	if !servePost(w, r) {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	reloadConfig()
+	if !config.State.IsPlaying {
+		writeServeJSON(w, http.StatusConflict, map[string]interface{}{"ok": false, "error": "no music is currently playing"})
+		return
+	}
+	if err := sendMpvCommandArgs("set_property", "pause", pause); err != nil {
+		writeServeJSON(w, http.StatusInternalServerError, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+	writeServeJSON(w, http.StatusOK, map[string]interface{}{"ok": true})
+}
+
+// serveStatus is 'mfp status' as JSON
+func serveStatus() map[string]interface{} {
+	status := map[string]interface{}{
+		"playing":  config.State.IsPlaying,
+		"playlist": config.State.CurrentPlaylist,
+		"volume":   *currentVolume(),
+		"shuffle":  config.State.IsShuffle,
+		"loop":     config.State.IsLoop,
+	}
+	if song := currentSong(); song != nil {
+		status["song"] = map[string]interface{}{
+			"number":   getCurrentSongIndex() + 1,
+			"title":    song.Title,
+			"artist":   song.Artist,
+			"video_id": song.VideoID,
+			"url":      song.URL,
+			"duration": song.Duration,
+		}
+		status["count"] = len(currentPlaylist().Songs)
+	}
+	position := config.State.Position
+	paused := false
+	if config.State.IsPlaying {
+		if pos := getMpvPosition(); pos >= 0 {
+			position = pos
+		}
+		if value, err := getMpvProperty("pause"); err == nil {
+			paused = value == true
+		}
+	}
+	status["position"] = position
+	status["paused"] = paused
+	return status
+}
+
+func writeServeJSON(w http.ResponseWriter, code int, body map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func handlePid() {
+	pid := readDaemonPid()
+	if pid < 0 || syscall.Kill(pid, 0) != nil {
+		fmt.Fprintln(os.Stderr, "Nothing is playing")
+		os.Exit(1)
+	}
+	fmt.Println(pid)
+}
+
+// handleURL prints a song's YouTube URL and nothing else, for piping to
+// xdg-open or pbcopy. Errors go to stderr with a non-zero exit.
+func handleURL(args []string) {
+	var song *Song
+	switch len(args) {
+	case 0:
+		song = currentSong()
+		// A song from queue-playlist plays past the end of the play order
+		if playlist := currentPlaylist(); playlist != nil && config.State.IsPlaying {
+			if queuedIndex := getMpvPlaylistPosition() - len(playOrder(playlist)); queuedIndex >= 0 && queuedIndex < len(config.State.TempQueue) {
+				song = &config.State.TempQueue[queuedIndex]
+			}
+		}
+		if song == nil {
+			fmt.Fprintln(os.Stderr, "No song is currently loaded")
+			os.Exit(1)
+		}
+	case 2:
+		playlist, exists := config.Playlists[args[0]]
+		if !exists {
+			fmt.Fprintf(os.Stderr, "Playlist '%s' not found\n", args[0])
+			os.Exit(1)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > len(playlist.Songs) {
+			fmt.Fprintf(os.Stderr, "Invalid song number. Use 1-%d\n", len(playlist.Songs))
+			os.Exit(1)
+		}
+		song = &playlist.Songs[n-1]
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: mfp url [<playlist> <song_number>]")
+		os.Exit(1)
+	}
+
+	url := song.URL
+	if url == "" {
+		url = fmt.Sprintf("https://www.youtube.com/watch?v=%s", song.VideoID)
+	}
+	fmt.Println(url)
+}
+
+func startPlayback() bool {
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("Error: Current playlist not found")
+		return false
+	}
+	if len(playlist.Songs) == 0 {
+		fmt.Println("Error: Current playlist has no songs")
+		return false
+	}
+
+	// Set state BEFORE starting mpv
+	config.State.IsPlaying = true
+	if err := saveConfig(); err != nil {
+		fmt.Printf("Error saving state: %v\n", err)
+	}
+
+	// Create temporary playlist file for mpv
+	playlistFile := filepath.Join(config.DataDir, "current_playlist.m3u")
+	if config.ReadOnly {
+		playlistFile = tempFilePath(config.Profile, "current_playlist.m3u")
+	}
+	if err := createPlaylistFile(playlist, playlistFile); err != nil {
+		fmt.Printf("Error creating playlist file: %v\n", err)
+		config.State.IsPlaying = false
+		saveConfig()
+		return false
+	}
+
+	// Start mpv with the playlist
+	if err := startMpv(playlistFile); err != nil {
+		fmt.Printf("Error starting mpv: %v\n", err)
+		config.State.IsPlaying = false
+		saveConfig()
+		return false
+	}
+
+	fmt.Printf("MPV started successfully for playlist: %s\n", config.State.CurrentPlaylist)
+	return true
+}
+
+// Improve handlePlay function
+func handlePlay(args []string) {
+	args, force := extractFlag(args, "--force")
+	args, background := extractFlag(args, "--background")
+	args, randomStart := extractFlag(args, "--random-start")
+	args, dryRun := extractFlag(args, "--dry-run")
+	args, single := extractFlag(args, "--single")
+	args, noResume := extractFlag(args, "--no-resume")
+	args, restart := extractFlag(args, "--restart")
+	noResume = noResume || restart
+	args, verifyStart := extractFlag(args, "--verify-start")
+	args, mpvArgs := extractFlagValues(args, "--mpv-arg")
+	if err := checkMpvArgs(mpvArgs); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	args, volumeValue, hasVolume := extractFlagValue(args, "--volume")
+	var sessionVolume *int
+	if hasVolume {
+		volume, err := strconv.Atoi(volumeValue)
+		if err != nil || volume < 0 || volume > 100 {
+			fmt.Println("Error: --volume must be between 0 and 100")
+			return
+		}
+		sessionVolume = &volume
+	}
+	args, fromValue, hasFrom := extractFlagValue(args, "--from")
+	args, toValue, hasTo := extractFlagValue(args, "--to")
+	hasRange := hasFrom || hasTo
+	args, atValue, hasAt := extractFlagValue(args, "--at")
+	atSong, atSeconds := 0, 0
+	if hasAt {
+		if hasRange || randomStart {
+			fmt.Println("Error: --at can't be combined with --from, --to or --random-start")
+			return
+		}
+		var err error
+		if atSong, atSeconds, err = parseAt(atValue); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+	}
+	if pinned := config.State.PinnedPlaylist; len(args) == 0 && pinned != "" && pinned != config.State.CurrentPlaylist &&
+		!config.State.IsPlaying && config.Playlists[pinned] != nil {
+		// A bare play goes back to the pinned playlist, whatever played last
+		args = []string{pinned}
+	}
+	if hasRange && len(args) == 0 && config.State.CurrentPlaylist != "" {
+		// A range with no playlist applies to the current one
+		args = []string{config.State.CurrentPlaylist}
+	}
+	savedOrder := config.State.ShuffleOrder
+	if dryRun {
+		// Go through the same steps on a copy of the state, then show the
+		// result instead of stopping or starting anything
+		savedState := config.State
+		preview := *config.State
+		config.State = &preview
+		defer func() { config.State = savedState }()
+	}
+
+	// Playing again what's already playing doesn't restart it: a paused
+	// player is unpaused, otherwise this just shows the status. A state
+	// left playing by a crash is cleared and resumed as usual.
+	startOptions := hasRange || hasAt || randomStart || hasVolume || single || verifyStart || len(mpvArgs) > 0
+	if config.State.IsPlaying && replaysCurrent(args, startOptions) && !noResume {
+		if getMpvPid() >= 0 {
+			if dryRun {
+				fmt.Println("Already playing, so this would only resume it if paused or show the status")
+				return
+			}
+			if paused, err := getMpvProperty("pause"); err == nil && paused == true {
+				sendMpvCommand("set pause no")
+				if song := currentSong(); song != nil {
+					fmt.Printf("Resumed: %s\n", song.DisplayTitle())
+				} else {
+					fmt.Println("Resumed")
+				}
+				return
+			}
+			fmt.Println("Already playing. Use 'mfp stop' to stop, or 'mfp play <playlist>' to switch.")
+			handleStatus(nil)
+			return
+		}
+		config.State.IsPlaying = false
+		if !dryRun {
+			saveConfig()
+		}
+	}
+
+	// Check --at against the playlist before anything is stopped
+	if hasAt {
+		target := currentPlaylist()
+		if len(args) > 0 {
+			target = config.Playlists[args[0]]
+		}
+		if target != nil {
+			if atSong > len(target.Songs) {
+				fmt.Printf("Error: --at song %d is past the end, the playlist has %d songs\n", atSong, len(target.Songs))
+				return
+			}
+			song := target.Songs[atSong-1]
+			if length, ok := durationSeconds(song.Duration); ok && atSeconds >= length {
+				fmt.Printf("Error: --at %s is past the end of song %d (%s)\n", formatDuration(atSeconds), atSong, song.Duration)
+				return
+			}
+		}
+	}
+
+	if !dryRun && !checkOrphanedMpv(force) {
+		return
+	}
+
+	if len(args) == 0 {
+		// Resume current playlist if available
+		if config.State.CurrentPlaylist == "" {
+			fmt.Println("No playlist specified. Use: mfp play <playlist_name>")
+			return
+		}
+		playlist := currentPlaylist()
+		if playlist == nil {
+			fmt.Printf("Playlist '%s' not found\n", config.State.CurrentPlaylist)
+			return
+		}
+		if len(playlist.Songs) == 0 {
+			printEmptyPlaylist(config.State.CurrentPlaylist)
+			return
+		}
+		if allSkipped(playlist) {
+			fmt.Println("Every song in this playlist is marked skip-always, nothing to play")
+			return
+		}
+
+		// Restarting while playing starts a new session from the top
+		if config.State.IsPlaying && !dryRun {
+			handleStop()
+			time.Sleep(500 * time.Millisecond) // Give time for cleanup
+		}
+
+		if noResume {
+			// Start over from the top; keep the shuffle order but go back to
+			// its first song
+			config.State.CurrentSongIndex = 0
+			config.State.ShuffleIndex = 0
+			config.State.Position = 0
+		}
+
+		restoreResumePoint(playlist)
+
+		if noResume {
+			fmt.Printf("Restarting playlist: %s\n", config.State.CurrentPlaylist)
+		} else {
+			fmt.Printf("Resuming playlist: %s\n", config.State.CurrentPlaylist)
+		}
+		if song := currentSong(); song != nil && !noResume {
+			fmt.Printf("  at song %d: %s (%s)\n", getCurrentSongIndex()+1, song.DisplayTitle(), formatDuration(config.State.Position))
+		}
+	} else {
+		// Start new playlist
+		playlistName := args[0]
+		source, exists := config.Playlists[playlistName]
+		if !exists {
+			fmt.Printf("Playlist '%s' not found\n", playlistName)
+			return
+		}
+		if len(source.Songs) == 0 {
+			printEmptyPlaylist(playlistName)
+			return
+		}
+		if allSkipped(source) {
+			fmt.Println("Every song in this playlist is marked skip-always, nothing to play")
+			fmt.Printf("Use 'mfp skip-always %s --clear' to unmark them\n", playlistName)
+			return
+		}
+		start, end, err := parseRange(fromValue, toValue, len(source.Songs))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		// Stop current playback if any
+		if config.State.IsPlaying && !dryRun {
+			handleStop()
+			time.Sleep(500 * time.Millisecond) // Give time for cleanup
+		}
+
+		config.State.CurrentPlaylist = playlistName
+		config.State.CurrentSongIndex = 0
+		config.State.Position = 0
+		config.State.Session = nil
+
+		// --from/--to play a slice of the playlist as a transient session,
+		// so indices and shuffle order refer to the slice
+		if hasRange {
+			config.State.Session = &Playlist{
+				Name:        fmt.Sprintf("%s (songs %d-%d)", playlistName, start+1, end),
+				URL:         source.URL,
+				Songs:       append([]Song(nil), source.Songs[start:end]...),
+				LastUpdated: source.LastUpdated,
+			}
+		}
+
+		// Initialize shuffle order if shuffle is enabled
+		if config.State.IsShuffle {
+			initShuffleOrder()
+		}
+
+		if config.State.Session != nil {
+			fmt.Printf("Loading playlist: %s\n", config.State.Session.Name)
+		} else {
+			fmt.Printf("Loading playlist: %s\n", playlistName)
+		}
+	}
+
+	// Pick a random first song and continue in order from there; with
+	// shuffle on, a random place in the shuffle order
+	if randomStart {
+		if playlist := currentPlaylist(); playlist != nil && len(playlist.Songs) > 0 {
+			if order := config.State.ShuffleOrder; config.State.IsShuffle && len(order) == len(playlist.Songs) {
+				config.State.ShuffleIndex = rand.Intn(len(order))
+				config.State.CurrentSongIndex = order[config.State.ShuffleIndex]
+			} else {
+				config.State.CurrentSongIndex = rand.Intn(len(playlist.Songs))
+			}
+			config.State.Position = 0
+			fmt.Printf("Starting from song %d: %s\n", config.State.CurrentSongIndex+1, playlist.Songs[config.State.CurrentSongIndex].DisplayTitle())
+		}
+	}
+
+	// Start at a given song and time; mpv gets the time as --start
+	if hasAt {
+		if playlist := currentPlaylist(); playlist != nil && atSong <= len(playlist.Songs) {
+			config.State.CurrentSongIndex = atSong - 1
+			config.State.Position = atSeconds
+			if config.State.IsShuffle {
+				for i, index := range config.State.ShuffleOrder {
+					if index == atSong-1 {
+						config.State.ShuffleIndex = i
+					}
+				}
+			}
+			fmt.Printf("Starting at song %d, %s: %s\n", atSong, formatDuration(atSeconds), playlist.Songs[atSong-1].DisplayTitle())
+		}
+	}
+
+	if dryRun {
+		showPlayDryRun(savedOrder, randomStart, verifyStart)
+		return
+	}
+	if verifyStart && !verifyStartSong() {
+		return
+	}
+
+	config.State.SingleSong = single
+	config.State.MpvArgs = mpvArgs
+	config.State.SessionVolume = sessionVolume
+	config.State.PlayedSeconds = 0
+	config.State.PlayedAsOf = time.Time{}
+	config.State.TempQueue = nil
+	config.State.Interjection = nil
+	config.State.PauseAfterSong = false
+	config.State.PlayStarted = time.Now()
+	config.State.LoopsDone = 0
+	startPlayer(background)
+}
+
+// startPlayer starts playback of the state handlePlay set up; tests swap it
+// out to check what would be played
+var startPlayer = launchPlayback
+
+// maxStartProbes is how many songs 'play --verify-start' tries before
+// giving up, so a dead connection doesn't probe the whole playlist
+const maxStartProbes = 5
+
+// verifyStartSong moves the start past songs yt-dlp can't play, since mpv
+// may stall on a dead first song. It reports false if none of the next
+// few songs can be played.
+func verifyStartSong() bool {
+	playlist := currentPlaylist()
+	if playlist == nil {
+		return true
+	}
+	order := playOrder(playlist)
+	start := config.State.CurrentSongIndex
+	if config.State.IsShuffle {
+		start = config.State.ShuffleIndex
+	}
+	if start < 0 || start >= len(order) {
+		start = 0
+	}
+
+	for tries := 0; tries < maxStartProbes && tries < len(order); tries++ {
+		i := (start + tries) % len(order)
+		song := playlist.Songs[order[i]]
+		if isSkipped(song) {
+			continue
+		}
+		if err := checkSongAvailable(song); err != nil {
+			fmt.Printf("Song %d is unavailable: %s (%v)\n", order[i]+1, song.DisplayTitle(), err)
+			recordProblem(song, playlistNameForProblems(), err)
+			continue
+		}
+		if tries > 0 {
+			config.State.CurrentSongIndex = order[i]
+			if config.State.IsShuffle {
+				config.State.ShuffleIndex = i
+			}
+			config.State.Position = 0
+			fmt.Printf("Starting at song %d instead: %s\n", order[i]+1, song.DisplayTitle())
+		}
+		return true
+	}
+	fmt.Println("Error: none of the first songs to play are available, check your connection ('mfp doctor')")
+	return false
+}
+
+// playlistNameForProblems is the saved playlist being played, or "" for a
+// search session that has none
+func playlistNameForProblems() string {
+	if config.Playlists[config.State.CurrentPlaylist] == nil {
+		return ""
+	}
+	return config.State.CurrentPlaylist
+}
+
+// checkSongAvailable asks yt-dlp whether song can be streamed in the
+// configured format, without downloading anything
+func checkSongAvailable(song Song) error {
+	waitForYtdlp()
+	args := []string{"--no-playlist", "--simulate", "--quiet", "--no-warnings", "-f", audioFormat(), song.URL}
+	if _, err := exec.Command("yt-dlp", ytdlpArgs(args...)...).Output(); err != nil {
+		return ytdlpError(err)
+	}
+	return nil
+}
+
+// launchPlayback saves the state the caller set up and starts playing it,
+// unless another mfp got mpv going first. In the foreground this process
+// owns mpv until it exits, so Ctrl+C stops the music; with background a
+// detached 'mfp daemon' owns it and this returns once mpv is up.
+func launchPlayback(background bool) {
+	playing := false
+	started, err := startIfIdle(func() {
+		saveConfig()
+
+		if !background {
+			if ownPlayback() && waitForMpv() {
+				playing = true
+			} else {
+				fmt.Println("Failed to start playback")
+			}
+			return
+		}
+
+		// Start playback in a background daemon that owns mpv
+		if err := startDaemon(); err != nil {
+			fmt.Printf("Error starting playback daemon: %v\n", err)
+			return
+		}
+
+		// Give it a moment to start, then confirm
+		if waitForMpv() {
+			fmt.Printf("Started playing playlist: %s\n", config.State.CurrentPlaylist)
+			runHook("play", config.Settings.OnPlay)
+			return
+		}
+		fmt.Println("Failed to start playback")
+		if !config.ReadOnly {
+			fmt.Printf("See %s for details\n", filepath.Join(config.DataDir, "daemon.log"))
+		}
+	})
+	if err != nil {
+		fmt.Printf("Error locking playback: %v\n", err)
+	} else if !started {
+		fmt.Println("mpv is still running. Use 'mfp stop' first, or 'mfp play --force'.")
+	}
+
+	if playing {
+		fmt.Printf("Started playing playlist: %s (Ctrl+C to stop)\n", config.State.CurrentPlaylist)
+		runHook("play", config.Settings.OnPlay)
+		runPlayback()
+	}
+}
+
+// waitForMpv gives a starting mpv a few seconds to listen on its socket
+func waitForMpv() bool {
+	for i := 0; i < 15; i++ {
+		time.Sleep(200 * time.Millisecond)
+		if mpvListening() {
+			return true
+		}
+	}
+	return false
+}
+
+// startIfIdle calls start unless an mpv is listening on the socket. It holds
+// play.lock from the check until start returns, so of two 'mfp play' at once
+// only one starts mpv; start must not return before mpv is listening. It
+// reports whether start was called.
+func startIfIdle(start func()) (bool, error) {
+	lock, err := lockFile("play.lock")
+	if err != nil {
+		return false, err
+	}
+	defer lock.Close()
+
+	// An mpv that was just told to quit may take a moment to go away
+	for i := 0; mpvListening(); i++ {
+		if i == 10 {
+			return false, nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	start()
+	return true, nil
+}
+
+// mpvListening reports whether anything accepts connections on the mpv
+// socket; a socket file left behind by a crash doesn't
+func mpvListening() bool {
+	conn, err := net.DialTimeout("unix", config.SocketFile, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// handlePlaySearch plays the results of a YouTube search as a transient
+// session, like a --from/--to range, without saving a playlist
+func handlePlaySearch(args []string) {
+	args, force := extractFlag(args, "--force")
+	args, background := extractFlag(args, "--background")
+	args, countValue, hasCount := extractFlagValue(args, "--count")
+	query := strings.TrimSpace(strings.Join(args, " "))
+	if query == "" {
+		fmt.Println("Usage: mfp play-search <query> [--count N]")
+		return
+	}
+	count := 10
+	if hasCount {
+		n, err := strconv.Atoi(countValue)
+		if err != nil || n < 1 || n > 50 {
+			fmt.Println("Error: --count must be between 1 and 50")
+			return
+		}
+		count = n
+	}
+
+	if !checkOrphanedMpv(force) {
+		return
+	}
+
+	fmt.Printf("Searching YouTube for '%s'...\n", query)
+	songs, err := searchSongs(query, count)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	if config.State.IsPlaying {
+		handleStop()
+		time.Sleep(500 * time.Millisecond) // Give time for cleanup
+	}
+
+	// The session's name stands in for a playlist name in status and the
+	// like; no saved playlist has it, so nothing gets written back
+	name := fmt.Sprintf("search: %s", query)
+	config.State.CurrentPlaylist = name
+	config.State.CurrentSongIndex = 0
+	config.State.Position = 0
+	config.State.Session = &Playlist{
+		Name:        name,
+		Songs:       songs,
+		LastUpdated: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if config.State.IsShuffle {
+		initShuffleOrder()
+	}
+	config.State.SingleSong = false
+	config.State.MpvArgs = nil
+	config.State.SessionVolume = nil
+	config.State.PlayedSeconds = 0
+	config.State.PlayedAsOf = time.Time{}
+	config.State.TempQueue = nil
+	config.State.Interjection = nil
+	config.State.PauseAfterSong = false
+	config.State.PlayStarted = time.Now()
+	config.State.LoopsDone = 0
+
+	fmt.Printf("Found %d songs\n", len(songs))
+	launchPlayback(background)
+}
+
+// searchSongs runs a yt-dlp ytsearch for up to count videos
+func searchSongs(query string, count int) ([]Song, error) {
+	waitForYtdlp()
+	cmd := exec.Command("yt-dlp", ytdlpArgs("--flat-playlist", "--print", "%(title)s|%(id)s|%(duration_string)s|%(artist,uploader)s", fmt.Sprintf("ytsearch%d:%s", count, query))...)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %v", ytdlpError(err))
+	}
+
+	var songs []Song
+	for _, line := range strings.Split(string(output), "\n") {
+		if song, ok := parseSongLine(line); ok {
+			if _, blacklisted := config.Blacklist[song.VideoID]; !blacklisted {
+				songs = append(songs, song)
+			}
+		}
+	}
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("%w for '%s'", errNoResults, query)
+	}
+	return songs, nil
+}
+
+// errNoResults is returned by searchSongs when the search itself worked
+var errNoResults = errors.New("no results")
+
+// handleContinueFrom plays the current playlist from a song to its end, as
+// a --from session, so the playlist itself is left alone
+func handleContinueFrom(args []string) {
+	args, background := extractFlag(args, "--background")
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp continue-from <song_number> [--background]")
+		return
+	}
+	playlistName := config.State.CurrentPlaylist
+	playlist, exists := config.Playlists[playlistName]
+	if !exists {
+		fmt.Println("No saved playlist is loaded. Use: mfp play <playlist_name> --from <song_number>")
+		return
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(playlist.Songs) {
+		fmt.Printf("Invalid song number. Use 1-%d\n", len(playlist.Songs))
+		return
+	}
+	if interjectionBlocksMove() {
+		return
+	}
+
+	if config.State.IsShuffle {
+		fmt.Printf("Note: shuffle is on, so songs %d-%d play in random order\n", n, len(playlist.Songs))
+	}
+	playArgs := []string{playlistName, "--from", args[0]}
+	if background {
+		playArgs = append(playArgs, "--background")
+	}
+	handlePlay(playArgs)
+}
+
+// parseAt reads a --at value "<song>:<time>", where the time is seconds,
+// M:SS or H:MM:SS, e.g. "5:1:30" for song 5 at 1:30
+func parseAt(value string) (int, int, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("--at must be <song>:<time>, e.g. 5:1:30")
+	}
+	song, err := strconv.Atoi(parts[0])
+	if err != nil || song < 1 {
+		return 0, 0, fmt.Errorf("invalid song number in --at: %s", parts[0])
+	}
+	seconds, ok := durationSeconds(parts[1])
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid time in --at: %s, use seconds, M:SS or H:MM:SS", parts[1])
+	}
+	return song, seconds, nil
+}
+
+func printEmptyPlaylist(playlistName string) {
+	fmt.Printf("Playlist '%s' has no songs, nothing to play\n", playlistName)
+	fmt.Printf("Use 'mfp refresh %s' to fetch its songs again\n", playlistName)
+}
+
+// lockFile takes an exclusive lock on the named lock file in the profile's
+// data directory, waiting for any other mfp that holds it. Closing the file
+// releases it.
+func lockFile(name string) (*os.File, error) {
+	path := filepath.Join(config.DataDir, name)
+	if config.ReadOnly {
+		path = tempFilePath(config.Profile, name)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// restoreResumePoint makes a bare 'mfp play' resume at the saved song and
+// position, keeping the saved shuffle order unless it no longer matches the
+// playlist
+func restoreResumePoint(playlist *Playlist) {
+	if config.State.IsShuffle && (len(config.State.ShuffleOrder) != len(playlist.Songs) ||
+		config.State.ShuffleIndex < 0 || config.State.ShuffleIndex >= len(config.State.ShuffleOrder)) {
+		initShuffleOrder()
+		config.State.Position = 0
+	}
+	if config.State.CurrentSongIndex < 0 || config.State.CurrentSongIndex >= len(playlist.Songs) {
+		config.State.CurrentSongIndex = 0
+		config.State.Position = 0
+	}
+}
+
+// replaysCurrent reports whether 'mfp play' with these arguments asks for
+// what's already loaded. Any option that changes how playback starts (--at,
+// --volume, ...) needs a fresh start, and so does naming the playlist of a
+// --from/--to session, which plays the whole playlist instead.
+func replaysCurrent(args []string, startOptions bool) bool {
+	if startOptions {
+		return false
+	}
+	return len(args) == 0 || (args[0] == config.State.CurrentPlaylist && config.State.Session == nil)
+}
+
+// showPlayDryRun prints the play order 'mfp play' has set up in the copied
+// state, in place of starting mpv
+func showPlayDryRun(savedOrder []int, randomStart, verifyStart bool) {
+	playlist := currentPlaylist()
+	if playlist == nil {
+		return
+	}
+	name := config.State.CurrentPlaylist
+	if config.State.Session != nil {
+		name = config.State.Session.Name
+	}
+
+	start := config.State.CurrentSongIndex
+	if config.State.IsShuffle {
+		start = config.State.ShuffleIndex
+	}
+	order := playOrder(playlist)
+	if start < 0 || start >= len(order) {
+		start = 0
+	}
+
+	fmt.Printf("Play order for '%s' (shuffle: %s, loop: %s):\n", name,
+		boolToOnOff(config.State.IsShuffle), boolToOnOff(config.State.IsLoop))
+	for i := start; i < len(order); i++ {
+		song := playlist.Songs[order[i]]
+		from := ""
+		if i == start && config.State.Position > 0 {
+			from = ", from " + formatDuration(config.State.Position)
+		}
+		fmt.Printf("  %d. %s (%s%s) [song %d]\n", i-start+1, song.DisplayTitle(), song.Duration, from, order[i]+1)
+	}
+	if config.State.IsLoop && start > 0 {
+		fmt.Println("  ...then repeats from the start of the playlist")
+	}
+	if config.State.IsShuffle && !slices.Equal(config.State.ShuffleOrder, savedOrder) {
+		fmt.Println("\nNote: a new shuffle order is generated on each play, so the real order will differ")
+	} else if randomStart {
+		fmt.Println("\nNote: --random-start picks a new song on each play")
+	}
+	if verifyStart {
+		fmt.Println("Note: --verify-start may start further down if the first songs can't be played")
+	}
+}
+
+// Fixed monitorMpv function to properly track current song
+func monitorMpv() {
+	defer func() {
+		currentCmd = nil
+		emitEvent("stop", nil)
+		// A newer daemon may already own the state and socket
+		if !isCurrentDaemon() {
+			return
+		}
+		endSession()
+	}()
+
+	// Wait for socket to be available
+	maxWait := 10 // seconds
+	for i := 0; i < maxWait; i++ {
+		if _, err := os.Stat(config.SocketFile); err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+		if i == maxWait-1 {
+			fmt.Println("Error: MPV socket not created, playback may have failed")
+			return
+		}
+	}
+
+	fmt.Println("MPV connection established")
+	lastPlaylistPos := -1 // Track the last known position to detect changes
+	endPos, endLength := -1, 0
+
+	// mpv applies --start to every file, so drop it once the resumed song is playing
+	resumePending := config.State.Position > 0
+	resumeTarget := config.State.Position
+
+	// Song changes are saved as they happen; the position in between is
+	// saved every position_save_interval so a crash loses little of it
+	lastPositionSave := time.Now()
+
+	// Set while quiet hours hold the volume down (or paused playback)
+	quietActive := false
+
+	// When mpv started waiting on its cache, zero while it isn't
+	var bufferingSince time.Time
+	bufferingNotified := false
+
+	// Only watched for 'mfp events' subscribers; -1 until the first reading
+	lastVolume, lastEventPos := -1, -1
+	lastPaused := false
+	lastEventCheck := time.Now()
+
+	// mpv's path for the current entry, to tell a rearranged playlist from a song change
+	var lastPath interface{}
+
+	// The silence filter mpv has now, to follow skip_silence changes; and the
+	// last gap skipped, since mpv keeps reporting it until the next one
+	activeSilenceFilter := silenceFilter()
+	lastSilenceStart := ""
+
+	// The second mpv for 'mfp mirror' and the device it plays on, started
+	// and stopped here as the mirror setting changes
+	var mirrorCmd *exec.Cmd
+	var mirrorSyncing atomic.Bool
+	mirrorDevice := ""
+	defer func() { stopMirror(mirrorCmd) }()
+
+	// Playback time for 'mfp limit', only counted while a limit is set and
+	// mpv isn't paused
+	played := float64(config.State.PlayedSeconds)
+	lastPlayedCheck := time.Now()
+	var limitPaused interface{}
+
+	// The song that started last, until it has played for songSettleDelay;
+	// every song change starts the wait over
+	var pendingSong *Song
+	var pendingPlaylist string
+	var pendingSince time.Time
+
+	for {
+		if currentCmd == nil {
+			break
+		}
+
+		// Check if process is still running
+		select {
+		case <-mpvExited:
+			fmt.Println("MPV process ended")
+			return
+		default:
+		}
+
+		stateMu.Lock()
+
+		// Pick up changes made by other mfp commands
+		reloadConfig()
+
+		// Update position
+		pos := getMpvPosition()
+		// The interrupted song's place is kept while an interjection plays
+		interjecting := config.State.Interjection != nil && config.State.Interjection.Started
+		if pos >= 0 && !interjecting {
+			config.State.Position = pos
+			if resumePending {
+				sendMpvCommand("set start none")
+				resumePending = false
+				// Some streams ignore --start; seek there explicitly if so
+				if pos < resumeTarget-3 || pos > resumeTarget+3 {
+					fmt.Printf("Resume landed at %s instead of %s, seeking\n", formatDuration(pos), formatDuration(resumeTarget))
+					sendMpvCommandArgs("seek", resumeTarget, "absolute")
+					config.State.Position = resumeTarget
+				}
+			}
+			if time.Since(lastPositionSave) >= positionSaveInterval() {
+				saveConfig()
+				lastPositionSave = time.Now()
+			}
+		}
+
+		// A long stall on the cache is the network, say so once per stall
+		if buffering, percent := mpvBuffering(); buffering {
+			if bufferingSince.IsZero() {
+				bufferingSince = time.Now()
+			} else if !bufferingNotified && time.Since(bufferingSince) >= bufferingNotifyAfter {
+				fmt.Printf("Buffering for %s (cache %d%%), the network is slow\n", formatDuration(int(time.Since(bufferingSince).Seconds())), percent)
+				if song := currentSong(); song != nil {
+					go notifyBuffering(*song)
+				}
+				bufferingNotified = true
+			}
+		} else {
+			bufferingSince = time.Time{}
+			bufferingNotified = false
+		}
+
+		// Quiet hours cap the volume (or pause once) inside the window, and
+		// put things back when it ends
+		if inQuietHours(time.Now()) {
+			quietCap, pause := quietVolume()
+			if pause {
+				if !quietActive {
+					fmt.Println("Quiet hours: pausing playback")
+					sendMpvCommand("set pause yes")
+				}
+			} else if volume, ok := getMpvFloatProperty("volume"); ok && int(volume) > quietCap {
+				if !quietActive {
+					fmt.Printf("Quiet hours: volume capped at %d%%\n", quietCap)
+				}
+				sendMpvCommand(fmt.Sprintf("set volume %d", quietCap))
+			}
+			quietActive = true
+		} else if quietActive {
+			quietActive = false
+			if _, pause := quietVolume(); pause {
+				fmt.Println("Quiet hours over, resuming playback")
+				sendMpvCommand("set pause no")
+			} else {
+				fmt.Printf("Quiet hours over, volume back to %d%%\n", *currentVolume())
+				sendMpvCommand(fmt.Sprintf("set volume %d", *currentVolume()))
+			}
+		}
+
+		// In single-song mode mpv holds the end of the song open; stop there.
+		// The end of an interjection is handled below instead.
+		if config.State.SingleSong && !interjecting {
+			if eof, err := getMpvProperty("eof-reached"); err == nil && eof == true {
+				fmt.Println("Song finished, stopping (single-song mode)")
+				handleStop()
+				stateMu.Unlock()
+				return
+			}
+		}
+
+		if config.State.PlayLimitAt > 0 {
+			paused, err := getMpvProperty("pause")
+			if err == nil && paused == false {
+				played += time.Since(lastPlayedCheck).Seconds()
+			}
+			config.State.PlayedSeconds = int(played)
+			config.State.PlayedAsOf = time.Now()
+			// playedSeconds counts on from PlayedAsOf while playing, so save
+			// when pausing or resuming to keep that right
+			if err == nil && paused != limitPaused {
+				limitPaused = paused
+				saveConfig()
+			}
+			if config.State.PlayedSeconds >= config.State.PlayLimitAt {
+				fmt.Println("Playback limit reached, stopping")
+				handleStop()
+				stateMu.Unlock()
+				return
+			}
+		}
+		lastPlayedCheck = time.Now()
+
+		if config.State.MirrorDevice != mirrorDevice {
+			stopMirror(mirrorCmd)
+			mirrorCmd = nil
+			mirrorDevice = config.State.MirrorDevice
+			if mirrorDevice != "" {
+				if cmd, err := startMirror(mirrorDevice); err != nil {
+					fmt.Printf("Error starting mirror on %s: %v\n", mirrorDevice, err)
+				} else {
+					mirrorCmd = cmd
+					fmt.Printf("Mirroring to %s\n", mirrorDevice)
+				}
+			}
+		}
+		// A hung mirror mustn't hold up the main player, so it's synced
+		// outside stateMu, one sync at a time
+		if mirrorCmd != nil && mirrorSyncing.CompareAndSwap(false, true) {
+			go func(pos int) {
+				defer mirrorSyncing.Store(false)
+				syncMirror(pos)
+			}(pos)
+		}
+
+		if filter := silenceFilter(); filter != activeSilenceFilter {
+			sendMpvCommandArgs("af", "remove", "@mfpsilence")
+			if filter != "" {
+				sendMpvCommandArgs("af", "add", filter)
+			}
+			activeSilenceFilter = filter
+		}
+		if activeSilenceFilter != "" {
+			if value, err := getMpvProperty("af-metadata/mfpsilence"); err == nil {
+				metadata, _ := value.(map[string]interface{})
+				start, silent := metadata["lavfi.silence_start"].(string)
+				if _, ended := metadata["lavfi.silence_end"]; silent && !ended && start != lastSilenceStart {
+					fmt.Printf("Skipping silence at %s\n", formatDuration(pos))
+					sendMpvCommandArgs("seek", silenceSeekStep, "relative")
+					lastSilenceStart = start
+				}
+			}
+		}
+
+		if pendingSong != nil && time.Since(pendingSince) >= songSettleDelay {
+			go notifySongChange(*pendingSong, pendingPlaylist)
+			appendHistory(*pendingSong, pendingPlaylist)
+			clearProblem(pendingSong.VideoID)
+			pendingSong = nil
+		}
+
+		// Update current song index based on mpv's playlist position
+		playlistPos := getMpvPlaylistPosition()
+
+		// Whether the song before a change ran out rather than being
+		// skipped, going by how far into it the last check was
+		ranOut := endLength > 0 && endPos >= endLength-songEndSlack
+		endPos, endLength = pos, 0
+		if config.State.PauseAfterSong {
+			if length, ok := getMpvFloatProperty("duration"); ok {
+				endLength = int(length)
+			}
+		}
+
+		// Volume, pause and seeks don't pass through here, so poll for them
+		// while someone is listening
+		if hasEventSubscribers() {
+			if volume, ok := getMpvFloatProperty("volume"); ok {
+				if lastVolume >= 0 && int(volume) != lastVolume {
+					emitEvent("volume-change", map[string]interface{}{"volume": int(volume)})
+				}
+				lastVolume = int(volume)
+			}
+			if value, err := getMpvProperty("pause"); err == nil {
+				if paused, ok := value.(bool); ok && paused != lastPaused {
+					if paused {
+						emitEvent("pause", map[string]interface{}{"position": pos})
+					} else {
+						emitEvent("play", map[string]interface{}{"position": pos})
+					}
+					lastPaused = paused
+				}
+			}
+			// Within the same song, a position that moved much more or less
+			// than the time that passed means a seek
+			if pos >= 0 && lastEventPos >= 0 && playlistPos == lastPlaylistPos {
+				expected := lastEventPos
+				if !lastPaused {
+					expected += int(time.Since(lastEventCheck).Seconds() + 0.5)
+				}
+				if pos < expected-2 || pos > expected+2 {
+					emitEvent("seek", map[string]interface{}{"position": pos})
+				}
+			}
+			lastEventPos = pos
+			lastEventCheck = time.Now()
+		} else {
+			lastVolume, lastEventPos = -1, -1
+		}
+		// mpv holds the end of an interjection open (see handleInterject)
+		// rather than stopping or quitting there
+		interjectionEnded := false
+		if in := config.State.Interjection; in != nil && in.Started && playlistPos == in.MpvPos {
+			eof, err := getMpvProperty("eof-reached")
+			interjectionEnded = err == nil && eof == true
+		}
+		if (playlistPos >= 0 && playlistPos != lastPlaylistPos || interjectionEnded) && config.State.Interjection != nil {
+			in := config.State.Interjection
+			if playlistPos == in.MpvPos && !in.Started {
+				in.Started = true
+				fmt.Printf("Now playing (interjection): %s\n", in.Song.DisplayTitle())
+				emitSongChange(in.Song, 0)
+				pendingSong, pendingPlaylist, pendingSince = &in.Song, "", time.Now()
+				lastPlaylistPos = playlistPos
+				lastPath = nil
+				saveConfig()
+				stateMu.Unlock()
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			if in.Started {
+				// Finished or skipped: pick up where we were and take the
+				// one-off song out so mpv's playlist lines up with ours again.
+				// It goes after the switch, as removing the entry playing
+				// would move mpv on, or end it after the last song.
+				sendMpvCommandArgs("set_property", "start", strconv.Itoa(in.ReturnTime))
+				sendMpvCommandArgs("playlist-play-index", in.ReturnPos)
+				sendMpvCommandArgs("playlist-remove", in.MpvPos)
+				sendMpvCommandArgs("set_property", "keep-open", keepOpenMode())
+				if interjectionEnded {
+					// Held open at its end, which pauses mpv
+					sendMpvCommand("set pause no")
+				}
+				// Only just told to play, from the interrupted spot
+				pauseAtSongEnd(ranOut, false)
+				resumePending, resumeTarget = true, in.ReturnTime
+				config.State.Position = in.ReturnTime
+				config.State.Interjection = nil
+				if song := currentSong(); song != nil {
+					fmt.Printf("Back to: %s at %s\n", song.DisplayTitle(), formatDuration(in.ReturnTime))
+					emitSongChange(*song, getCurrentSongIndex()+1)
+				}
+				pendingSong = nil
+				lastPlaylistPos = in.ReturnPos
+				lastPath = nil
+				saveConfig()
+				stateMu.Unlock()
+				time.Sleep(1 * time.Second)
+				continue
+			}
+		}
+		if playlistPos >= 0 && playlistPos != lastPlaylistPos {
+			// 'mfp shuffle' moves the playing entry; the same file still
+			// playing on is not a song change
+			path, _ := getMpvProperty("path")
+			if path != nil && path == lastPath && pos >= 2 {
+				lastPlaylistPos = playlistPos
+				stateMu.Unlock()
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			lastPath = path
+			pendingSong = nil
+			pauseAtSongEnd(ranOut, true)
+
+			// Count finished passes for 'mfp loop <times>'; on the last one mpv
+			// stops looping, so playback ends after it
+			if playlist := currentPlaylist(); playlist != nil && config.State.IsLoop && config.State.LoopCount > 0 &&
+				playlistPos == 0 && lastPlaylistPos > 0 && lastPlaylistPos == len(playOrder(playlist))+len(config.State.TempQueue)-1 {
+				config.State.LoopsDone++
+				if onFinalLoop() {
+					fmt.Printf("Starting the last pass (%d/%d)\n", config.State.LoopCount, config.State.LoopCount)
+					sendMpvCommand("set loop-playlist no")
+				} else {
+					fmt.Printf("Starting pass %d/%d\n", config.State.LoopsDone+1, config.State.LoopCount)
+				}
+			}
+
+			// A looped shuffle wrapping from the last song to the first
+			// (by itself or through 'next') gets a fresh order for the new pass
+			if playlistPos == 0 && lastPlaylistPos == len(config.State.ShuffleOrder)-1 && lastPlaylistPos > 0 &&
+				config.State.IsShuffle && config.State.IsLoop && config.State.ReshuffleOnLoop {
+				reshuffleForNextLoop()
+			}
+
+			// MPV playlist position changed - update our state
+			movedBack := playlistPos == lastPlaylistPos-1
+			lastPlaylistPos = playlistPos
+
+			playlist := currentPlaylist()
+			if playlist != nil {
+				if config.State.IsShuffle {
+					// In shuffle mode, playlistPos is the index in the shuffled order
+					if playlistPos < len(config.State.ShuffleOrder) {
+						config.State.ShuffleIndex = playlistPos
+						config.State.CurrentSongIndex = config.State.ShuffleOrder[playlistPos]
+					}
+				} else {
+					// In normal mode, playlistPos is the direct song index
+					if playlistPos < len(playlist.Songs) {
+						config.State.CurrentSongIndex = playlistPos
+					}
+				}
+
+				// Past the playlist's own songs are the ones from queue-playlist
+				if queuedIndex := playlistPos - len(playOrder(playlist)); queuedIndex >= 0 && queuedIndex < len(config.State.TempQueue) {
+					fmt.Printf("Now playing (queued): %s\n", config.State.TempQueue[queuedIndex].DisplayTitle())
+					emitSongChange(config.State.TempQueue[queuedIndex], playlistPos+1)
+					stateMu.Unlock()
+					time.Sleep(1 * time.Second)
+					continue
+				}
+
+				// Move past skip-always and blacklisted songs in the direction
+				// we were going
+				if song := currentSong(); song != nil && isSkipped(*song) {
+					if allSkipped(playlist) {
+						fmt.Println("Every song is marked skip-always or blacklisted, stopping")
+						handleStop()
+						stateMu.Unlock()
+						return
 					}
+					reason := "skip-always"
+					if !song.Skip {
+						reason = "blacklisted"
+					}
+					fmt.Printf("Skipping: %s (%s)\n", song.DisplayTitle(), reason)
+					if movedBack && playlistPos > 0 {
+						sendMpvCommand("playlist-prev")
+					} else {
+						sendMpvCommand("playlist-next")
+					}
+					saveConfig()
+					stateMu.Unlock()
+					time.Sleep(1 * time.Second)
+					continue
+				}
+
+				// Save the updated state
+				if err := saveConfig(); err == nil {
+					// Optional: Print song change notification
+					if playlistPos < len(playlist.Songs) {
+						currentIndex := getCurrentSongIndex()
+						if currentIndex < len(playlist.Songs) {
+							fmt.Printf("Now playing: %s\n", playlist.Songs[currentIndex].DisplayTitle())
+							updateMediaTitle()
+							runHook("song_change", config.Settings.OnSongChange)
+							song := playlist.Songs[currentIndex]
+							pendingSong, pendingPlaylist, pendingSince = &song, config.State.CurrentPlaylist, time.Now()
+							emitSongChange(playlist.Songs[currentIndex], currentIndex+1)
+						}
+					}
+				}
+			}
+		}
+
+		stateMu.Unlock()
+		time.Sleep(1 * time.Second) // Check every second for better responsiveness
+	}
+}
+
+// Improved getMpvPlaylistPosition with better error handling
+func getMpvPlaylistPosition() int {
+	if _, err := os.Stat(config.SocketFile); os.IsNotExist(err) {
+		return -1
+	}
+
+	request := `{"command": ["get_property", "playlist-pos"]}`
+	output, err := mpvRequest(config.SocketFile, request)
+	debugIPC(request, output)
+	if err != nil {
+		return -1
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return -1
+	}
+
+	if data, ok := response["data"].(float64); ok {
+		return int(data)
+	}
+
+	return -1
+}
+
+// getMpvProperty reads a single property over mpv's IPC socket
+func getMpvProperty(name string) (interface{}, error) {
+	return getMpvPropertyAt(config.SocketFile, name)
+}
+
+// getMpvPropertyAt reads a property from the mpv listening on socket
+func getMpvPropertyAt(socket, name string) (interface{}, error) {
+	if _, err := os.Stat(socket); os.IsNotExist(err) {
+		return nil, fmt.Errorf("mpv socket not found")
+	}
+
+	request := fmt.Sprintf(`{"command": ["get_property", "%s"]}`, name)
+	output, err := mpvRequest(socket, request)
+	debugIPC(request, output)
+	if err != nil {
+		return nil, fmt.Errorf("mpv not responding: %v", err)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, fmt.Errorf("invalid response from mpv: %v", err)
+	}
+
+	if errMsg, ok := response["error"].(string); ok && errMsg != "success" {
+		return nil, fmt.Errorf("mpv: %s", errMsg)
+	}
+
+	return response["data"], nil
+}
+
+// getMpvFloatProperty reads a numeric property, reporting false if mpv
+// doesn't have it (e.g. duration of a live stream)
+func getMpvFloatProperty(name string) (float64, bool) {
+	data, err := getMpvProperty(name)
+	if err != nil {
+		return 0, false
+	}
+	value, ok := data.(float64)
+	return value, ok
+}
+
+// getMpvPid returns the pid of the mpv listening on our socket, or -1 if none responds
+func getMpvPid() int {
+	data, err := getMpvProperty("pid")
+	if err != nil {
+		return -1
+	}
+	if pid, ok := data.(float64); ok {
+		return int(pid)
+	}
+	return -1
+}
+
+// checkOrphanedMpv reconciles our state with whatever mpv is behind the socket.
+// An mpv that answers while we think nothing is playing is left over from a
+// crashed session; it is killed after confirmation (or straight away with force).
+// Returns false if playback should not go ahead.
+func checkOrphanedMpv(force bool) bool {
+	pid := getMpvPid()
+	if pid < 0 {
+		if currentCmd == nil {
+			// Nothing is actually playing, the saved state and socket are stale
+			if config.State.IsPlaying {
+				config.State.IsPlaying = false
+				saveConfig()
+			}
+			os.Remove(config.SocketFile)
+		}
+		return true
+	}
+
+	if config.State.IsPlaying {
+		return true
+	}
+
+	fmt.Printf("Found an orphaned mpv process (pid %d) from a previous session\n", pid)
+	if !force && !confirm("Kill it and start fresh?") {
+		fmt.Println("Aborted. Use 'mfp play --force' to kill it without asking.")
+		return false
+	}
+
+	if process, err := os.FindProcess(pid); err == nil {
+		process.Kill()
+	}
+	os.Remove(config.SocketFile)
+	fmt.Printf("Killed orphaned mpv process (pid %d)\n", pid)
+	return true
+}
+
+// Improved getMpvPosition with better error handling
+func getMpvPosition() int {
+	if _, err := os.Stat(config.SocketFile); os.IsNotExist(err) {
+		return -1
+	}
+
+	request := `{"command": ["get_property", "time-pos"]}`
+	output, err := mpvRequest(config.SocketFile, request)
+	debugIPC(request, output)
+	if err != nil {
+		return -1
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return -1
+	}
+
+	if data, ok := response["data"].(float64); ok {
+		return int(data)
+	}
+
+	return -1
+}
+
+// Improved the getCurrentSongIndex function for better safety
+func getCurrentSongIndex() int {
+	if config.State.CurrentPlaylist == "" {
+		return 0
+	}
+
+	playlist := currentPlaylist()
+	if playlist == nil {
+		return 0
+	}
+
+	if config.State.IsShuffle {
+		if config.State.ShuffleIndex >= 0 && config.State.ShuffleIndex < len(config.State.ShuffleOrder) {
+			shuffledIndex := config.State.ShuffleOrder[config.State.ShuffleIndex]
+			if shuffledIndex >= 0 && shuffledIndex < len(playlist.Songs) {
+				return shuffledIndex
+			}
+		}
+		return 0
+	}
+
+	if config.State.CurrentSongIndex >= 0 && config.State.CurrentSongIndex < len(playlist.Songs) {
+		return config.State.CurrentSongIndex
+	}
+
+	return 0
+}
+
+// mirrorSocketPath is the IPC socket of the 'mfp mirror' mpv, next to the main one
+func mirrorSocketPath() string {
+	return filepath.Join(filepath.Dir(config.SocketFile), "mirror-socket")
+}
+
+// mirrorDriftLimit is how far, in seconds, the mirror may drift from the
+// main player before it's seeked back in step
+const mirrorDriftLimit = 2
+
+// startMirror starts an idle mpv on device; syncMirror then loads and
+// follows whatever the main player plays
+func startMirror(device string) (*exec.Cmd, error) {
+	socket := mirrorSocketPath()
+	os.Remove(socket)
+	args := []string{
+		"--no-video",
+		"--no-terminal",
+		"--idle=yes",
+		"--input-ipc-server=" + socket,
+		"--audio-device=" + device,
+		"--volume=" + strconv.Itoa(*currentVolume()),
+		"--ytdl-format=" + audioFormat(),
+		"--quiet",
+	}
+	args = append(args, config.Settings.MpvExtraArgs...)
+	args = append(args, config.State.MpvArgs...)
+
+	cmd := exec.Command("mpv", args...)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start mpv: %v", err)
+	}
+	go cmd.Wait()
+	return cmd, nil
+}
+
+func stopMirror(cmd *exec.Cmd) {
+	if cmd == nil {
+		return
+	}
+	sendMpvCommandArgsAt(mirrorSocketPath(), "quit")
+	time.Sleep(100 * time.Millisecond)
+	cmd.Process.Kill()
+	os.Remove(mirrorSocketPath())
+}
+
+// syncMirror brings the mirror to the main player's song, position, pause
+// and volume. It's only roughly in step: each player buffers on its own.
+func syncMirror(pos int) {
+	socket := mirrorSocketPath()
+	path, err := getMpvProperty("path")
+	if err != nil {
+		return
+	}
+	if mirrorPath, _ := getMpvPropertyAt(socket, "path"); mirrorPath != path {
+		sendMpvCommandArgsAt(socket, "loadfile", path, "replace")
+		return
+	}
+	if mirrorPos, err := getMpvPropertyAt(socket, "time-pos"); err == nil && pos >= 0 {
+		if value, ok := mirrorPos.(float64); ok && (int(value) < pos-mirrorDriftLimit || int(value) > pos+mirrorDriftLimit) {
+			sendMpvCommandArgsAt(socket, "seek", pos, "absolute")
+		}
+	}
+	if paused, err := getMpvProperty("pause"); err == nil {
+		if mirrorPaused, _ := getMpvPropertyAt(socket, "pause"); mirrorPaused != paused {
+			sendMpvCommandArgsAt(socket, "set_property", "pause", paused)
+		}
+	}
+	if volume, err := getMpvProperty("volume"); err == nil {
+		if mirrorVolume, _ := getMpvPropertyAt(socket, "volume"); mirrorVolume != volume {
+			sendMpvCommandArgsAt(socket, "set_property", "volume", volume)
+		}
+	}
+}
+
+func handleMirror(args []string) {
+	if len(args) == 0 {
+		if config.State.MirrorDevice == "" {
+			fmt.Println("Not mirroring")
+		} else {
+			fmt.Printf("Mirroring to %s\n", config.State.MirrorDevice)
+		}
+		return
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp mirror [<device>|devices|off]")
+		return
+	}
+
+	switch args[0] {
+	case "devices":
+		output, err := exec.Command("mpv", "--audio-device=help").Output()
+		if err != nil {
+			fmt.Printf("Error listing audio devices: %v\n", err)
+			return
+		}
+		fmt.Print(string(output))
+	case "off":
+		if config.State.MirrorDevice == "" {
+			fmt.Println("Not mirroring")
+			return
+		}
+		config.State.MirrorDevice = ""
+		saveConfig()
+		fmt.Println("Mirror stopped")
+	default:
+		if !config.State.IsPlaying {
+			fmt.Println("Nothing is playing; start playback first")
+			return
+		}
+		config.State.MirrorDevice = args[0]
+		saveConfig()
+		fmt.Printf("Mirroring to %s (roughly in sync; 'mfp mirror off' to stop)\n", args[0])
+	}
+}
+
+// Improve startMpv function
+func startMpv(playlistFile string) error {
+	// Clean up old socket
+	os.Remove(config.SocketFile)
+
+	startIndex := config.State.CurrentSongIndex
+	if config.State.IsShuffle {
+		startIndex = config.State.ShuffleIndex
+	}
+
+	// With fade on, start silent and let fadeIn bring the volume up
+	startVolume := *currentVolume()
+	if config.Settings.Fade > 0 {
+		startVolume = 0
+	}
+
+	args := []string{
+		"--no-video",
+		"--no-terminal", // Run in background
+		"--input-ipc-server=" + config.SocketFile,
+		"--volume=" + strconv.Itoa(startVolume),
+		"--playlist=" + playlistFile,
+		"--playlist-start=" + strconv.Itoa(startIndex),
+		"--ytdl-format=" + audioFormat(),
+		"--quiet", // Reduce output noise
+	}
+
+	// Resume the first song where it was left off
+	if config.State.Position > 0 {
+		args = append(args, "--start="+strconv.Itoa(config.State.Position))
+	}
+
+	if config.State.SingleSong {
+		// Never advance on our own; the monitor stops playback at the end of the song
+		args = append(args, "--keep-open="+keepOpenMode())
+	} else if config.State.IsLoop && !onFinalLoop() {
+		args = append(args, "--loop-playlist=inf")
+	}
+
+	// mpv resolves YouTube URLs through yt-dlp, which needs the cookies too
+	if cookies := cookiesFile(); cookies != "" {
+		args = append(args, "--ytdl-raw-options=cookies="+cookies)
+	}
+
+	// User arguments go last so mpv lets them win: one-off --mpv-arg values
+	// over mpv_extra_args over mfp's defaults. config.json may have been
+	// edited by hand, so check the saved ones again.
+	for _, extraArgs := range [][]string{config.Settings.MpvExtraArgs, config.State.MpvArgs} {
+		if err := checkMpvArgs(extraArgs); err != nil {
+			fmt.Printf("Warning: ignoring extra mpv arguments: %v\n", err)
+			continue
+		}
+		args = append(args, extraArgs...)
+	}
+
+	// The EQ is added to whatever filter chain the arguments above set up
+	for _, band := range eqBands {
+		if gain := *band.gain(); gain != 0 {
+			args = append(args, "--af-add="+eqFilter(band.name, gain))
+		}
+	}
+	if filter := silenceFilter(); filter != "" {
+		args = append(args, "--af-add="+filter)
+	}
+
+	currentCmd = exec.Command("mpv", args...)
+
+	// mpv's output is only read for the errors of songs it can't play; a
+	// goroutine drains it so mpv never blocks on a full pipe
+	output, outputWriter, err := os.Pipe()
+	if err == nil {
+		currentCmd.Stdout = outputWriter
+		currentCmd.Stderr = outputWriter
+	}
+
+	if err := currentCmd.Start(); err != nil {
+		if output != nil {
+			output.Close()
+			outputWriter.Close()
+		}
+		return fmt.Errorf("failed to start mpv: %v", err)
+	}
+	if output != nil {
+		outputWriter.Close()
+		go watchMpvErrors(output)
+	}
+
+	mpvExited = make(chan struct{})
+	go func(cmd *exec.Cmd, exited chan struct{}) {
+		cmd.Wait()
+		close(exited)
+	}(currentCmd, mpvExited)
+
+	return nil
+}
+
+// mpvYtdlErrorPattern matches mpv's report of a video yt-dlp couldn't open,
+// e.g. "[ytdl_hook] ERROR: [youtube] <id>: Video unavailable"
+var mpvYtdlErrorPattern = regexp.MustCompile(`ytdl_hook\] ERROR: \[youtube\] ([A-Za-z0-9_-]{11}): (.*)`)
+
+// watchMpvErrors records the songs mpv fails to play as problems; mpv skips
+// them by itself
+func watchMpvErrors(output io.ReadCloser) {
+	defer output.Close()
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		matches := mpvYtdlErrorPattern.FindStringSubmatch(scanner.Text())
+		if matches == nil {
+			continue
+		}
+		stateMu.Lock()
+		reason := describeYtdlpOutput(matches[2])
+		var song *Song
+		if playlist := currentPlaylist(); playlist != nil {
+			for i := range playlist.Songs {
+				if playlist.Songs[i].VideoID == matches[1] {
+					song = &playlist.Songs[i]
+					break
+				}
+			}
+		}
+		if song != nil {
+			fmt.Printf("Could not play: %s (%v)\n", song.DisplayTitle(), reason)
+			recordProblem(*song, playlistNameForProblems(), reason)
+		}
+		stateMu.Unlock()
+	}
+}
+
+// Improve handleCurrent function
+func handleCurrent(args []string) {
+	args, verbose := extractFlag(args, "--verbose")
+	if len(args) > 0 {
+		fmt.Println("Usage: mfp current [--verbose]")
+		return
+	}
+	if config.State.CurrentPlaylist == "" {
+		fmt.Println("No playlist is currently loaded")
+		return
+	}
+
+	playlist := currentPlaylist()
+	if playlist == nil {
+		fmt.Println("Current playlist not found")
+		return
+	}
+
+	currentIndex := getCurrentSongIndex()
+	if currentIndex >= len(playlist.Songs) || currentIndex < 0 {
+		fmt.Println("No current song")
+		return
+	}
+
+	song := playlist.Songs[currentIndex]
+	status := "Paused"
+	if config.State.IsPlaying {
+		status = "Playing"
+	}
+
+	if in := config.State.Interjection; in != nil && in.Started && config.State.IsPlaying {
+		fmt.Println("Current Song (Interjection):")
+		fmt.Printf("  Title: %s\n", in.Song.Title)
+		if in.Song.Artist != "" {
+			fmt.Printf("  Artist: %s\n", in.Song.Artist)
+		}
+		fmt.Printf("  Duration: %s\n", in.Song.Duration)
+		if pos := getMpvPosition(); pos >= 0 {
+			fmt.Printf("  Time: %s\n", formatDuration(pos))
+		}
+		fmt.Printf("  Then back to: %s at %s\n", song.DisplayTitle(), formatDuration(in.ReturnTime))
+		return
+	}
+
+	fmt.Printf("Current Song (%s):\n", status)
+	fmt.Printf("  Title: %s\n", song.Title)
+	if song.Artist != "" {
+		fmt.Printf("  Artist: %s\n", song.Artist)
+	}
+	fmt.Printf("  Duration: %s\n", song.Duration)
+	fmt.Printf("  Position: %d/%d in playlist\n", currentIndex+1, len(playlist.Songs))
+	fmt.Printf("  Playlist: %s\n", config.State.CurrentPlaylist)
+	if song.Note != "" {
+		fmt.Printf("  Note: %s\n", song.Note)
+	}
+
+	// Try to get current position from mpv
+	if config.State.IsPlaying {
+		if pos := getMpvPosition(); pos >= 0 {
+			fmt.Printf("  Time: %s\n", formatDuration(pos))
+		}
+		if chapters := getMpvChapters(); len(chapters) > 0 {
+			if current, ok := getMpvFloatProperty("chapter"); ok && int(current) >= 0 && int(current) < len(chapters) {
+				fmt.Printf("  Chapter: %d/%d %s\n", int(current)+1, len(chapters), chapters[int(current)].Title)
+			}
+		}
+		if verbose {
+			printStreamInfo()
+		}
+	}
+}
+
+// printStreamInfo shows what mpv reports about the audio stream; some of it
+// is unavailable until the stream has started, or for some formats
+func printStreamInfo() {
+	codec := "unknown"
+	if value, err := getMpvProperty("audio-codec-name"); err == nil {
+		if name, ok := value.(string); ok && name != "" {
+			codec = name
+		}
+	}
+	bitrate := "unknown"
+	if value, ok := getMpvFloatProperty("audio-bitrate"); ok && value > 0 {
+		bitrate = fmt.Sprintf("%d kbps", int(value/1000+0.5))
+	}
+	format := "unknown"
+	if value, err := getMpvProperty("audio-params"); err == nil {
+		if params, ok := value.(map[string]interface{}); ok {
+			var parts []string
+			if rate, ok := params["samplerate"].(float64); ok {
+				parts = append(parts, fmt.Sprintf("%d Hz", int(rate)))
+			}
+			if channels, ok := params["hr-channels"].(string); ok && channels != "" {
+				parts = append(parts, channels)
+			} else if count, ok := params["channel-count"].(float64); ok {
+				parts = append(parts, fmt.Sprintf("%d channels", int(count)))
+			}
+			if sample, ok := params["format"].(string); ok && sample != "" {
+				parts = append(parts, sample)
+			}
+			if len(parts) > 0 {
+				format = strings.Join(parts, ", ")
+			}
+		}
+	}
+	fmt.Printf("  Codec: %s\n", codec)
+	fmt.Printf("  Bitrate: %s\n", bitrate)
+	fmt.Printf("  Audio: %s\n", format)
+}
+
+func historyFile() string {
+	return filepath.Join(config.DataDir, "history.json")
+}
+
+func loadHistory() []HistoryEntry {
+	var entries []HistoryEntry
+	if data, err := ioutil.ReadFile(historyFile()); err == nil {
+		json.Unmarshal(data, &entries)
+	}
+	return entries
+}
+
+func saveHistory(entries []HistoryEntry) error {
+	if config.ReadOnly {
+		return nil
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(historyFile(), data, 0644); err != nil {
+		return handleWriteError(err)
+	}
+	return nil
+}
+
+// appendHistory records that song started playing, pruning old entries per
+// the history_max/history_days settings as it goes
+func appendHistory(song Song, playlistName string) {
+	entries := append(loadHistory(), HistoryEntry{
+		PlayedAt: time.Now(),
+		Playlist: playlistName,
+		Title:    song.Title,
+		Artist:   song.Artist,
+		VideoID:  song.VideoID,
+	})
+	if err := saveHistory(pruneHistory(entries)); err != nil {
+		fmt.Printf("Error saving history: %v\n", err)
+	}
+}
+
+// ProblemEntry is a song that failed to play or download, for 'mfp problems'
+type ProblemEntry struct {
+	At       time.Time `json:"at"`
+	Playlist string    `json:"playlist"`
+	Title    string    `json:"title"`
+	Artist   string    `json:"artist,omitempty"`
+	VideoID  string    `json:"video_id"`
+	Reason   string    `json:"reason"`
+}
+
+// maxProblems bounds problems.json; the oldest entries go first
+const maxProblems = 200
+
+func problemsFile() string {
+	return filepath.Join(config.DataDir, "problems.json")
+}
+
+func loadProblems() []ProblemEntry {
+	var entries []ProblemEntry
+	if data, err := ioutil.ReadFile(problemsFile()); err == nil {
+		json.Unmarshal(data, &entries)
+	}
+	return entries
+}
+
+func saveProblems(entries []ProblemEntry) error {
+	if config.ReadOnly {
+		return nil
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(problemsFile(), data, 0644); err != nil {
+		return handleWriteError(err)
+	}
+	return nil
+}
+
+// recordProblem notes that song failed; a song keeps only its latest problem
+// per playlist
+func recordProblem(song Song, playlistName string, reason error) {
+	entries := loadProblems()
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.VideoID != song.VideoID || entry.Playlist != playlistName {
+			kept = append(kept, entry)
+		}
+	}
+	kept = append(kept, ProblemEntry{
+		At:       time.Now(),
+		Playlist: playlistName,
+		Title:    song.Title,
+		Artist:   song.Artist,
+		VideoID:  song.VideoID,
+		Reason:   reason.Error(),
+	})
+	if len(kept) > maxProblems {
+		kept = kept[len(kept)-maxProblems:]
+	}
+	if err := saveProblems(kept); err != nil {
+		fmt.Printf("Error saving problems: %v\n", err)
+	}
+}
+
+// clearProblem forgets the problems of a song that has since played fine
+func clearProblem(videoID string) {
+	entries := loadProblems()
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.VideoID != videoID {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) < len(entries) {
+		saveProblems(kept)
+	}
+}
+
+func handleProblems(args []string) {
+	args, remove := extractFlag(args, "--remove")
+	args, yes := extractFlag(args, "--yes")
+	args, clear := extractFlag(args, "--clear")
+	if len(args) > 0 || remove && clear {
+		fmt.Println("Usage: mfp problems [--remove [--yes] | --clear]")
+		return
+	}
+
+	entries := loadProblems()
+	if len(entries) == 0 {
+		fmt.Println("No problems recorded")
+		return
+	}
+	if clear {
+		saveProblems(nil)
+		fmt.Printf("Forgot %d problems\n", len(entries))
+		return
+	}
+
+	if !remove {
+		fmt.Println("Songs that failed to play or download:")
+		for i := len(entries) - 1; i >= 0; i-- {
+			entry := entries[i]
+			song := Song{Title: entry.Title, Artist: entry.Artist}
+			where := entry.Playlist
+			if where == "" {
+				where = "-"
+			}
+			fmt.Printf("  %s  %-12s %s\n", entry.At.Format("2006-01-02 15:04"), where, song.DisplayTitle())
+			fmt.Println(colorize("2", "      "+entry.Reason))
+		}
+		fmt.Println("\nRemove them from their playlists with 'mfp problems --remove'")
+		return
+	}
+
+	failed := make(map[string]map[string]bool)
+	total := 0
+	for _, entry := range entries {
+		if config.Playlists[entry.Playlist] == nil {
+			continue
+		}
+		if failed[entry.Playlist] == nil {
+			failed[entry.Playlist] = make(map[string]bool)
+		}
+		failed[entry.Playlist][entry.VideoID] = true
+		total++
+	}
+	if total == 0 {
+		fmt.Println("None of the problem songs are in a saved playlist anymore")
+		saveProblems(nil)
+		return
+	}
+	if !yes {
+		fmt.Printf("This removes %d songs from %d playlists.\n", total, len(failed))
+		if !isTerminal(os.Stdin) {
+			fmt.Println("Run again with --yes to confirm.")
+			return
+		}
+		if !confirm("Remove them?") {
+			fmt.Println("Aborted, nothing was removed")
+			return
+		}
+	}
+
+	if failed[config.State.CurrentPlaylist] != nil && interjectionBlocksEdit(config.State.CurrentPlaylist) {
+		return
+	}
+	removed := 0
+	for name, ids := range failed {
+		removed += removeSongs(name, func(song Song) bool { return ids[song.VideoID] })
+	}
+	if err := saveConfig(); err != nil {
+		fmt.Printf("Error saving playlists: %v\n", err)
+		return
+	}
+	saveProblems(nil)
+	fmt.Printf("Removed %d problem songs from %d playlists\n", removed, len(failed))
+}
+
+// removeSongs drops the songs matching remove from a saved playlist, taking
+// playback along (see carryOverPlayback). The caller saves.
+func removeSongs(playlistName string, remove func(Song) bool) int {
+	playlist := config.Playlists[playlistName]
+	var kept []Song
+	oldToNew := make([]int, len(playlist.Songs))
+	for i, song := range playlist.Songs {
+		oldToNew[i] = -1
+		if !remove(song) {
+			oldToNew[i] = len(kept)
+			kept = append(kept, song)
+		}
+	}
+	removed := len(playlist.Songs) - len(kept)
+	playlist.Songs = kept
+	if removed > 0 {
+		carryOverPlayback(playlistName, oldToNew)
+	}
+	return removed
+}
+
+// pruneHistory drops entries older than history_days, then all but the
+// newest history_max
+func pruneHistory(entries []HistoryEntry) []HistoryEntry {
+	if days := config.Settings.HistoryDays; days > 0 {
+		cutoff := time.Now().AddDate(0, 0, -days)
+		kept := entries[:0]
+		for _, entry := range entries {
+			if entry.PlayedAt.After(cutoff) {
+				kept = append(kept, entry)
+			}
+		}
+		entries = kept
+	}
+	if max := config.Settings.HistoryMax; max > 0 && len(entries) > max {
+		entries = entries[len(entries)-max:]
+	}
+	return entries
+}
+
+func handleHistory(args []string) {
+	if len(args) > 0 && args[0] == "prune" {
+		entries := loadHistory()
+		pruned := pruneHistory(entries)
+		removed := len(entries) - len(pruned)
+		if removed == 0 {
+			fmt.Println("Nothing to prune")
+			return
+		}
+		if err := saveHistory(pruned); err != nil {
+			fmt.Printf("Error saving history: %v\n", err)
+			return
+		}
+		fmt.Printf("Removed %d old history entries, %d left\n", removed, len(pruned))
+		return
+	}
+
+	args, sinceValue, hasSince := extractFlagValue(args, "--since")
+	args, playlistName, hasPlaylist := extractFlagValue(args, "--playlist")
+
+	// --since shows the whole window unless a count is given too
+	count := 20
+	if hasSince {
+		count = 0
+	}
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || len(args) > 1 {
+			fmt.Println("Usage: mfp history [count] [--since <2h|2024-01-01|08:00>] [--playlist <name>]")
+			fmt.Println("       mfp history prune")
+			return
+		}
+		count = n
+	}
+	var since time.Time
+	if hasSince {
+		var err error
+		if since, err = parseSince(sinceValue, time.Now()); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+	}
+
+	entries := loadHistory()
+	if len(entries) == 0 {
+		fmt.Println("No listening history yet")
+		return
+	}
+	if hasSince || hasPlaylist {
+		var matching []HistoryEntry
+		for _, entry := range entries {
+			if hasSince && entry.PlayedAt.Before(since) {
+				continue
+			}
+			if hasPlaylist && entry.Playlist != playlistName {
+				continue
+			}
+			matching = append(matching, entry)
+		}
+		if len(matching) == 0 {
+			fmt.Println("Nothing played that matches")
+			return
+		}
+		entries = matching
+	}
+	if count == 0 || count > len(entries) {
+		count = len(entries)
+	}
+
+	fmt.Println("Recently played:")
+	for i := len(entries) - 1; i >= len(entries)-count; i-- {
+		entry := entries[i]
+		song := Song{Title: entry.Title, Artist: entry.Artist}
+		fmt.Printf("  %s  %s [%s]\n", entry.PlayedAt.Format("2006-01-02 15:04"), song.DisplayTitle(), entry.Playlist)
+	}
+}
+
+// parseSince turns a --since value into the earliest time to include: an
+// age ("90m", "2h", "3d"), a date ("2024-01-01", "2024-01-01 18:00") or a
+// time today ("08:00")
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && strings.HasSuffix(value, "d") && days >= 0 {
+		return now.AddDate(0, 0, -days), nil
+	}
+	if age, err := time.ParseDuration(value); err == nil && age >= 0 {
+		return now.Add(-age), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.ParseInLocation("15:04", value, time.Local); err == nil {
+		return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q, use e.g. 2h, 3d, 2024-01-01 or 08:00", value)
+}
+
+// downloadKey names a song's record in downloads.json. Each playlist
+// downloads into its own directory, so records are kept per playlist.
+func downloadKey(playlistName, videoID string) string {
+	return playlistName + "/" + videoID
+}
+
+func downloadsFile() string {
+	return filepath.Join(config.DataDir, "downloads.json")
+}
+
+func loadDownloads() map[string]*DownloadRecord {
+	records := make(map[string]*DownloadRecord)
+	if data, err := ioutil.ReadFile(downloadsFile()); err == nil {
+		json.Unmarshal(data, &records)
+	}
+	return records
+}
+
+func saveDownloads(records map[string]*DownloadRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(downloadsFile(), data, 0644)
+}
+
+var rateLimitPattern = regexp.MustCompile(`(?i)^\d+(\.\d+)?[KMG]?$`)
+
+// handleDownload saves a playlist's audio to ~/.mfp/downloads/<playlist>,
+// running up to --concurrency yt-dlp processes at once. Songs already
+// downloaded are skipped, and yt-dlp continues partial files, so running it
+// again after an interruption carries on where it stopped.
+func handleDownload(args []string) {
+	args, concurrencyValue, hasConcurrency := extractFlagValue(args, "--concurrency")
+	args, rateLimit, _ := extractFlagValue(args, "--rate-limit")
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp download <playlist_name> [--concurrency N] [--rate-limit 1M]")
+		return
+	}
+	if config.ReadOnly {
+		fmt.Println("The data directory is read-only, nowhere to download to")
+		return
+	}
+
+	name := args[0]
+	playlist, exists := config.Playlists[name]
+	if !exists {
+		fmt.Printf("Playlist '%s' not found\n", name)
+		return
+	}
+	// The name becomes the download directory
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		fmt.Printf("Error: '%s' can't be used as a directory name, rename the playlist to download it\n", name)
+		return
+	}
+
+	concurrency := 2
+	if hasConcurrency {
+		n, err := strconv.Atoi(concurrencyValue)
+		if err != nil || n < 1 || n > 8 {
+			fmt.Println("Error: --concurrency must be between 1 and 8")
+			return
+		}
+		concurrency = n
+	}
+	if rateLimit != "" && !rateLimitPattern.MatchString(rateLimit) {
+		fmt.Println("Error: --rate-limit must be a rate like 500K or 1.5M (bytes per second, per download)")
+		return
+	}
+
+	dir := filepath.Join(config.DataDir, "downloads", name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Printf("Error creating %s: %v\n", dir, err)
+		return
+	}
+
+	records := loadDownloads()
+	var pending []Song
+	done := 0
+	for _, song := range playlist.Songs {
+		if _, blacklisted := config.Blacklist[song.VideoID]; blacklisted {
+			continue
+		}
+		key := downloadKey(name, song.VideoID)
+		if file := downloadedFile(dir, song.VideoID, records[key]); file != "" {
+			records[key] = &DownloadRecord{Status: "done", File: file, UpdatedAt: time.Now()}
+			touchCacheFile(file)
+			done++
+			continue
+		}
+		pending = append(pending, song)
+	}
+	if len(pending) == 0 {
+		fmt.Printf("All %d songs of '%s' are already downloaded to %s\n", done, name, dir)
+		saveDownloads(records)
+		return
+	}
+	if done > 0 {
+		fmt.Printf("%d songs already downloaded, fetching the other %d\n", done, len(pending))
+	} else {
+		fmt.Printf("Downloading %d songs to %s\n", len(pending), dir)
+	}
+
+	// A fixed pool of workers bounds how many yt-dlp run at once; records
+	// are saved after every song so an interruption loses nothing finished
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan Song)
+	finished, failed := 0, 0
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for song := range jobs {
+				file, err := downloadSong(song, dir, rateLimit)
+
+				mu.Lock()
+				finished++
+				if err != nil {
+					failed++
+					records[downloadKey(name, song.VideoID)] = &DownloadRecord{Status: "failed", Error: err.Error(), UpdatedAt: time.Now()}
+					recordProblem(song, name, err)
+					fmt.Printf("[%d/%d] Failed: %s (%v)\n", finished, len(pending), song.DisplayTitle(), err)
 				} else {
-					// In normal mode, playlistPos is the direct song index
-					if playlistPos < len(playlist.Songs) {
-						config.State.CurrentSongIndex = playlistPos
-					}
+					records[downloadKey(name, song.VideoID)] = &DownloadRecord{Status: "done", File: file, UpdatedAt: time.Now()}
+					fmt.Printf("[%d/%d] Downloaded: %s\n", finished, len(pending), song.DisplayTitle())
 				}
-
-				// Save the updated state
-				if err := saveConfig(); err == nil {
-					// Optional: Print song change notification
-					if playlistPos < len(playlist.Songs) {
-						currentIndex := getCurrentSongIndex()
-						if currentIndex < len(playlist.Songs) {
-							fmt.Printf("Now playing: %s\n", playlist.Songs[currentIndex].Title)
-						}
-					}
+				if err := saveDownloads(records); err != nil {
+					fmt.Printf("Error saving download status: %v\n", err)
 				}
+				mu.Unlock()
 			}
+		}()
+	}
+	for _, song := range pending {
+		jobs <- song
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failed > 0 {
+		fmt.Printf("Downloaded %d songs, %d failed; run the command again to retry them\n", len(pending)-failed, failed)
+	} else {
+		fmt.Printf("Downloaded %d songs\n", len(pending))
+	}
+
+	if limit := config.Settings.CacheMaxMB; limit > 0 {
+		if count, freed := pruneCache(int64(limit) << 20); count > 0 {
+			fmt.Printf("Cache over cache_max_mb (%d MB): removed the %d least recently used files, %s\n", limit, count, formatBytes(freed))
 		}
+	}
+}
 
-		time.Sleep(1 * time.Second) // Check every second for better responsiveness
+// cacheFile is a download or thumbnail counted against cache_max_mb
+type cacheFile struct {
+	path    string
+	size    int64
+	touched time.Time
+}
+
+// cacheFiles lists the downloads and thumbnails; partial downloads and
+// thumbnails being written are left out
+func cacheFiles() []cacheFile {
+	var files []cacheFile
+	for _, dir := range []string{"downloads", "thumbs"} {
+		filepath.Walk(filepath.Join(config.DataDir, dir), func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			name := info.Name()
+			if strings.HasSuffix(name, ".part") || strings.HasSuffix(name, ".ytdl") || strings.HasPrefix(name, ".") {
+				return nil
+			}
+			files = append(files, cacheFile{path: path, size: info.Size(), touched: info.ModTime()})
+			return nil
+		})
+	}
+	return files
+}
+
+// touchCacheFile marks a cached file as just used; the modification time is
+// what the least-recently-used pruning goes by
+func touchCacheFile(path string) {
+	if config.ReadOnly {
+		return
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// pruneCache removes the least recently used downloads and thumbnails until
+// they fit in limit bytes, and returns how many files and bytes it removed
+func pruneCache(limit int64) (int, int64) {
+	files := cacheFiles()
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+	if total <= limit || config.ReadOnly {
+		return 0, 0
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].touched.Before(files[j].touched) })
+
+	removed := make(map[string]bool)
+	var freed int64
+	for _, file := range files {
+		if total-freed <= limit {
+			break
+		}
+		if err := os.Remove(file.path); err != nil {
+			continue
+		}
+		removed[file.path] = true
+		freed += file.size
+	}
+
+	// Forget removed downloads so 'mfp download' fetches them again
+	records := loadDownloads()
+	changed := false
+	for id, record := range records {
+		if record.File != "" && removed[record.File] {
+			delete(records, id)
+			changed = true
+		}
+	}
+	if changed {
+		saveDownloads(records)
+	}
+	return len(removed), freed
+}
+
+// formatBytes prints a size in the largest fitting unit, e.g. "12.3 MB"
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func handleCache(args []string) {
+	if len(args) == 0 {
+		var downloads, thumbs int64
+		count := 0
+		thumbsDir := filepath.Join(config.DataDir, "thumbs")
+		for _, file := range cacheFiles() {
+			if strings.HasPrefix(file.path, thumbsDir) {
+				thumbs += file.size
+			} else {
+				downloads += file.size
+			}
+			count++
+		}
+		fmt.Printf("Cache: %s in %d files (downloads %s, thumbnails %s)\n", formatBytes(downloads+thumbs), count, formatBytes(downloads), formatBytes(thumbs))
+		if limit := config.Settings.CacheMaxMB; limit > 0 {
+			fmt.Printf("Limit: %d MB (cache_max_mb)\n", limit)
+		} else {
+			fmt.Println("Limit: none, set one with 'mfp config set cache_max_mb <MB>'")
+		}
+		return
+	}
+
+	if args[0] != "prune" || len(args) > 2 {
+		fmt.Println("Usage: mfp cache [prune [max_mb]]")
+		return
+	}
+	limit := config.Settings.CacheMaxMB
+	if len(args) == 2 {
+		mb, err := strconv.Atoi(args[1])
+		if err != nil || mb < 0 {
+			fmt.Println("Error: max_mb must be a number of megabytes")
+			return
+		}
+		limit = mb
+	} else if limit == 0 {
+		fmt.Println("No cache_max_mb set; give a size ('mfp cache prune 500') or set one with 'mfp config set cache_max_mb 500'")
+		return
+	}
+	if config.ReadOnly {
+		fmt.Println("The data directory is read-only, nothing can be removed")
+		return
+	}
+
+	count, freed := pruneCache(int64(limit) << 20)
+	if count == 0 {
+		fmt.Printf("The cache is already under %d MB\n", limit)
+		return
+	}
+	fmt.Printf("Removed %d least recently used files, reclaimed %s\n", count, formatBytes(freed))
+}
+
+// downloadedFile returns the completed file for videoID in dir, going by the
+// download record first and otherwise by a finished <id>.<ext> file
+func downloadedFile(dir, videoID string, record *DownloadRecord) string {
+	if record != nil && record.Status == "done" && filepath.Dir(record.File) == dir {
+		if _, err := os.Stat(record.File); err == nil {
+			return record.File
+		}
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, videoID+".*"))
+	for _, match := range matches {
+		if !strings.HasSuffix(match, ".part") && !strings.HasSuffix(match, ".ytdl") {
+			return match
+		}
+	}
+	return ""
+}
+
+// downloadSong fetches one song's audio with yt-dlp and returns the file
+// it was saved to
+func downloadSong(song Song, dir, rateLimit string) (string, error) {
+	// --no-mtime: the cache is pruned oldest first, and yt-dlp would
+	// otherwise date the file by the upload
+	args := []string{"--no-playlist", "-f", audioFormat(), "--continue", "--no-simulate", "--no-mtime",
+		"--print", "after_move:filepath", "-o", filepath.Join(dir, "%(id)s.%(ext)s")}
+	if rateLimit != "" {
+		args = append(args, "--limit-rate", rateLimit)
+	}
+	args = append(args, song.URL)
+
+	waitForYtdlp()
+	output, err := exec.Command("yt-dlp", ytdlpArgs(args...)...).Output()
+	if err != nil {
+		return "", ytdlpError(err)
+	}
+	file := strings.TrimSpace(string(output))
+	if file == "" {
+		file = downloadedFile(dir, song.VideoID, nil)
+	}
+	touchCacheFile(file)
+	return file, nil
+}
+
+// handleSaveSession saves the songs played since the last 'mfp play', in the
+// order they were first heard, as a new playlist
+func handleSaveSession(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp save-session <new_playlist_name>")
+		return
+	}
+	name := args[0]
+	if _, exists := config.Playlists[name]; exists {
+		fmt.Printf("Playlist '%s' already exists\n", name)
+		return
+	}
+	if config.State.PlayStarted.IsZero() {
+		fmt.Println("Nothing has been played yet")
+		return
+	}
+
+	// Durations aren't in the history, take them from any playlist that has the song
+	durations := make(map[string]string)
+	for _, playlist := range config.Playlists {
+		for _, song := range playlist.Songs {
+			durations[song.VideoID] = song.Duration
+		}
+	}
+	if config.State.Session != nil {
+		for _, song := range config.State.Session.Songs {
+			durations[song.VideoID] = song.Duration
+		}
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	var songs []Song
+	for _, entry := range loadHistory() {
+		if entry.PlayedAt.Before(config.State.PlayStarted) || seen[entry.VideoID] {
+			continue
+		}
+		seen[entry.VideoID] = true
+		duration := durations[entry.VideoID]
+		if duration == "" {
+			duration = "Unknown"
+		}
+		songs = append(songs, Song{
+			Title:    entry.Title,
+			Artist:   entry.Artist,
+			VideoID:  entry.VideoID,
+			Duration: duration,
+			URL:      fmt.Sprintf("https://www.youtube.com/watch?v=%s", entry.VideoID),
+			AddedAt:  now,
+		})
+	}
+	if len(songs) == 0 {
+		fmt.Println("No songs have been played since the last 'mfp play'")
+		return
 	}
-}
 
-// Improved getMpvPlaylistPosition with better error handling
-func getMpvPlaylistPosition() int {
-	if _, err := os.Stat(config.SocketFile); os.IsNotExist(err) {
-		return -1
+	config.Playlists[name] = &Playlist{
+		Name:         name,
+		Songs:        songs,
+		LastUpdated:  now.Format("2006-01-02 15:04:05"),
+		ListPosition: nextListPosition(),
+	}
+	if err := saveConfig(); err != nil {
+		fmt.Printf("Error saving playlist: %v\n", err)
+		return
 	}
+	fmt.Printf("Saved %d songs played since %s as playlist '%s'\n", len(songs), config.State.PlayStarted.Format("15:04"), name)
+}
 
-	// Use timeout to prevent hanging
-	cmd := exec.Command("timeout", "2s", "sh", "-c",
-		fmt.Sprintf(`echo '{"command": ["get_property", "playlist-pos"]}' | socat - %s 2>/dev/null`, config.SocketFile))
+// spotifyTrack is one track of a Spotify export, to be found on YouTube
+type spotifyTrack struct {
+	Track  string `json:"track"`
+	Artist string `json:"artist"`
+}
 
-	output, err := cmd.Output()
-	if err != nil {
-		return -1
+func (t spotifyTrack) String() string {
+	if t.Artist == "" {
+		return t.Track
 	}
+	return t.Track + " – " + t.Artist
+}
 
-	var response map[string]interface{}
-	if err := json.Unmarshal(output, &response); err != nil {
-		return -1
-	}
+// spotifyImport is the progress of an 'mfp import-spotify', saved after every
+// search so an interrupted import picks up where it stopped
+type spotifyImport struct {
+	File       string         `json:"file"`
+	Done       int            `json:"done"`
+	Songs      []Song         `json:"songs"`
+	Unresolved []spotifyTrack `json:"unresolved,omitempty"`
+}
 
-	if data, ok := response["data"].(float64); ok {
-		return int(data)
-	}
+// importSearchDelay spaces out the searches of an import on top of ytdlp_rate
+const importSearchDelay = time.Second
 
-	return -1
+func spotifyImportFile(name string) string {
+	return filepath.Join(config.DataDir, "import-"+name+".json")
 }
 
-// Improved getMpvPosition with better error handling
-func getMpvPosition() int {
-	if _, err := os.Stat(config.SocketFile); os.IsNotExist(err) {
-		return -1
+func handleImportSpotify(args []string) {
+	args, restart := extractFlag(args, "--restart")
+	if len(args) != 2 {
+		fmt.Println("Usage: mfp import-spotify <playlist_name> <export.csv|export.json> [--restart]")
+		return
+	}
+	name, file := args[0], args[1]
+	if _, exists := config.Playlists[name]; exists {
+		fmt.Printf("Playlist '%s' already exists\n", name)
+		return
+	}
+	// The name goes into the progress file's name
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		fmt.Printf("Error: '%s' can't be used in a file name, pick another playlist name\n", name)
+		return
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
 	}
 
-	// Use timeout to prevent hanging
-	cmd := exec.Command("timeout", "2s", "sh", "-c",
-		fmt.Sprintf(`echo '{"command": ["get_property", "time-pos"]}' | socat - %s 2>/dev/null`, config.SocketFile))
-
-	output, err := cmd.Output()
+	tracks, err := readSpotifyExport(file)
 	if err != nil {
-		return -1
+		fmt.Printf("Error reading %s: %v\n", file, err)
+		return
+	}
+	if len(tracks) == 0 {
+		fmt.Println("Error: no tracks found, the export needs track and artist columns")
+		return
 	}
 
-	var response map[string]interface{}
-	if err := json.Unmarshal(output, &response); err != nil {
-		return -1
+	progressFile := spotifyImportFile(name)
+	progress := spotifyImport{File: file}
+	if data, err := ioutil.ReadFile(progressFile); err == nil && !restart {
+		var saved spotifyImport
+		if json.Unmarshal(data, &saved) == nil {
+			if saved.File != file {
+				fmt.Printf("An unfinished import of %s into '%s' exists; use --restart to start over\n", saved.File, name)
+				return
+			}
+			progress = saved
+			fmt.Printf("Resuming the import at track %d/%d\n", progress.Done+1, len(tracks))
+		}
 	}
 
-	if data, ok := response["data"].(float64); ok {
-		return int(data)
+	seen := make(map[string]bool)
+	for _, song := range progress.Songs {
+		seen[song.VideoID] = true
+	}
+	start := progress.Done
+	for i := start; i < len(tracks); i++ {
+		if i > start {
+			time.Sleep(importSearchDelay)
+		}
+		track := tracks[i]
+		fmt.Printf("[%d/%d] %s", i+1, len(tracks), track)
+		songs, err := searchSongs(fmt.Sprintf("\"%s %s\"", track.Track, track.Artist), 1)
+		if err != nil && !errors.Is(err, errNoResults) {
+			// Network trouble or rate limiting: stop here so the track is
+			// searched again next time rather than given up on
+			fmt.Println()
+			fmt.Printf("Error: %v\n", err)
+			fmt.Printf("Stopped at track %d/%d; run the same command again to continue from there\n", i+1, len(tracks))
+			return
+		}
+		if err != nil {
+			fmt.Println(colorize("2", " - not found"))
+			progress.Unresolved = append(progress.Unresolved, track)
+		} else {
+			song := songs[0]
+			fmt.Println(colorize("2", " → "+song.DisplayTitle()))
+			if !seen[song.VideoID] {
+				seen[song.VideoID] = true
+				song.AddedAt = time.Now()
+				progress.Songs = append(progress.Songs, song)
+			}
+		}
+		progress.Done = i + 1
+		if !config.ReadOnly {
+			if data, err := json.MarshalIndent(progress, "", "  "); err == nil {
+				ioutil.WriteFile(progressFile, data, 0644)
+			}
+		}
 	}
 
-	return -1
-}
+	if len(progress.Songs) == 0 {
+		fmt.Println("None of the tracks were found on YouTube, no playlist created")
+		os.Remove(progressFile)
+		return
+	}
+	now := time.Now()
+	config.Playlists[name] = &Playlist{
+		Name:         name,
+		Songs:        progress.Songs,
+		LastUpdated:  now.Format("2006-01-02 15:04:05"),
+		ListPosition: nextListPosition(),
+	}
+	if err := saveConfig(); err != nil {
+		fmt.Printf("Error saving playlist: %v\n", err)
+		return
+	}
+	os.Remove(progressFile)
 
-// Improved the getCurrentSongIndex function for better safety
-func getCurrentSongIndex() int {
-	if config.State.CurrentPlaylist == "" {
-		return 0
+	fmt.Printf("Imported %d of %d tracks into playlist '%s'\n", len(progress.Songs), len(tracks), name)
+	if len(progress.Unresolved) > 0 {
+		fmt.Printf("Not found on YouTube (%d):\n", len(progress.Unresolved))
+		for _, track := range progress.Unresolved {
+			fmt.Printf("  - %s\n", track)
+		}
 	}
+}
 
-	playlist := config.Playlists[config.State.CurrentPlaylist]
-	if playlist == nil {
-		return 0
+// readSpotifyExport reads the tracks of an Exportify-style CSV or of a
+// Spotify data export JSON (YourLibrary.json, Playlist1.json)
+func readSpotifyExport(file string) ([]spotifyTrack, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		var tracks []spotifyTrack
+		collectSpotifyTracks(value, &tracks)
+		return tracks, nil
 	}
 
-	if config.State.IsShuffle {
-		if config.State.ShuffleIndex >= 0 && config.State.ShuffleIndex < len(config.State.ShuffleOrder) {
-			shuffledIndex := config.State.ShuffleOrder[config.State.ShuffleIndex]
-			if shuffledIndex >= 0 && shuffledIndex < len(playlist.Songs) {
-				return shuffledIndex
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), "\ufeff"))).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, nil
+	}
+	trackCol, artistCol := -1, -1
+	for i, column := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "track name", "track", "name", "title", "song":
+			if trackCol < 0 {
+				trackCol = i
+			}
+		case "artist name(s)", "artist name", "artist", "artists":
+			if artistCol < 0 {
+				artistCol = i
 			}
 		}
-		return 0
+	}
+	if trackCol < 0 {
+		return nil, fmt.Errorf("no track name column in %q", strings.Join(records[0], ","))
 	}
 
-	if config.State.CurrentSongIndex >= 0 && config.State.CurrentSongIndex < len(playlist.Songs) {
-		return config.State.CurrentSongIndex
+	var tracks []spotifyTrack
+	for _, record := range records[1:] {
+		if trackCol >= len(record) || strings.TrimSpace(record[trackCol]) == "" {
+			continue
+		}
+		track := spotifyTrack{Track: strings.TrimSpace(record[trackCol])}
+		if artistCol >= 0 && artistCol < len(record) {
+			// Exportify lists every artist; the first finds the song
+			track.Artist = strings.TrimSpace(strings.Split(record[artistCol], ",")[0])
+		}
+		tracks = append(tracks, track)
 	}
+	return tracks, nil
+}
 
-	return 0
+// collectSpotifyTracks finds every object with a track name, wherever the
+// export nests it
+func collectSpotifyTracks(value interface{}, tracks *[]spotifyTrack) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			collectSpotifyTracks(item, tracks)
+		}
+	case map[string]interface{}:
+		for _, key := range []string{"trackName", "track"} {
+			if name, ok := v[key].(string); ok && name != "" {
+				artist, _ := v["artistName"].(string)
+				if artist == "" {
+					artist, _ = v["artist"].(string)
+				}
+				*tracks = append(*tracks, spotifyTrack{Track: name, Artist: artist})
+				return
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collectSpotifyTracks(v[key], tracks)
+		}
+	}
 }
 
-// Improve startMpv function
-func startMpv(playlistFile string) error {
-	// Clean up old socket
-	os.Remove(config.SocketFile)
+// mpvChapter is one entry of mpv's chapter-list property
+type mpvChapter struct {
+	Title string  `json:"title"`
+	Time  float64 `json:"time"`
+}
 
-	startIndex := config.State.CurrentSongIndex
-	if config.State.IsShuffle {
-		startIndex = config.State.ShuffleIndex
+// getMpvChapters returns the current file's chapters, or nil if it has none
+// or mpv isn't answering
+func getMpvChapters() []mpvChapter {
+	data, err := getMpvProperty("chapter-list")
+	if err != nil {
+		return nil
 	}
-
-	args := []string{
-		"--no-video",
-		"--no-terminal", // Run in background
-		"--input-ipc-server=" + config.SocketFile,
-		"--volume=" + strconv.Itoa(config.State.Volume),
-		"--playlist=" + playlistFile,
-		"--playlist-start=" + strconv.Itoa(startIndex),
-		"--quiet", // Reduce output noise
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil
 	}
+	var chapters []mpvChapter
+	if json.Unmarshal(raw, &chapters) != nil {
+		return nil
+	}
+	return chapters
+}
 
-	if config.State.IsLoop {
-		args = append(args, "--loop-playlist=inf")
+func handleChapters() {
+	if !config.State.IsPlaying {
+		fmt.Println("No music is currently playing")
+		return
 	}
 
-	currentCmd = exec.Command("mpv", args...)
+	chapters := getMpvChapters()
+	if len(chapters) == 0 {
+		fmt.Println("The current song has no chapters")
+		return
+	}
 
-	// Don't pipe stdout/stderr to avoid blocking
-	currentCmd.Stdout = nil
-	currentCmd.Stderr = nil
+	current := -1
+	if value, ok := getMpvFloatProperty("chapter"); ok {
+		current = int(value)
+	}
+	fmt.Println("Chapters:")
+	for i, chapter := range chapters {
+		marker := " "
+		if i == current {
+			marker = "▶"
+		}
+		title := chapter.Title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		fmt.Printf("%s %d. %s (%s)\n", marker, i+1, title, formatDuration(int(chapter.Time)))
+	}
+}
 
-	if err := currentCmd.Start(); err != nil {
-		return fmt.Errorf("failed to start mpv: %v", err)
+func handleChapter(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: mfp chapter <number>")
+		return
 	}
 
-	return nil
-}
+	if !config.State.IsPlaying {
+		fmt.Println("No music is currently playing")
+		return
+	}
 
-// Improve handleCurrent function
-func handleCurrent() {
-	if config.State.CurrentPlaylist == "" {
-		fmt.Println("No playlist is currently loaded")
+	chapters := getMpvChapters()
+	if len(chapters) == 0 {
+		fmt.Println("The current song has no chapters")
 		return
 	}
 
-	playlist := config.Playlists[config.State.CurrentPlaylist]
-	if playlist == nil {
-		fmt.Println("Current playlist not found")
+	number, err := strconv.Atoi(args[0])
+	if err != nil || number < 1 || number > len(chapters) {
+		fmt.Printf("Invalid chapter number. Please use 1-%d\n", len(chapters))
 		return
 	}
 
-	currentIndex := getCurrentSongIndex()
-	if currentIndex >= len(playlist.Songs) || currentIndex < 0 {
-		fmt.Println("No current song")
+	sendMpvCommandArgs("set_property", "chapter", number-1)
+	fmt.Printf("Jumped to chapter %d: %s\n", number, chapters[number-1].Title)
+}
+
+func handleDebug(args []string) {
+	if len(args) == 0 || args[0] != "m3u" || len(args) < 2 || len(args) > 3 {
+		fmt.Println("Usage: mfp debug m3u <playlist> [file.m3u]")
+		return
+	}
+	name := args[1]
+	if _, exists := config.Playlists[name]; !exists && name != config.State.CurrentPlaylist {
+		fmt.Printf("Playlist '%s' not found\n", name)
 		return
 	}
 
-	song := playlist.Songs[currentIndex]
-	status := "Paused"
-	if config.State.IsPlaying {
-		status = "Playing"
+	// The playing playlist is shown as mpv got it; any other as a fresh
+	// play would build it, on a copy of the state
+	savedState := config.State
+	preview := *config.State
+	config.State = &preview
+	defer func() { config.State = savedState }()
+	if name != savedState.CurrentPlaylist {
+		config.State.CurrentPlaylist = name
+		config.State.Session = nil
+		config.State.CurrentSongIndex = 0
+		if config.State.IsShuffle {
+			initShuffleOrder()
+		}
 	}
+	playlist := currentPlaylist()
 
-	fmt.Printf("Current Song (%s):\n", status)
-	fmt.Printf("  Title: %s\n", song.Title)
-	fmt.Printf("  Duration: %s\n", song.Duration)
-	fmt.Printf("  Position: %d/%d in playlist\n", currentIndex+1, len(playlist.Songs))
-	fmt.Printf("  Playlist: %s\n", config.State.CurrentPlaylist)
+	path := ""
+	if len(args) == 3 {
+		path = args[2]
+	} else {
+		file, err := ioutil.TempFile("", "mfp-debug-*.m3u")
+		if err != nil {
+			fmt.Printf("Error creating temp file: %v\n", err)
+			return
+		}
+		file.Close()
+		path = file.Name()
+		defer os.Remove(path)
+	}
+	if err := createPlaylistFile(playlist, path); err != nil {
+		fmt.Printf("Error writing M3U: %v\n", err)
+		return
+	}
 
-	// Try to get current position from mpv
-	if config.State.IsPlaying {
-		if pos := getMpvPosition(); pos >= 0 {
-			fmt.Printf("  Time: %s\n", formatDuration(pos))
+	start := config.State.CurrentSongIndex
+	if config.State.IsShuffle {
+		start = config.State.ShuffleIndex
+	}
+	if len(args) == 3 {
+		fmt.Printf("Wrote %d entries to %s\n", len(playOrder(playlist)), path)
+	} else {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Printf("Error reading M3U: %v\n", err)
+			return
 		}
+		fmt.Print(string(data))
+	}
+	fmt.Printf("# shuffle: %s, mpv starts at entry %d (--playlist-start=%d)\n", boolToOnOff(config.State.IsShuffle), start+1, start)
+	if name != savedState.CurrentPlaylist && config.State.IsShuffle {
+		fmt.Println("# a new shuffle order is generated on each play, so the real order will differ")
 	}
 }
 
 func createPlaylistFile(playlist *Playlist, filename string) error {
+	var songs []Song
+	for _, index := range playOrder(playlist) {
+		songs = append(songs, playlist.Songs[index])
+	}
+	return writeM3UWith(songs, filename, m3uEntryTemplate())
+}
+
+// defaultM3UEntry is how a song is written to an M3U file; "\n" in a
+// template separates lines
+const defaultM3UEntry = `#EXTINF:-1,{display_title}\n{url}`
+
+// m3uEntryTemplate is the m3u_entry setting, used for the playlist file
+// mpv gets; exported files always use the default
+func m3uEntryTemplate() string {
+	if config.Settings.M3UEntry == "" {
+		return defaultM3UEntry
+	}
+	return config.Settings.M3UEntry
+}
+
+// renderM3UEntry fills in an entry template for song. The result must be
+// the song's URL on a line of its own, with only #-directives around it;
+// anything else would make mpv play something other than the song.
+func renderM3UEntry(template string, song Song) (string, error) {
+	seconds, ok := durationSeconds(song.Duration)
+	if !ok {
+		seconds = -1
+	}
+	clean := strings.NewReplacer("\r", " ", "\n", " ")
+	entry := strings.NewReplacer(
+		`\n`, "\n",
+		"{title}", clean.Replace(song.Title),
+		"{artist}", clean.Replace(song.Artist),
+		"{display_title}", clean.Replace(song.DisplayTitle()),
+		"{video_id}", song.VideoID,
+		"{duration}", strconv.Itoa(seconds),
+		"{url}", song.URL,
+	).Replace(template)
+
+	urls := 0
+	for _, line := range strings.Split(entry, "\n") {
+		switch {
+		case line == song.URL:
+			urls++
+		case !strings.HasPrefix(line, "#"):
+			return "", fmt.Errorf("m3u_entry lines must start with # or be just {url}, got %q", line)
+		}
+	}
+	if urls != 1 {
+		return "", fmt.Errorf("m3u_entry must have {url} on a line of its own, once")
+	}
+	return entry, nil
+}
+
+func writeM3U(songs []Song, filename string) error {
+	return writeM3UWith(songs, filename, defaultM3UEntry)
+}
+
+func writeM3UWith(songs []Song, filename, template string) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
@@ -1178,24 +9260,67 @@ func createPlaylistFile(playlist *Playlist, filename string) error {
 
 	file.WriteString("#EXTM3U\n")
 
-	var songsToWrite []Song
+	for _, song := range songs {
+		// A template that breaks on some song (say, one with an odd title)
+		// falls back to the default entry for it
+		entry, err := renderM3UEntry(template, song)
+		if err != nil {
+			entry, _ = renderM3UEntry(defaultM3UEntry, song)
+		}
+		file.WriteString(entry + "\n")
+	}
+
+	return nil
+}
+
+// currentPlaylist returns the songs being played: the transient session if
+// one is active, otherwise the saved playlist named by CurrentPlaylist
+func currentPlaylist() *Playlist {
+	if config.State.Session != nil {
+		return config.State.Session
+	}
+	return config.Playlists[config.State.CurrentPlaylist]
+}
+
+// parseRange converts 1-based inclusive --from/--to values into [start, end)
+// slice bounds for a playlist of the given length. Empty values mean the
+// first or last song.
+func parseRange(fromValue, toValue string, length int) (int, int, error) {
+	from, to := 1, length
+	var err error
+	if fromValue != "" {
+		if from, err = strconv.Atoi(fromValue); err != nil {
+			return 0, 0, fmt.Errorf("invalid --from value %q", fromValue)
+		}
+	}
+	if toValue != "" {
+		if to, err = strconv.Atoi(toValue); err != nil {
+			return 0, 0, fmt.Errorf("invalid --to value %q", toValue)
+		}
+	}
+	if from < 1 || to > length || from > to {
+		return 0, 0, fmt.Errorf("invalid range %d-%d, songs are numbered 1-%d", from, to, length)
+	}
+	return from - 1, to, nil
+}
+
+// playOrder returns the indices of playlist's songs in the order mpv plays
+// them: the shuffle order when shuffle is on, otherwise the stored order
+func playOrder(playlist *Playlist) []int {
+	var order []int
 	if config.State.IsShuffle {
-		// Write songs in shuffle order
 		for _, index := range config.State.ShuffleOrder {
-			if index < len(playlist.Songs) {
-				songsToWrite = append(songsToWrite, playlist.Songs[index])
+			if index >= 0 && index < len(playlist.Songs) {
+				order = append(order, index)
 			}
 		}
-	} else {
-		songsToWrite = playlist.Songs
+		return order
 	}
 
-	for _, song := range songsToWrite {
-		file.WriteString(fmt.Sprintf("#EXTINF:-1,%s\n", song.Title))
-		file.WriteString(fmt.Sprintf("%s\n", song.URL))
+	for i := range playlist.Songs {
+		order = append(order, i)
 	}
-
-	return nil
+	return order
 }
 
 func sendMpvCommand(command string) error {
@@ -1216,9 +9341,42 @@ func sendMpvCommand(command string) error {
 		jsonCmd = fmt.Sprintf(`{"command": ["%s"]}`, parts[0])
 	}
 
-	// Send command via socat
-	cmd := exec.Command("sh", "-c", fmt.Sprintf(`echo '%s' | socat - %s`, jsonCmd, config.SocketFile))
-	return cmd.Run()
+	debugf(1, "mpv %s", jsonCmd)
+	output, err := mpvRequest(config.SocketFile, jsonCmd)
+	debugIPC(jsonCmd, output)
+	return err
+}
+
+// sendMpvCommandArgs sends a command whose arguments may contain spaces or
+// quotes (titles, paths), JSON-encoding them instead of splitting on whitespace
+func sendMpvCommandArgs(args ...interface{}) error {
+	return sendMpvCommandArgsAt(config.SocketFile, args...)
+}
+
+// sendMpvCommandArgsAt sends a command to the mpv listening on socket
+func sendMpvCommandArgsAt(socket string, args ...interface{}) error {
+	if _, err := os.Stat(socket); os.IsNotExist(err) {
+		return fmt.Errorf("mpv socket not found")
+	}
+
+	jsonCmd, err := json.Marshal(map[string]interface{}{"command": args})
+	if err != nil {
+		return err
+	}
+
+	debugf(1, "mpv %s", jsonCmd)
+	output, err := mpvRequest(socket, string(jsonCmd))
+	debugIPC(string(jsonCmd), output)
+	return err
+}
+
+// mpvRequest sends one JSON request to the mpv listening on socket through
+// socat and returns the reply; tests swap it out to stand in for mpv
+var mpvRequest = func(socket, request string) ([]byte, error) {
+	// Use timeout to prevent hanging
+	cmd := exec.Command("timeout", "2s", "socat", "-", socket)
+	cmd.Stdin = strings.NewReader(request + "\n")
+	return cmd.Output()
 }
 
 func showHelp() {
@@ -1228,32 +9386,1115 @@ func showHelp() {
 	fmt.Println("Commands:")
 	fmt.Println("  add <name> <url>        Add a YouTube playlist")
 	fmt.Println("  play [playlist]         Start/resume playback")
+	fmt.Println("  play-search <query>     Play YouTube search results without saving them")
 	fmt.Println("  stop                    Stop playback")
-	fmt.Println("  next                    Skip to next song")
-	fmt.Println("  prev/previous           Go to previous song")
+	fmt.Println("  next [count]            Skip to next song")
+	fmt.Println("  prev/previous [count]   Go to previous song")
 	fmt.Println("  current/now             Show current playing song")
+	fmt.Println("  lyrics                  Show the current song's lyrics")
+	fmt.Println("  art [--no-color]        Draw the current song's thumbnail in the terminal")
+	fmt.Println("  url [playlist n]        Print a song's YouTube URL")
+	fmt.Println("  chapters / chapter <n>  List or jump to chapters of a long mix")
 	fmt.Println("  queue [count]           Show playlist queue")
-	fmt.Println("  jump <number>           Jump to specific song")
+	fmt.Println("  schedule-view [count]   Show when upcoming songs start")
+	fmt.Println("  time-left               Show how long until the playlist ends")
+	fmt.Println("  queue-after <url>       Play a video after the current song")
+	fmt.Println("  interject <url>         Play a video now, then resume where you were")
+	fmt.Println("  pause-after-track       Pause when the current song ends (toggle)")
+	fmt.Println("  queue-playlist <name>   Play another playlist after this one")
+	fmt.Println("  jump <number|+N|-N>     Jump to specific song, or N songs on/back")
+	fmt.Println("  continue-from <number>  Play from a song to the end of the playlist")
+	fmt.Println("  random                  Jump to a random song")
 	fmt.Println("  shuffle [on|off]        Toggle/set shuffle mode")
-	fmt.Println("  loop [on|off]           Toggle/set loop mode")
+	fmt.Println("  loop [on|off|<times>]   Toggle/set loop mode")
+	fmt.Println("  eq [bass|treble <dB>]   Adjust bass and treble, or reset them")
+	fmt.Println("  quiet-hours [window]    Cap the volume during a daily time window")
+	fmt.Println("  skip-silence [on|off]   Seek past silent gaps inside songs")
+	fmt.Println("  limit [<duration>|off]  Stop after this much playing time")
+	fmt.Println("  mirror <device>|off     Also play on a second audio device (experimental)")
 	fmt.Println("  volume/vol [up|down|N]  Control volume (0-100)")
-	fmt.Println("  seek [+|-]<seconds>     Seek in current song")
+	fmt.Println("  seek [+|-]<secs>|<n>%   Seek in current song")
 	fmt.Println("  list/playlists          List all playlists")
 	fmt.Println("  songs <playlist>        List songs in playlist")
+	fmt.Println("  refresh <playlist>      Re-fetch a playlist from YouTube")
+	fmt.Println("  export <name> <file>    Export a playlist as M3U")
+	fmt.Println("  schedule <subcommand>   Refresh playlists automatically")
+	fmt.Println("  recent-added [count]    Show the newest songs across playlists")
+	fmt.Println("  download <playlist>     Download a playlist's audio for keeping")
+	fmt.Println("  cache [prune [max_mb]]  Show or trim the space downloads and thumbnails use")
+	fmt.Println("  history [count|prune]   Show recently played songs")
+	fmt.Println("  problems [--remove]     Songs that failed to play, to clean up playlists")
+	fmt.Println("  save-session <name>     Save the songs played since 'play' as a playlist")
+	fmt.Println("  import-spotify <name>   Build a playlist from a Spotify export file")
+	fmt.Println("  blacklist <subcommand>  Keep songs out of every playlist")
+	fmt.Println("  trim-playlist <name>    Keep only the first N songs")
+	fmt.Println("  dedupe <playlist>       Remove duplicate songs")
+	fmt.Println("  sort <name> --by <key>  Reorder songs by title, duration or added")
 	fmt.Println("  rename <old> <new>      Rename a playlist")
+	fmt.Println("  rename-song <title>     Rename the current song")
+	fmt.Println("  skip-always <name> <n>  Always skip a song")
+	fmt.Println("  note <name> <n> <text>  Leave a note on a song")
 	fmt.Println("  delete/remove <name>    Delete a playlist")
-	fmt.Println("  status                  Show player status")
-	fmt.Println("  help                    Show this help")
+	fmt.Println("  tag <name> <tags...>    Label and tag a playlist")
+	fmt.Println("  reorder <name> <pos>    Move a playlist in the list")
+	fmt.Println("  pin/unpin <name>        Make 'play' default to a playlist")
+	fmt.Println("  status [--oneline]      Show player status")
+	fmt.Println("  pid                     Print the PID of the process playing")
+	fmt.Println("  events                  Stream player events as JSON lines")
+	fmt.Println("  serve [options]         HTTP API for remote control")
+	fmt.Println("  doctor                  Check dependencies and data directory")
+	fmt.Println("  debug m3u <playlist>    Show the M3U playlist handed to mpv")
+	fmt.Println("  sync                    Overwrite the saved playback state with what mpv reports")
+	fmt.Println("  repair                  Fix broken references in the state files")
+	fmt.Println("  backup <file.tar.gz>    Archive playlists, state, history and settings")
+	fmt.Println("  restore <file.tar.gz>   Replace the data directory with a backup")
+	fmt.Println("  config <get|set|unset>  View or change settings")
+	fmt.Println("  help [command]          Show help for all or one command")
 	fmt.Println()
-	fmt.Println("Examples:")
-	fmt.Println("  mfp add rock https://www.youtube.com/playlist?list=PLxxx...")
-	fmt.Println("  mfp play rock")
-	fmt.Println("  mfp volume 80")
-	fmt.Println("  mfp shuffle on")
-	fmt.Println("  mfp jump 5")
+	fmt.Println("Run 'mfp help <command>' for options and examples.")
+	fmt.Println()
+	fmt.Println("Global options (-v and -vv go before the command):")
+	fmt.Println("  --profile <name>        Use a separate set of playlists, state and player")
+	fmt.Println("  -v, -vv                 Show mpv commands and player events (-vv: all IPC)")
 	fmt.Println()
 	fmt.Println("Requirements:")
 	fmt.Println("  - mpv (media player)")
 	fmt.Println("  - yt-dlp (YouTube downloader)")
 	fmt.Println("  - socat (socket communication)")
 }
+
+func handleHelp(args []string) {
+	if len(args) == 0 {
+		showHelp()
+		return
+	}
+
+	name := strings.ToLower(args[0])
+	if alias, ok := commandAliases[name]; ok {
+		name = alias
+	}
+
+	help, ok := commandHelp[name]
+	if !ok {
+		fmt.Printf("No help for unknown command: %s\n", args[0])
+		fmt.Println("Run 'mfp help' to see all commands")
+		return
+	}
+	fmt.Println(strings.TrimSpace(help))
+}
+
+// commandAliases maps alternative command names to the name used in commandHelp
+var commandAliases = map[string]string{
+	"prev":          "previous",
+	"now":           "current",
+	"vol":           "volume",
+	"playlists":     "list",
+	"move-playlist": "reorder",
+	"unpin":         "pin",
+	"chapter":       "chapters",
+	"remove":        "delete",
+	"-h":            "help",
+	"--help":        "help",
+}
+
+// commandHelp holds the detailed help text shown by 'mfp help <command>'
+var commandHelp = map[string]string{
+	"add": `
+Usage: mfp add <name> <youtube_playlist_url> [--order original|reverse|shuffle]
+
+Fetch a YouTube playlist with yt-dlp and save it under <name>.
+Adding a playlist with an existing name replaces it. If the same YouTube
+playlist is already saved under another name, mfp warns and offers to
+refresh that one instead.
+
+Options:
+  --order original    Keep YouTube's order (default)
+  --order reverse     Reverse it, e.g. oldest uploads first
+  --order shuffle     Shuffle once and save that order; it is kept on
+                      every play and refresh (new songs go at the end)
+
+Examples:
+  mfp add rock "https://www.youtube.com/playlist?list=PLxxx..."
+  mfp add podcast "https://www.youtube.com/playlist?list=PLyyy..." --order reverse
+`,
+	"export": `
+Usage: mfp export <playlist> <file.m3u> [--from N] [--to M]
+
+Write a playlist (or songs N to M of it) to an M3U file that other
+players can open.
+
+Examples:
+  mfp export rock rock.m3u
+  mfp export rock best.m3u --from 10 --to 20
+`,
+	"blacklist": `
+Usage: mfp blacklist add <video_id|url>
+       mfp blacklist remove <video_id|url>
+       mfp blacklist list
+
+Keep junk like trailers and ads out of all playlists. Blacklisted songs are
+left out when adding or refreshing a playlist, and skipped during playback
+if a playlist still has them (run 'mfp refresh' to drop them for good).
+
+The blacklist is kept in ~/.mfp/blacklist.json.
+
+Examples:
+  mfp blacklist add dQw4w9WgXcQ
+  mfp blacklist add "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+  mfp blacklist list
+`,
+	"download": `
+Usage: mfp download <playlist> [--concurrency N] [--rate-limit RATE]
+
+Download the audio of every song in a playlist to
+~/.mfp/downloads/<playlist>/<video_id>.<ext>, using the configured format.
+
+Songs that are already downloaded are skipped and partial files are
+continued, so after an interruption just run the same command again.
+Progress is kept in ~/.mfp/downloads.json.
+
+Options:
+  --concurrency N     Download up to N songs at once, 1-8 (default: 2)
+  --rate-limit RATE   Cap each download's speed, e.g. 500K or 1M
+                      (bytes per second)
+
+Examples:
+  mfp download rock
+  mfp download rock --concurrency 4 --rate-limit 1M
+`,
+	"cache": `
+Usage: mfp cache
+       mfp cache prune [max_mb]
+
+Show how much space downloads ('mfp download') and cached thumbnails take.
+
+prune removes the least recently used files until the cache fits in
+max_mb megabytes, or in cache_max_mb if no size is given, and reports the
+space reclaimed. A file counts as used when it's downloaded, when a later
+'mfp download' finds it in place, or for thumbnails when a notification
+or 'mfp art' shows it. With cache_max_mb set, 'mfp download' prunes by
+itself when it finishes. Removed songs are downloaded again by the next
+'mfp download' of their playlist.
+
+Examples:
+  mfp cache
+  mfp config set cache_max_mb 2000
+  mfp cache prune
+  mfp cache prune 500
+`,
+	"save-session": `
+Usage: mfp save-session <new_playlist_name>
+
+Save every song played since the last 'mfp play' (or play-search) as a new
+playlist, in the order they were first heard. Handy for keeping what turned
+up in a search session. The new playlist has no YouTube URL, so it can't be
+refreshed.
+
+Examples:
+  mfp play-search "city pop" --count 30
+  mfp save-session citypop
+`,
+	"import-spotify": `
+Usage: mfp import-spotify <playlist_name> <export.csv|export.json> [--restart]
+
+Build a new playlist from a Spotify export: a CSV from a tool like
+Exportify (needs "Track Name" and "Artist Name(s)" columns) or a JSON file
+from Spotify's own data export (YourLibrary.json, Playlist1.json). Every
+track is looked up with a YouTube search for its title and first artist,
+and the first result is taken.
+
+Searches are spaced out and follow ytdlp_rate, so big imports take a while.
+Progress is saved as it goes; run the same command again to resume an
+interrupted import, or add --restart to start over. Tracks YouTube has no
+match for are listed at the end. The playlist has no YouTube URL, so it
+can't be refreshed.
+
+Examples:
+  mfp import-spotify liked liked_songs.csv
+  mfp import-spotify library ~/Downloads/MyData/YourLibrary.json
+`,
+	"problems": `
+Usage: mfp problems [--remove [--yes] | --clear]
+
+List the songs that failed to play or download lately, newest first, with
+the reason when yt-dlp or mpv gave one (removed, private, geo-blocked, ...).
+Failures come from playback (mpv skips such songs by itself), from
+'mfp play --verify-start' and from 'mfp download'. A song that later plays
+fine is taken off the list.
+
+--remove deletes the listed songs from their playlists (asks for --yes
+first) and empties the list; --clear just empties it. A refresh brings back
+removed songs that are still in the YouTube playlist.
+
+Examples:
+  mfp problems
+  mfp problems --remove --yes
+`,
+	"history": `
+Usage: mfp history [count] [--since <when>] [--playlist <name>]
+       mfp history prune
+
+Show the most recently played songs, newest first (20 by default). Every
+song that plays for more than a couple of seconds is recorded in
+~/.mfp/history.json; songs skipped right away are left out.
+
+--since keeps only songs played after a point in time: an age like 90m,
+2h or 3d, a date like 2024-01-01 (optionally with a time, "2024-01-01
+18:00"), or a time today like 08:00. It shows everything in the window
+unless a count is given. --playlist keeps only songs from that playlist.
+
+The history_max and history_days settings limit how much is kept; old
+entries are dropped as new ones are recorded. 'mfp history prune' applies
+the limits right away, e.g. after lowering them.
+
+Examples:
+  mfp history 50
+  mfp history --since 08:00
+  mfp history --since 2d --playlist rock
+  mfp config set history_days 365
+  mfp history prune
+`,
+	"recent-added": `
+Usage: mfp recent-added [count]
+
+List the most recently added songs across all playlists, newest first,
+with the playlist each one is in. Shows 10 by default.
+
+Songs added before mfp recorded this use their playlist's last update time.
+
+Examples:
+  mfp recent-added
+  mfp recent-added 25
+`,
+	"schedule": `
+Usage: mfp schedule refresh <playlist> --every <interval>
+       mfp schedule list
+       mfp schedule remove <playlist>
+
+Refresh a playlist from YouTube on a schedule, handy for playlists that
+keep growing like a channel's latest uploads. New songs are added to the
+end of the playlist (and to the live queue if it's playing); songs that
+disappeared from YouTube are kept, run 'mfp refresh' to drop them.
+
+Scheduled refreshes run in the background player, so they happen while
+mfp is playing. One that came due while stopped runs when playback starts.
+
+Intervals: 30m, 6h, 1d, ... (at least 15m)
+
+Examples:
+  mfp schedule refresh uploads --every 6h
+  mfp schedule list
+  mfp schedule remove uploads
+`,
+	"sort": `
+Usage: mfp sort <playlist> --by title|duration|added [--desc]
+
+Reorder a playlist's songs for good, unlike shuffle which only changes the
+play order. Songs with an unknown length go last when sorting by duration.
+'mfp refresh' puts the songs back in YouTube's order.
+
+Options:
+  --by title      Alphabetically by title
+  --by duration   Shortest first
+  --by added      Oldest first, by when the song was added to mfp
+  --desc          Reverse the order
+
+Examples:
+  mfp sort rock --by title
+  mfp sort rock --by added --desc
+`,
+	"trim-playlist": `
+Usage: mfp trim-playlist <playlist> <count> [--tail] [--yes]
+
+Keep only the first <count> songs of a playlist and remove the rest.
+Without --yes it shows how many songs would be removed and, in a terminal,
+asks before removing them.
+
+Options:
+  --tail    Keep the last <count> songs instead
+  --yes     Remove the songs without asking
+
+Examples:
+  mfp trim-playlist rock 50
+  mfp trim-playlist rock 20 --tail --yes
+`,
+	"dedupe": `
+Usage: mfp dedupe <playlist_name> [--fuzzy] [--yes]
+
+Find songs that are in a playlist more than once and choose which copy to
+keep. Without --fuzzy only the same video counts as a duplicate.
+
+--fuzzy also catches different uploads of the same song, like the official
+video and the "Artist - Topic" audio: titles are compared without extras
+such as "(Official Video)" or "[Lyrics]", and the lengths must be within
+10 seconds of each other.
+
+In a terminal you're asked which copy to keep for each group; with --yes
+the first copy is kept. A refresh brings back removed songs that are still
+in the YouTube playlist.
+
+Examples:
+  mfp dedupe rock
+  mfp dedupe rock --fuzzy
+  mfp dedupe rock --fuzzy --yes
+`,
+	"refresh": `
+Usage: mfp refresh <playlist> [--dry-run]
+
+Fetch the playlist from YouTube again to pick up added and removed songs,
+keeping the order chosen with 'mfp add --order'. The added (+, green) and
+removed (-, red) songs are listed.
+
+Options:
+  --dry-run    Only show what would change, without saving anything
+
+Examples:
+  mfp refresh rock --dry-run
+`,
+	"play": `
+Usage: mfp play [playlist] [--from N] [--to M] [--at <song>:<time>]
+                [--random-start] [--single] [--no-resume] [--verify-start]
+                [--volume <0-100>] [--mpv-arg=<arg>...] [--dry-run] [--force]
+                [--background]
+
+Start playing a playlist. Without a playlist name, resumes the playlist
+that was loaded last at the same song and position, keeping its shuffle
+order.
+
+Playback runs in the foreground until the playlist ends or Ctrl+C stops
+it; other mfp commands control it from another terminal. With
+--background it runs in a detached process instead and the terminal is
+free right away; stop it with 'mfp stop'.
+
+While something is playing, playing it again (bare or by name) doesn't
+restart it: a paused player is unpaused, otherwise the status is shown.
+Playing a different playlist stops the current one first.
+
+Options:
+  --no-resume       Start from song 1 at 0:00 instead of the saved position
+                    (alias --restart)
+  --random-start    Start from a random song, then continue in order (the
+                    shuffle order if shuffle is on)
+  --single          Play only the current song, then stop
+  --verify-start    Check with yt-dlp that the first song can be played
+                    and start at the next one that can if not; mpv may
+                    hang on a deleted or private video
+  --from N, --to M  Play only songs N to M (inclusive) of the playlist;
+                    either bound can be left out
+  --at <song>:<time>
+                    Start at a song and a time in it, e.g. 5:1:30 for song
+                    5 at 1:30 (time as seconds, M:SS or H:MM:SS)
+  --volume <0-100>  Volume for this session only; 'mfp volume' changes it
+                    until playback stops, then the saved volume is back
+  --mpv-arg=<arg>   Extra mpv argument for this session (repeatable), e.g.
+                    --mpv-arg=--af=bass=10. Overrides mpv_extra_args.
+  --dry-run         Print the songs in the order they would play, taking the
+                    other options into account, without starting playback
+                    or changing any state
+  --force           Kill a leftover mpv from a crashed session without asking
+  --background      Keep playing after this command returns; output goes
+                    to ~/.mfp/daemon.log
+
+Examples:
+  mfp play rock
+  mfp play rock --background
+  mfp play rock --random-start
+  mfp play rock --from 10 --to 20
+  mfp play lectures --at 5:1:30
+  mfp play chill --volume 40
+  mfp play
+  mfp play --restart
+`,
+	"play-search": `
+Usage: mfp play-search <query> [--count N] [--force] [--background]
+
+Search YouTube and play the results (10 by default, at most 50) as a
+one-off session, without saving a playlist. Next, previous, volume, shuffle
+and the rest work as usual until you play something else. Like 'mfp play',
+it plays in the foreground unless given --background.
+
+Examples:
+  mfp play-search lofi hip hop
+  mfp play-search "synthwave mix" --count 5
+`,
+	"stop": `
+Usage: mfp stop
+
+Stop playback and quit mpv.
+`,
+	"next": `
+Usage: mfp next [count]
+
+Skip to the next song, or forward <count> songs. Past the end of the
+playlist playback stops, unless loop is on, in which case it wraps
+around to the start. A skip less than 0.3s after the previous one is
+ignored, so use a count rather than repeating the command quickly.
+
+Examples:
+  mfp next
+  mfp next 3
+`,
+	"previous": `
+Usage: mfp prev [count]
+       mfp previous [count]
+
+Go back to the previous song, or back <count> songs. Before the start of
+the playlist it stays on the first song, unless loop is on, in which
+case it wraps around to the end.
+
+Examples:
+  mfp prev
+  mfp prev 2
+`,
+	"current": `
+Usage: mfp current [--verbose]
+       mfp now [--verbose]
+
+Show the current song's title, duration, playlist position and, while
+playing, the elapsed time. --verbose adds the stream's codec, bitrate,
+sample rate and channels as reported by mpv.
+`,
+	"chapters": `
+Usage: mfp chapters
+       mfp chapter <number>
+
+Long mixes on YouTube often have chapters. 'mfp chapters' lists the
+current song's chapters with their start times, and 'mfp chapter <n>'
+jumps to one. 'mfp current' shows the chapter that's playing.
+
+Examples:
+  mfp chapters
+  mfp chapter 4
+`,
+	"lyrics": `
+Usage: mfp lyrics
+
+Look up and print the lyrics of the current song, by its title and artist.
+Lyrics come from LRCLIB (lrclib.net) unless the lyrics_api setting points
+at another LRCLIB-compatible API; lyrics_key is sent as a bearer token if
+set. Found lyrics are cached in ~/.mfp/lyrics.
+`,
+	"art": `
+Usage: mfp art [--width N] [--no-color]
+
+Draw the current song's YouTube thumbnail in the terminal, 48 characters
+wide unless --width says otherwise. It uses 24-bit color, which most
+terminals support; --no-color (or output that isn't a terminal) draws it
+in grayscale blocks instead. Thumbnails are cached in ~/.mfp/thumbs,
+shared with the song change notifications.
+
+Examples:
+  mfp art
+  mfp art --width 80
+  mfp art --no-color
+`,
+	"schedule-view": `
+Usage: mfp schedule-view [count]
+
+Show the clock time each of the next songs (10 by default) should start,
+from the current position and the song lengths. Songs after one with an
+unknown length get "~" since their times can't be worked out.
+
+Examples:
+  mfp schedule-view
+  mfp schedule-view 30
+`,
+	"time-left": `
+Usage: mfp time-left
+
+Show how long until the playlist ends: the rest of the current song plus
+every song after it in play order (shuffled or not), queue-playlist songs,
+and the passes left of 'mfp loop <times>'. Skipped songs don't count. With
+loop on and no number of passes, the playlist never ends.
+
+Songs without a known length are counted separately, as in
+"Playlist ends in ~1h 12m + 2 songs of unknown length".
+
+Examples:
+  mfp time-left
+`,
+	"queue": `
+Usage: mfp queue [count]
+       mfp queue list
+       mfp queue clear
+       mfp queue remove <n>
+
+Show the previous and upcoming songs around the current one, in play
+order (shuffle order when shuffle is on). Shows 5 on each side by default.
+Each upcoming song shows its length and roughly how long until it starts.
+
+list, clear and remove work on the songs queued with 'mfp queue-playlist'
+only; the playlist itself is never changed. Numbers are the ones shown by
+'mfp queue list'.
+
+Examples:
+  mfp queue
+  mfp queue 10
+  mfp queue list
+  mfp queue remove 3
+`,
+	"queue-after": `
+Usage: mfp queue-after <youtube_video_url>
+
+Fetch a single YouTube video and insert it into the current playlist right
+after the song that's playing, so it plays next. The song is saved in the
+playlist.
+
+Examples:
+  mfp queue-after "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+`,
+	"pause-after-track": `
+Usage: mfp pause-after-track
+
+Let the current song finish, then pause instead of moving on, for stopping
+at a natural break. The next song is loaded and paused at its start, so
+'mfp play' picks up from there. Skipping ahead doesn't pause; it then
+waits for the new song to end. Run it again before the song ends to
+cancel; it's cleared once it has paused.
+
+Examples:
+  mfp pause-after-track
+`,
+	"interject": `
+Usage: mfp interject <youtube_video_url>
+
+Play a YouTube video right away as a one-off, then go back to the song it
+interrupted at the exact spot. The video isn't saved in the playlist and
+doesn't count as a playlist song. 'mfp next' while it plays skips it and
+resumes the playlist.
+
+Examples:
+  mfp interject "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+`,
+	"queue-playlist": `
+Usage: mfp queue-playlist <playlist>
+       mfp queue-playlist --clear
+
+Queue all songs of another playlist to play after the current playlist
+finishes. Nothing is saved: the queued songs are dropped when playback
+stops, and neither playlist is changed.
+
+--clear removes the queued songs again (unless one of them is playing).
+
+Examples:
+  mfp queue-playlist chill
+  mfp queue-playlist --clear
+`,
+	"jump": `
+Usage: mfp jump <song_number|+N|-N>
+
+Jump to a song by its number in the playlist (as shown by 'mfp songs').
+
++N and -N move that many songs forward or back in play order (shuffled if
+shuffle is on). Unlike 'next N' and 'prev N' they never wrap around: a
+jump past either end stops at the first or last song.
+
+Examples:
+  mfp jump 5
+  mfp jump +3
+  mfp jump -2
+`,
+	"continue-from": `
+Usage: mfp continue-from <song_number> [--background]
+
+Play the current playlist from song <song_number> (as shown by
+'mfp songs') to its end, for picking up where you left off in a long one.
+The earlier songs are left out of this session without changing the
+playlist; playback stops after the last song unless loop is on. Same as
+'mfp play <playlist> --from <song_number>'.
+
+Examples:
+  mfp continue-from 15
+`,
+	"random": `
+Usage: mfp random
+
+Jump to a random song in the current playlist, like 'mfp jump' with a
+number picked for you. Playback then continues in order from there (or in
+shuffle order when shuffle is on).
+`,
+	"shuffle": `
+Usage: mfp shuffle [on|off]
+       mfp shuffle reshuffle-on-loop [on|off]
+
+Toggle shuffle, or set it explicitly. Turning shuffle on generates a new
+random play order.
+
+With reshuffle-on-loop on, a shuffled playlist that's also looping gets a
+fresh random order each time it wraps around, instead of repeating the
+same sequence.
+
+Examples:
+  mfp shuffle on
+  mfp shuffle reshuffle-on-loop on
+`,
+	"loop": `
+Usage: mfp loop [on|off|inf|<times>]
+
+Toggle looping of the whole playlist, or set it explicitly. With a number,
+the playlist plays that many times in all and then stops; the pass that's
+playing counts as the first. 'inf' (same as 'on') loops forever.
+
+Examples:
+  mfp loop on
+  mfp loop 3
+  mfp loop off
+`,
+	"volume": `
+Usage: mfp volume [up|down|reset|<0-100>]
+       mfp vol [+|-|reset|<0-100>]
+
+Show the volume, step it up or down by 10, or set it to a percentage.
+'reset' goes back to the default_volume setting (70 unless changed).
+
+Examples:
+  mfp volume
+  mfp volume 80
+  mfp vol +
+  mfp volume reset
+`,
+	"seek": `
+Usage: mfp seek [+|-]<seconds>|<percent>% [--allow-overflow]
+
+Seek within the current song. Values are whole seconds.
+  <seconds>    Absolute: jump to that time from the start of the song
+  +<seconds>   Relative: skip forward
+  -<seconds>   Relative: skip backward
+  <percent>%   Absolute: jump to that point of the song (0-100%)
+
+Seeks are kept between the start and the last second of the song, so a
+large jump doesn't skip into the next song.
+
+Options:
+  --allow-overflow    Don't clamp; seeking past the end moves to the next song
+
+Examples:
+  mfp seek 90     Jump to 1:30
+  mfp seek +30    Skip ahead 30 seconds
+  mfp seek -10    Go back 10 seconds
+  mfp seek 50%    Jump to the middle of the song
+`,
+	"list": `
+Usage: mfp list [--tag <tag>] [--sort name|updated|songs] [--duplicates]
+       mfp playlists
+
+List saved playlists with their song counts and labels, in the order set
+with 'mfp reorder' (alphabetical for the rest).
+
+Options:
+  --tag <tag>      Only show playlists with this tag
+  --sort name      Sort alphabetically
+  --sort updated   Most recently added/refreshed first
+  --sort songs     Most songs first
+  --duplicates     Only show playlists saved more than once under
+                   different names (same YouTube list= ID)
+
+Examples:
+  mfp list --tag rock
+  mfp list --sort updated
+  mfp list --duplicates
+`,
+	"songs": `
+Usage: mfp songs <playlist> [--group-by artist] [--notes]
+
+List all songs in a playlist with their numbers and durations. For the
+playlist that is playing, ▶ marks the current song and ✓ the songs
+already played since 'mfp play'.
+
+With --group-by artist, songs are grouped under their artist (or channel),
+biggest groups first, to see what a playlist is made of. --notes shows
+the notes left with 'mfp note' under their songs.
+
+Examples:
+  mfp songs rock
+  mfp songs rock --group-by artist
+  mfp songs rock --notes
+`,
+	"note": `
+Usage: mfp note <playlist> <song_number> "text"
+       mfp note <playlist> <song_number> [--clear]
+
+Leave a personal note on a song, e.g. "skip to 2:00". Notes are kept with
+the playlist (also across refreshes) and shown by 'mfp current' and
+'mfp songs <playlist> --notes'. Without text, shows the song's note;
+--clear removes it.
+
+Examples:
+  mfp note rock 4 "great intro"
+  mfp note rock 4 --clear
+`,
+	"rename": `
+Usage: mfp rename <old_name> <new_name>
+
+Rename a playlist. The currently loaded playlist keeps playing.
+`,
+	"skip-always": `
+Usage: mfp skip-always <playlist> <song_number>
+       mfp skip-always [playlist] --clear
+
+Mark a song (an intro, an ad, a track you never want) to be skipped
+automatically whenever the playlist plays. Running it again on a marked
+song unmarks it. Marked songs are shown dimmed with [skipped] in
+'mfp songs'.
+
+--clear removes every mark in the playlist, or in all playlists when no
+playlist is given.
+
+Examples:
+  mfp skip-always rock 1
+  mfp skip-always rock --clear
+`,
+	"rename-song": `
+Usage: mfp rename-song <new_title>
+
+Change the stored title of the song that is playing now (or would resume
+next), without looking up its playlist and number. The new title shows up
+in 'mfp songs', 'mfp current' and mpv right away.
+
+Examples:
+  mfp rename-song "Artist - Better Title"
+`,
+	"delete": `
+Usage: mfp delete <playlist> [--yes]
+       mfp remove <playlist> [--yes]
+
+Delete a saved playlist. Stops playback if it's the one playing. In a
+terminal it asks first, showing the playlist's name and song count; --yes
+skips the question.
+`,
+	"pin": `
+Usage: mfp pin <playlist>
+       mfp unpin
+
+Pin a "home" playlist: 'mfp play' without a playlist name plays it, even
+after you've played other playlists (the last-played one is otherwise
+resumed). It starts from the top unless it was also the last one played.
+'mfp play' while something is playing doesn't switch playlists.
+
+Examples:
+  mfp pin focus
+  mfp unpin
+`,
+	"reorder": `
+Usage: mfp reorder <playlist> <position>
+       mfp move-playlist <playlist> <position>
+
+Set where a playlist appears in 'mfp list' (1 is the top). The other
+playlists keep their relative order. New playlists are added at the end.
+
+Examples:
+  mfp reorder focus 1
+`,
+	"tag": `
+Usage: mfp tag <playlist> [label] [tags...]
+       mfp tag <playlist> --clear
+
+Set a short emoji label and tags on a playlist. A first value made only of
+emoji/symbols becomes the label; the rest are added as tags.
+
+Examples:
+  mfp tag rock 🎸 rock classic
+  mfp tag chill --clear
+`,
+	"status": `
+Usage: mfp status [--oneline]
+
+Show volume, shuffle and loop settings and the loaded playlist. While mpv
+is waiting for the network, a "Buffering… N%" line shows how full its cache
+is. A stall of more than 10 seconds is logged by the player (and sent as a
+desktop notification when notify is on).
+
+Options:
+  --oneline    Print a compact single line for shell prompts or tmux, e.g.
+               ▶ rock 3/42 | Song Title | 1:12/3:45 | 70% S L
+               (▶ playing, ⏸ stopped, S shuffle, L loop)
+
+Examples:
+  mfp status --oneline
+`,
+	"eq": `
+Usage: mfp eq
+       mfp eq bass <dB>
+       mfp eq treble <dB>
+       mfp eq reset
+
+Boost or cut bass and treble, from -20 to +20 dB (0 is flat). Changes
+apply right away while playing and are kept for the next sessions. They
+are added on top of any --af filters from mpv_extra_args. Without
+arguments, shows the current EQ.
+
+Examples:
+  mfp eq bass +5
+  mfp eq treble -3
+  mfp eq reset
+`,
+	"mirror": `
+Usage: mfp mirror <device>
+       mfp mirror devices
+       mfp mirror off
+
+Experimental: play along on a second audio device, e.g. speakers in
+another room. A second mpv follows the main player's song, position,
+pause and volume. It is only roughly in sync (within about 2 seconds),
+since each player streams and buffers on its own. The mirror stops with
+playback. Device names are the ones 'mfp mirror devices' lists.
+
+Examples:
+  mfp mirror devices
+  mfp mirror pulse/bluez_sink.XX_XX_XX_XX_XX_XX.a2dp_sink
+  mfp mirror off
+`,
+	"limit": `
+Usage: mfp limit [<duration>|off]
+
+Stop playback once it has played for the given time, counted from now,
+e.g. for a focused work block. Only playing time counts: paused stretches
+don't use it up. The limit lasts until it runs out or playback stops.
+Without arguments, shows the time left ('mfp status' shows it too).
+
+Examples:
+  mfp limit 2h
+  mfp limit 1h30m
+  mfp limit off
+`,
+	"skip-silence": `
+Usage: mfp skip-silence [on|off] [--min <seconds>]
+
+Seek forward past silent gaps inside songs, e.g. the pauses between
+tracks in a recorded live set. mpv detects the silence; once it has lasted
+--min seconds (default 5) mfp jumps ahead 10 seconds at a time until the
+sound comes back. Changes apply right away while playing. Without
+arguments, shows the current setting.
+
+Examples:
+  mfp skip-silence on
+  mfp skip-silence on --min 8
+  mfp skip-silence off
+`,
+	"quiet-hours": `
+Usage: mfp quiet-hours [HH:MM-HH:MM|off]
+
+Keep it down during a daily time window, e.g. at night in a shared space.
+While playing inside the window, the volume is capped at quiet_volume
+(default 20%) and restored when the window ends. Set quiet_volume to
+'pause' to pause playback at the start of the window instead (it resumes
+when the window ends). Windows may cross midnight.
+
+Without arguments, shows the current setting.
+
+Examples:
+  mfp quiet-hours 22:00-07:00
+  mfp config set quiet_volume 10
+  mfp quiet-hours off
+`,
+	"url": `
+Usage: mfp url [<playlist> <song_number>]
+
+Print the YouTube URL of the current song, or of any song in a playlist,
+and nothing else, so it can be piped to other tools.
+
+Examples:
+  xdg-open "$(mfp url)"
+  mfp url | pbcopy
+  mfp url rock 12
+`,
+	"events": `
+Usage: mfp events
+
+Print the player's events as they happen, one JSON object per line, until
+playback stops or you press Ctrl+C. Handy for widgets and scripts that
+want to react right away instead of polling 'mfp status'.
+
+Events: song-change, play, pause, stop, volume-change, seek. Every event
+has "event" and "time"; song-change adds playlist, number, title, artist,
+video_id, url and duration, the others volume or position as fits.
+
+Examples:
+  mfp events
+  mfp events | jq -r 'select(.event == "song-change") | .title'
+`,
+	"serve": `
+Usage: mfp serve [--addr host:port] [--token secret]
+
+Serve a small HTTP API so a phone or web page can control playback. It
+listens on 127.0.0.1:8080 unless --addr says otherwise; use --addr :8080
+to reach it from other devices on your network.
+
+With --token (or MFP_TOKEN), every request must send the token as
+'Authorization: Bearer <token>'. POST requests must be sent with
+'Content-Type: application/json', even without a body.
+
+Endpoints (JSON in and out):
+  GET  /status                   Playlist, song, position, volume, ...
+  POST /play [{"playlist": name}]
+  POST /stop, /next, /prev, /pause, /resume
+  POST /volume {"value": 50}     Also "up", "down" or "reset"
+  POST /seek {"value": "+30"}
+
+POST endpoints run the matching mfp command and return its output.
+
+Examples:
+  mfp serve --addr :8080 --token hunter2
+  curl -X POST -H 'Authorization: Bearer hunter2' -H 'Content-Type: application/json' -d '{"value": 40}' localhost:8080/volume
+`,
+	"pid": `
+Usage: mfp pid
+
+Print the PID of the mfp process that owns mpv while playing.
+It accepts signals, which is handy for binding keyboard media keys:
+  SIGUSR1    Next song
+  SIGUSR2    Previous song
+
+Examples:
+  kill -USR1 $(mfp pid)
+  kill -USR2 $(mfp pid)
+`,
+	"doctor": `
+Usage: mfp doctor
+
+Check that mpv, yt-dlp and socat are installed, that mpv is recent enough
+and that the data directory (~/.mfp) is writable.
+`,
+	"backup": `
+Usage: mfp backup <file.tar.gz>
+
+Archive playlists.json, state.json, history.json, blacklist.json and
+config.json into one file, e.g. to move mfp to another machine.
+Downloaded songs are not included.
+
+Examples:
+  mfp backup ~/mfp-backup.tar.gz
+  mfp --profile work backup work.tar.gz
+`,
+	"restore": `
+Usage: mfp restore <file.tar.gz> [--yes]
+
+Unpack a backup made by 'mfp backup' into the data directory, replacing
+the files it contains. The archive is checked first and nothing is
+overwritten if any file in it is damaged. Asks before replacing unless
+--yes is given. Playback must be stopped.
+
+Examples:
+  mfp restore ~/mfp-backup.tar.gz
+`,
+	"debug": `
+Usage: mfp debug m3u <playlist> [file.m3u]
+
+Build the M3U playlist file that mfp hands to mpv and print it, or write
+it to file.m3u. Entries are in play order, so with shuffle on they follow
+the shuffle order. For the playing playlist this is the file mpv got; for
+any other it's what 'mfp play' would build now. Useful when the play
+order looks wrong, and to attach to bug reports.
+
+Examples:
+  mfp debug m3u rock
+  mfp debug m3u rock /tmp/rock.m3u
+`,
+	"sync": `
+Usage: mfp sync
+
+Make mpv the source of truth: read its playlist, position in it, playback
+time and volume, and overwrite what mfp has saved, then list what changed
+(before → after). For when the saved state has drifted from the player,
+e.g. after controlling mpv directly over its socket. With shuffle on, the
+shuffle order is taken from mpv's playlist too.
+
+Playback has to be running; 'mfp repair' fixes the saved state without it.
+`,
+	"repair": `
+Usage: mfp repair
+
+Check playlists.json and state.json for broken references, e.g. a current
+playlist that was deleted, a song index past the end of the playlist or a
+shuffle order that no longer matches it. Fixes or resets what's wrong,
+rewrites both files and lists what it changed.
+
+A file that can't be parsed at all is moved aside to <name>.bak and started
+over. Stop playback first.
+`,
+	"config": `
+Usage: mfp config get <key>
+       mfp config set <key> <value>
+       mfp config unset <key>
+       mfp config show
+
+View or change settings stored in ~/.mfp/config.json. 'config show' prints
+every setting's effective value and whether it comes from the config file
+or is the default, along with the paths and programs mfp uses.
+
+Keys:
+  cookies    Optional cookies file (Netscape format) passed to yt-dlp and
+             mpv so age-restricted or members-only videos can be played.
+             Ignored with a warning if the file doesn't exist.
+  on_song_change, on_play, on_stop
+             Executable run (in the background) when the song changes,
+             playback starts or playback stops. It receives MFP_EVENT,
+             MFP_TITLE, MFP_ARTIST, MFP_VIDEO_ID, MFP_URL, MFP_DURATION,
+             MFP_INDEX and MFP_PLAYLIST as environment variables.
+  notify     on/off: desktop notification (notify-send) with the song's
+             thumbnail when the song changes. Thumbnails are cached in
+             ~/.mfp/thumbs.
+  media_title_playlist
+             on/off: show "playlist: title" instead of just the title as
+             mpv's media title (mpv always shows the title stored by mfp)
+  mpv_extra_args
+             Extra mpv arguments added to every playback, separated by
+             spaces. They come after mfp's own arguments so they override
+             them, and 'mfp play --mpv-arg' overrides these in turn.
+             --input-ipc-server, --playlist, --playlist-start and --shuffle
+             are managed by mfp and can't be set.
+  m3u_entry  Template for each song in the playlist file handed to mpv,
+             for extra per-entry directives. Placeholders: {url}, {title},
+             {artist}, {display_title}, {video_id}, {duration} (seconds,
+             -1 if unknown); \n starts a new line. {url} must be on a line
+             of its own and every other line must start with #.
+             (default: #EXTINF:-1,{display_title}\n{url}; check the result
+             with 'mfp debug m3u')
+  fade       Seconds (0-30) to fade the volume in when playback starts and
+             out on 'mfp stop'. Skipping to another song during the fade-in
+             jumps to full volume; changing the volume ends the fade.
+  lyrics_api Base URL of the LRCLIB-compatible API used by 'mfp lyrics'
+             (default: https://lrclib.net/api)
+  lyrics_key Optional API key for lyrics_api, sent as a bearer token
+  history_max, history_days
+             Keep at most this many history entries / days of history
+             (default 0: keep everything)
+  ytdlp_rate Most YouTube fetches (add, refresh, search, download) per
+             minute or hour, shared by all running mfp commands, e.g. 20/m,
+             300/h or off (default: off). Set it for scripted bulk imports
+             so they don't get your IP throttled.
+  default_volume
+             Volume that 'mfp volume reset' goes back to (default: 70)
+  quiet_hours, quiet_volume
+             Daily window like 22:00-07:00 and the volume cap in it (or
+             'pause'), see 'mfp help quiet-hours'
+  skip_silence, skip_silence_min
+             Seek past silent gaps inside songs, once they last this many
+             seconds (default: off, 5), see 'mfp help skip-silence'
+  cache_max_mb
+             Most megabytes that downloads and thumbnails may take up; the
+             least recently used files go first (default: 0, no limit), see
+             'mfp help cache'
+  position_save_interval
+             Seconds between saves of the playback position while playing,
+             so a crash loses at most that much (default: 10)
+  format     yt-dlp format used for streaming (default: bestaudio/best).
+             Must be one of:
+               bestaudio/best, bestaudio, bestaudio[ext=m4a]/bestaudio,
+               bestaudio[ext=webm]/bestaudio, bestaudio[abr<=128]/bestaudio,
+               bestaudio[abr<=64]/worstaudio, worstaudio, best
+
+Examples:
+  mfp config set cookies ~/cookies.txt
+  mfp config set format "bestaudio[abr<=64]/worstaudio"
+  mfp config set on_song_change ~/bin/scrobble.sh
+  mfp config set mpv_extra_args "--af=loudnorm --cache=yes"
+  mfp config set fade 3
+  mfp config unset cookies
+`,
+	"help": `
+Usage: mfp help [command]
+
+Show the list of commands, or detailed help for one command.
+`,
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useTestConfig points mfp at an empty data directory for one test
+func useTestConfig(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	saved := config
+	cfg, err := initConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = cfg
+	t.Cleanup(func() { config = saved })
+}
+
+func TestReplaysCurrent(t *testing.T) {
+	useTestConfig(t)
+	config.State.CurrentPlaylist = "chill"
+	config.State.IsPlaying = true
+
+	tests := []struct {
+		name         string
+		args         []string
+		startOptions bool
+		session      bool
+		want         bool
+	}{
+		{"bare play", nil, false, false, true},
+		{"same playlist", []string{"chill"}, false, false, true},
+		{"other playlist", []string{"rock"}, false, false, false},
+		{"bare play with --at", nil, true, false, false},
+		{"same playlist with --volume", []string{"chill"}, true, false, false},
+		{"bare play during a range", nil, false, true, true},
+		{"same playlist during a range", []string{"chill"}, false, true, false},
+	}
+	for _, tt := range tests {
+		config.State.Session = nil
+		if tt.session {
+			config.State.Session = &Playlist{Name: "chill"}
+		}
+		if got := replaysCurrent(tt.args, tt.startOptions); got != tt.want {
+			t.Errorf("%s: replaysCurrent(%v, %v) = %v, want %v", tt.name, tt.args, tt.startOptions, got, tt.want)
+		}
+	}
+}
+
+// fakeMpv stands in for mpv's IPC socket: it answers get_property from
+// props and records every other command it's sent
+type fakeMpv struct {
+	props    map[string]interface{}
+	commands []string
+}
+
+func useFakeMpv(t *testing.T, props map[string]interface{}) *fakeMpv {
+	t.Helper()
+	mpv := &fakeMpv{props: props}
+	if err := os.WriteFile(config.SocketFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := mpvRequest
+	mpvRequest = func(socket, request string) ([]byte, error) {
+		var req struct {
+			Command []interface{} `json:"command"`
+		}
+		if err := json.Unmarshal([]byte(request), &req); err != nil {
+			return nil, err
+		}
+		if len(req.Command) == 2 && req.Command[0] == "get_property" {
+			value, ok := mpv.props[fmt.Sprint(req.Command[1])]
+			if !ok {
+				return []byte(`{"error": "property unavailable"}`), nil
+			}
+			return json.Marshal(map[string]interface{}{"data": value, "error": "success"})
+		}
+		var words []string
+		for _, arg := range req.Command {
+			words = append(words, fmt.Sprint(arg))
+		}
+		mpv.commands = append(mpv.commands, strings.Join(words, " "))
+		return []byte(`{"error": "success"}`), nil
+	}
+	t.Cleanup(func() { mpvRequest = saved })
+	return mpv
+}
+
+// useFakeStart records what handlePlay would start instead of starting mpv
+func useFakeStart(t *testing.T) *[]string {
+	t.Helper()
+	var started []string
+	saved := startPlayer
+	startPlayer = func(bool) {
+		started = append(started, config.State.CurrentPlaylist)
+	}
+	t.Cleanup(func() { startPlayer = saved })
+	return &started
+}
+
+func TestPlayTransitions(t *testing.T) {
+	playing := `{"current_playlist": "chill", "is_playing": true, "current_song_index": 1, "position": 42}`
+	tests := []struct {
+		name         string
+		state        string
+		paused       interface{} // nil: no mpv answers
+		args         []string
+		wantCommands []string
+		wantStarted  []string
+		wantPlaylist string
+	}{
+		{"playing, bare play shows the status", playing, false, nil, nil, nil, "chill"},
+		{"playing, same playlist shows the status", playing, false, []string{"chill"}, nil, nil, "chill"},
+		{"paused, bare play resumes", playing, true, nil, []string{"set pause no"}, nil, "chill"},
+		{"paused, same playlist resumes", playing, true, []string{"chill"}, []string{"set pause no"}, nil, "chill"},
+		{"playing, other playlist stops and loads it", playing, false, []string{"rock"}, []string{"quit"}, []string{"rock"}, "rock"},
+		{"stopped, bare play starts the last playlist", `{"current_playlist": "chill", "current_song_index": 1}`, nil, nil, nil, []string{"chill"}, "chill"},
+		{"crashed while playing, bare play starts again", playing, nil, nil, nil, []string{"chill"}, "chill"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestConfig(t)
+			writeTestData(t, testPlaylists, tt.state)
+			started := useFakeStart(t)
+			var mpv *fakeMpv
+			if tt.paused != nil {
+				mpv = useFakeMpv(t, map[string]interface{}{"pid": 4242.0, "pause": tt.paused, "time-pos": 42.0})
+			}
+
+			handlePlay(tt.args)
+
+			var commands []string
+			if mpv != nil {
+				commands = mpv.commands
+			}
+			if !slices.Equal(commands, tt.wantCommands) {
+				t.Errorf("sent mpv %q, want %q", commands, tt.wantCommands)
+			}
+			if !slices.Equal(*started, tt.wantStarted) {
+				t.Errorf("started %q, want %q", *started, tt.wantStarted)
+			}
+			if config.State.CurrentPlaylist != tt.wantPlaylist {
+				t.Errorf("current playlist %q, want %q", config.State.CurrentPlaylist, tt.wantPlaylist)
+			}
+		})
+	}
+}
+
+func TestOwnsMpvSession(t *testing.T) {
+	saved := currentCmd
+	t.Cleanup(func() { currentCmd = saved })
+
+	// A CLI command talking to the daemon's mpv never started one
+	currentCmd = nil
+	if ownsMpvSession() {
+		t.Error("ownsMpvSession() = true without an mpv command")
+	}
+
+	currentCmd = exec.Command("sleep", "5")
+	if ownsMpvSession() {
+		t.Error("ownsMpvSession() = true before mpv was started")
+	}
+
+	if err := currentCmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer currentCmd.Process.Kill()
+	if !ownsMpvSession() {
+		t.Error("ownsMpvSession() = false for the process that started mpv")
+	}
+}
+
+// writeTestData writes playlists.json and state.json as an earlier mfp run
+// would have left them, and loads them
+func writeTestData(t *testing.T, playlists, state string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(config.DataDir, "playlists.json"), []byte(playlists), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config.StateFile, []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig()
+}
+
+const testPlaylists = `{"chill": {"name": "chill", "songs": [
+	{"title": "One", "video_id": "aaaaaaaaaaa", "duration": "3:00"},
+	{"title": "Two", "video_id": "bbbbbbbbbbb", "duration": "3:00"},
+	{"title": "Three", "video_id": "ccccccccccc", "duration": "3:00"},
+	{"title": "Four", "video_id": "ddddddddddd", "duration": "3:00"}
+]}, "rock": {"name": "rock", "songs": [
+	{"title": "Five", "video_id": "eeeeeeeeeee", "duration": "3:00"},
+	{"title": "Six", "video_id": "fffffffffff", "duration": "3:00"}
+]}}`
+
+func TestResumeFromSavedState(t *testing.T) {
+	useTestConfig(t)
+	writeTestData(t, testPlaylists, `{"current_playlist": "chill", "current_song_index": 0,
+		"is_shuffle": true, "shuffle_order": [2, 0, 3, 1], "shuffle_index": 1, "position": 95}`)
+
+	restoreResumePoint(currentPlaylist())
+	if got := getCurrentSongIndex(); got != 0 {
+		t.Errorf("resumed at song index %d, want 0", got)
+	}
+	if config.State.ShuffleIndex != 1 || config.State.Position != 95 {
+		t.Errorf("resumed at shuffle index %d, %ds, want 1, 95s", config.State.ShuffleIndex, config.State.Position)
+	}
+	if got := playOrder(currentPlaylist()); !sameOrder(got, []int{2, 0, 3, 1}) {
+		t.Errorf("shuffle order = %v, want the saved [2 0 3 1]", got)
+	}
+}
+
+func TestResumeFromStaleState(t *testing.T) {
+	useTestConfig(t)
+	// The playlist lost songs since: the saved order and index don't fit it
+	writeTestData(t, testPlaylists, `{"current_playlist": "chill", "current_song_index": 7,
+		"is_shuffle": true, "shuffle_order": [5, 2, 0, 3, 1, 4], "shuffle_index": 4, "position": 95}`)
+
+	restoreResumePoint(currentPlaylist())
+	if len(config.State.ShuffleOrder) != 4 || config.State.ShuffleIndex != 0 {
+		t.Errorf("shuffle order %v at %d, want a new order of 4 songs from the start", config.State.ShuffleOrder, config.State.ShuffleIndex)
+	}
+	if config.State.CurrentSongIndex != 0 || config.State.Position != 0 {
+		t.Errorf("resumed at song index %d, %ds, want 0, 0s", config.State.CurrentSongIndex, config.State.Position)
+	}
+}
+
+func TestPlayLockSerializesStarts(t *testing.T) {
+	useTestConfig(t)
+
+	// Two 'mfp play' calls at once; the starter stands in for the daemon and
+	// comes up listening on the mpv socket like mpv does
+	var starts int32
+	var listener net.Listener
+	start := func() {
+		atomic.AddInt32(&starts, 1)
+		time.Sleep(50 * time.Millisecond) // mpv takes a moment to come up
+		l, err := net.Listen("unix", config.SocketFile)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		listener = l
+	}
+	var wg sync.WaitGroup
+	begin := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-begin
+			if _, err := startIfIdle(start); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(begin)
+	wg.Wait()
+	if listener != nil {
+		listener.Close()
+	}
+	if starts != 1 {
+		t.Errorf("mpv was started %d times, want 1", starts)
+	}
+}
+
+func TestRapidSkipsMoveOnce(t *testing.T) {
+	useTestConfig(t)
+
+	// Hammering 'mfp next': only the first skip within skipCooldown moves the
+	// index. Each handleNext reads the state back from state.json, as a
+	// separate 'mfp next' would.
+	tests := []struct {
+		name  string
+		state string
+		index func() int
+		want  int
+	}{
+		{"in order", `{"current_playlist": "chill", "is_playing": true, "current_song_index": 1}`,
+			func() int { return config.State.CurrentSongIndex }, 2},
+		{"shuffled", `{"current_playlist": "chill", "is_playing": true, "is_shuffle": true,
+			"shuffle_order": [2, 0, 3, 1], "shuffle_index": 1, "current_song_index": 0}`,
+			func() int { return config.State.ShuffleIndex }, 2},
+	}
+	for _, tt := range tests {
+		writeTestData(t, testPlaylists, tt.state)
+		for i := 0; i < 5; i++ {
+			handleNext(nil)
+		}
+		reloadConfig()
+		if got := tt.index(); got != tt.want {
+			t.Errorf("%s: index %d after 5 rapid skips, want %d", tt.name, got, tt.want)
+		}
+
+		// Once the cooldown is over the next skip goes through
+		config.State.LastSkip = time.Now().Add(-skipCooldown)
+		saveConfig()
+		handleNext(nil)
+		reloadConfig()
+		if got := tt.index(); got != tt.want+1 {
+			t.Errorf("%s: index %d after a skip past the cooldown, want %d", tt.name, got, tt.want+1)
+		}
+	}
+}